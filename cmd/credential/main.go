@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/freundallein/scheduler/pkg/adapters/credentials"
+)
+
+// Issues a credential: prints a client's token and an entry for the credentials file.
+// go run ./cmd/credential -name billing -scopes scheduler,worker -ttl 8760h
+func main() {
+	name := flag.String("name", "", "client name")
+	scopes := flag.String("scopes", string(domain.ScopeScheduler), "comma separated scopes: scheduler, worker, admin")
+	ttl := flag.Duration("ttl", 0, "credential lifetime, zero means it never expires")
+	flag.Parse()
+	if *name == "" || strings.Contains(*name, ":") {
+		fmt.Fprintln(os.Stderr, "name should be non-empty and shouldn't contain ':'")
+		os.Exit(2)
+	}
+	secret, err := credentials.NewSecret()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	credential := domain.Credential{
+		Name:       *name,
+		SecretHash: credentials.HashSecret(secret),
+	}
+	for _, scope := range strings.Split(*scopes, ",") {
+		if !domain.Scope(scope).Valid() {
+			fmt.Fprintf(os.Stderr, "unknown scope %q\n", scope)
+			os.Exit(2)
+		}
+		credential.Scopes = append(credential.Scopes, domain.Scope(scope))
+	}
+	if *ttl > 0 {
+		expiresAt := time.Now().Add(*ttl).UTC().Truncate(time.Second)
+		credential.ExpiresAt = &expiresAt
+	}
+	entry, err := json.Marshal(credential)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("token: %s:%s\n", *name, secret)
+	fmt.Printf("entry: %s\n", entry)
+}
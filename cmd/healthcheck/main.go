@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/freundallein/scheduler/pkg/utils"
 	"net/http"
@@ -8,12 +9,24 @@ import (
 )
 
 const (
-	opsPortKey = "OPS_PORT"
+	opsPortKey    = "OPS_PORT"
+	opsTLSCertKey = "OPS_TLS_CERT_FILE"
 )
 
 func main() {
 	opsPort := utils.GetEnv(opsPortKey, "8001")
-	_, err := http.Get(fmt.Sprintf("http://127.0.0.1:%s/ops/healthcheck", opsPort))
+	scheme := "http"
+	httpcli := http.DefaultClient
+	if utils.GetEnv(opsTLSCertKey, "") != "" {
+		// Local probe checks liveness, the certificate isn't issued for 127.0.0.1.
+		scheme = "https"
+		httpcli = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+	_, err := httpcli.Get(fmt.Sprintf("%s://127.0.0.1:%s/ops/healthcheck", scheme, opsPort))
 	if err != nil {
 		os.Exit(1)
 	}
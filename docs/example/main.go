@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	domain "github.com/freundallein/scheduler/pkg"
 	"github.com/freundallein/scheduler/pkg/client"
 	"github.com/freundallein/scheduler/pkg/utils"
@@ -15,38 +16,33 @@ const (
 	workerTokenKey = "WORKER_TOKEN"
 )
 
-func worker() {
+func worker(ctx context.Context) {
 	token := utils.GetEnv(workerTokenKey, "token")
 	worker := client.NewWorker(
 		"0.0.0.0:8000",
-		time.Second,
+		10*time.Second,
 		client.WithWorkerToken(token),
+		client.WithClaimWait(5*time.Second),
 	)
-	for {
-		tasks, err := worker.Claim(2)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"err": err,
-			}).Error("worker_claim_error")
-		}
-		for idx, task := range tasks {
-			payload := task.Payload
-			log.WithFields(log.Fields{
-				"uid":     task.ID,
-				"payload": payload,
-			}).Error("worker_processing_task")
-			//err := worker.Fail(task.ID, *task.ClaimID, "nobody is at home")
-			err := worker.Succeed(task.ID, *task.ClaimID, map[string]interface{}{
-				"idx": idx,
-			})
-			if err != nil {
-				log.WithFields(log.Fields{
-					"err": err,
-				}).Error("worker_fail_error")
-			}
-		}
-
-		time.Sleep(time.Millisecond * 100)
+	runner := client.NewRunner(
+		worker,
+		client.WithConcurrency(2),
+		client.WithPrefetch(2),
+	)
+	runner.Handle("parse", func(ctx context.Context, task *domain.Task) (map[string]interface{}, error) {
+		log.WithFields(log.Fields{
+			"uid":     task.ID,
+			"payload": task.Payload,
+		}).Info("worker_processing_task")
+		return map[string]interface{}{
+			"number": task.Payload["number"],
+		}, nil
+	})
+	err := runner.Run(ctx)
+	if err != nil && err != context.Canceled {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("worker_run_error")
 	}
 }
 
@@ -58,10 +54,12 @@ func main() {
 
 	service := client.NewScheduler(
 		"127.0.0.1:8000",
-		time.Second,
+		10*time.Second,
 		client.WithToken(token),
 	)
-	go worker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker(ctx)
 	uids := map[uuid.UUID]struct{}{}
 	for i := 0; i < 10; i++ {
 		uid, err := service.Set(time.Now(), time.Now().Add(time.Hour), map[string]interface{}{
@@ -82,11 +80,11 @@ func main() {
 	}
 	for len(uids) > 0 {
 		for uid := range uids {
-			task, err := service.Get(uid)
+			task, err := service.Wait(uid, 5*time.Second)
 			if err != nil {
 				log.WithFields(log.Fields{
 					"err": err,
-				}).Error("scheduler_get_failed")
+				}).Error("scheduler_wait_failed")
 				panic(err)
 			}
 			if task.State == domain.StateSucceeded {
@@ -102,7 +100,6 @@ func main() {
 					"state": task.State,
 				}).Info("task_is_processing")
 			}
-			time.Sleep(time.Millisecond * 100)
 		}
 	}
 
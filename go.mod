@@ -3,11 +3,14 @@ module github.com/freundallein/scheduler
 go 1.17
 
 require (
+	github.com/golang-jwt/jwt/v4 v4.3.0
 	github.com/google/uuid v1.3.0
 	github.com/jackc/pgx/v4 v4.13.0
 	github.com/oklog/run v1.1.0
 	github.com/prometheus/client_golang v1.11.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.8.1
+	golang.org/x/time v0.0.0-20220224211638-0e9765cccd65
 )
 
 require (
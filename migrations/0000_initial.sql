@@ -30,5 +30,3 @@ create table task (
 );
 
 create index task_state on task (execute_at, id) where state <> 'succeeded';
-
--- TODO: add failure table
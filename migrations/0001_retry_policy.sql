@@ -0,0 +1,7 @@
+-- Retry policy and exhausted tasks
+alter type task_state add value if not exists 'exhausted';
+
+alter table task add column if not exists retry_policy JSONB;
+
+drop index if exists task_state;
+create index task_claim on task (execute_at, id) where state in ('pending', 'processing', 'failed');
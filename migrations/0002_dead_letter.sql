@@ -0,0 +1,20 @@
+-- Dead letters for tasks, that have exhausted their attempts
+create index task_exhausted on task (id) where state = 'exhausted';
+
+drop table if exists dead_letter;
+create table dead_letter (
+	id uuid not null,
+	execute_at timestamp with time zone not null,
+	deadline timestamp with time zone not null,
+	payload JSONB not null,
+	retry_policy JSONB,
+	fail_reason text not null default '',
+	attempts integer not null default 0,
+	history JSONB not null default '[]',
+	meta JSONB not null default '{}',
+	created_at timestamp with time zone not null,
+	dead_at timestamp with time zone not null default current_timestamp,
+	primary key(id)
+);
+
+create index dead_letter_dead_at on dead_letter (dead_at, id);
@@ -0,0 +1,4 @@
+-- Deadline enforcement
+alter type task_state add value if not exists 'expired';
+
+create index task_deadline on task (deadline) where state in ('pending', 'processing', 'failed');
@@ -0,0 +1,4 @@
+-- Task cancellation
+alter type task_state add value if not exists 'cancelled';
+
+alter table task add column if not exists cancel_requested boolean not null default false;
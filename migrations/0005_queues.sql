@@ -0,0 +1,6 @@
+-- Named queues
+alter table task add column if not exists queue text not null default 'default';
+
+alter table dead_letter add column if not exists queue text not null default 'default';
+
+create index task_queue_claim on task (queue, execute_at, id) where state in ('pending', 'processing', 'failed');
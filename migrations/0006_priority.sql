@@ -0,0 +1,4 @@
+-- Task priorities
+alter table task add column if not exists priority integer not null default 0;
+
+alter table dead_letter add column if not exists priority integer not null default 0;
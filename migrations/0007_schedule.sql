@@ -0,0 +1,19 @@
+-- Recurring tasks
+drop table if exists schedule;
+create table schedule (
+	id uuid not null,
+	cron text not null,
+	timezone text not null default 'UTC',
+	queue text not null default 'default',
+	priority integer not null default 0,
+	payload JSONB not null,
+	retry_policy JSONB,
+	ttl interval not null,
+	misfire_policy text not null default 'once',
+	next_run_at timestamp with time zone not null,
+	last_run_at timestamp with time zone,
+	created_at timestamp with time zone not null default current_timestamp,
+	primary key(id)
+);
+
+create index schedule_next_run_at on schedule (next_run_at, id);
@@ -0,0 +1,14 @@
+-- Wake up long-polling workers, when a task becomes claimable
+create or replace function notify_task_claimable() returns trigger as $$
+begin
+	perform pg_notify('task_claimable', new.queue);
+	return new;
+end;
+$$ language plpgsql;
+
+drop trigger if exists task_claimable on task;
+create trigger task_claimable
+	after insert or update of state, execute_at on task
+	for each row
+	when (new.state in ('pending', 'failed'))
+	execute procedure notify_task_claimable();
@@ -0,0 +1,17 @@
+-- Notify about claimable and finished tasks on a single channel
+create or replace function notify_task_state() returns trigger as $$
+begin
+	perform pg_notify('task_state', json_build_object('id', new.id, 'queue', new.queue, 'state', new.state)::text);
+	return new;
+end;
+$$ language plpgsql;
+
+drop trigger if exists task_claimable on task;
+drop function if exists notify_task_claimable();
+
+drop trigger if exists task_state on task;
+create trigger task_state
+	after insert or update of state, execute_at on task
+	for each row
+	when (new.state <> 'processing')
+	execute procedure notify_task_state();
@@ -0,0 +1,49 @@
+-- Completion webhooks outbox
+alter table task add column if not exists callback_url text not null default '';
+alter table dead_letter add column if not exists callback_url text not null default '';
+
+drop table if exists webhook;
+create table webhook (
+	id bigserial not null,
+	task_id uuid not null,
+	url text not null,
+	payload JSONB not null,
+	state text not null default 'pending',
+	attempts integer not null default 0,
+	last_error text not null default '',
+	next_attempt_at timestamp with time zone not null default current_timestamp,
+	created_at timestamp with time zone not null default current_timestamp,
+	delivered_at timestamp with time zone,
+	primary key(id)
+);
+
+create index webhook_pending on webhook (next_attempt_at, id) where state = 'pending';
+
+create or replace function enqueue_task_webhook() returns trigger as $$
+begin
+	insert into webhook(task_id, url, payload)
+	values (new.id, new.callback_url, jsonb_build_object(
+		'id', new.id,
+		'state', new.state,
+		'queue', new.queue,
+		'payload', new.payload,
+		'result', new.result,
+		'failReason', COALESCE(new.meta->>'failReason', ''),
+		'attempts', COALESCE(new.meta->>'attempts', '0')::int,
+		'createdAt', new.created_at,
+		'doneAt', new.done_at
+	));
+	return new;
+end;
+$$ language plpgsql;
+
+drop trigger if exists task_webhook on task;
+create trigger task_webhook
+	after update of state on task
+	for each row
+	when (
+		new.callback_url <> ''
+		and new.state is distinct from old.state
+		and new.state in ('succeeded', 'exhausted', 'expired', 'cancelled')
+	)
+	execute procedure enqueue_task_webhook();
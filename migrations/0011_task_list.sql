@@ -0,0 +1,2 @@
+-- Tasks listing for admin API
+create index if not exists task_created_at on task (created_at, id);
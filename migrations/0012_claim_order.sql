@@ -0,0 +1,2 @@
+-- Claim order by priority, waiting tasks are aged while claimed
+create index if not exists task_priority_claim on task (priority desc, execute_at) where state in ('pending', 'processing', 'failed');
@@ -1,5 +1,13 @@
 package apiserv
 
+import (
+	"crypto/tls"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
 // Option is used to configure Service.
 type Option func(service *Service)
 
@@ -23,3 +31,87 @@ func WithWorkerToken(token string) Option {
 		s.WorkerToken = token
 	}
 }
+
+// WithAdminToken provides admin API access token, admin API is disabled without it.
+func WithAdminToken(token string) Option {
+	return func(s *Service) {
+		s.AdminToken = token
+	}
+}
+
+// WithAuthenticator makes Service accept credentials of the authenticator along with tokens,
+// admin API is enabled with it.
+func WithAuthenticator(authenticator domain.Authenticator) Option {
+	return func(s *Service) {
+		s.authenticator = authenticator
+	}
+}
+
+// WithBearerAuthenticator makes Service accept tokens of the "Authorization: Bearer" header,
+// e.g. signed JWTs, along with the legacy Auth header. Admin API is enabled with it.
+func WithBearerAuthenticator(authenticator domain.Authenticator) Option {
+	return func(s *Service) {
+		s.bearerAuthenticator = authenticator
+	}
+}
+
+// WithTLSConfig makes Service serve TLS, the config should provide certificates,
+// e.g. with GetCertificate to reload them.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Service) {
+		s.tlsConfig = config
+	}
+}
+
+// WithWorkerClientCerts makes worker API accept clients with certificates, that TLS config verified (mTLS),
+// a client is named after the certificate's common name. If certificates are required, tokens aren't accepted.
+// TLS config should verify client certificates with its own CAs.
+func WithWorkerClientCerts(required bool) Option {
+	return func(s *Service) {
+		s.workerCerts = true
+		s.workerCertsRequired = required
+	}
+}
+
+// WithTimeout limits a call, unless its method has own timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *Service) {
+		s.Timeout = timeout
+	}
+}
+
+// WithMethodTimeout limits calls of the method, e.g. "Scheduler.Wait".
+func WithMethodTimeout(method string, timeout time.Duration) Option {
+	return func(s *Service) {
+		s.MethodTimeouts[method] = timeout
+	}
+}
+
+// WithRequestsCancelled configures Service to use counter metrics.
+func WithRequestsCancelled(counter prometheus.Counter) Option {
+	return func(s *Service) {
+		s.requestsCancelled = counter
+	}
+}
+
+// WithRequestsTimedOut configures Service to use counter metrics.
+func WithRequestsTimedOut(counter prometheus.Counter) Option {
+	return func(s *Service) {
+		s.requestsTimedOut = counter
+	}
+}
+
+// WithRateLimits limits calls of every client, the most specific limit of a call is applied.
+// Rate limited calls fail with "rate_limited" error and retry after information.
+func WithRateLimits(limits ...RateLimit) Option {
+	return func(s *Service) {
+		s.rateLimits = append(s.rateLimits, limits...)
+	}
+}
+
+// WithRequestsRateLimited configures Service to count rejected calls by client and method of the matched rule.
+func WithRequestsRateLimited(counter *prometheus.CounterVec) Option {
+	return func(s *Service) {
+		s.requestsRateLimited = counter
+	}
+}
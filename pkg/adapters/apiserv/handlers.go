@@ -3,6 +3,7 @@ package apiserv
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -15,26 +16,89 @@ type Scheduler struct {
 	svc domain.Scheduler
 }
 
+// RetryParams describes a retry policy of a task.
+// Delays are Go duration strings, e.g. "30s" or "5m".
+type RetryParams struct {
+	MaxAttempts int     `json:"maxAttempts"`
+	BaseDelay   string  `json:"baseDelay"`
+	Multiplier  float64 `json:"multiplier"`
+	MaxDelay    string  `json:"maxDelay"`
+	Jitter      float64 `json:"jitter"`
+}
+
+// RetryPolicy validates params and converts them to domain.RetryPolicy.
+func (params *RetryParams) RetryPolicy() (*domain.RetryPolicy, error) {
+	if params == nil {
+		return nil, nil
+	}
+	policy := &domain.RetryPolicy{
+		MaxAttempts: params.MaxAttempts,
+		Multiplier:  params.Multiplier,
+		Jitter:      params.Jitter,
+	}
+	if policy.MaxAttempts < 0 {
+		return nil, invalidParams(fmt.Errorf("maxAttempts should not be negative"))
+	}
+	if policy.Multiplier != 0 && policy.Multiplier < 1 {
+		return nil, invalidParams(fmt.Errorf("multiplier should not be less than 1"))
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return nil, invalidParams(fmt.Errorf("jitter should be between 0 and 1"))
+	}
+	var err error
+	if params.BaseDelay != "" {
+		policy.BaseDelay, err = time.ParseDuration(params.BaseDelay)
+		if err != nil {
+			return nil, invalidParams(err)
+		}
+	}
+	if params.MaxDelay != "" {
+		policy.MaxDelay, err = time.ParseDuration(params.MaxDelay)
+		if err != nil {
+			return nil, invalidParams(err)
+		}
+	}
+	if policy.BaseDelay < 0 || policy.MaxDelay < 0 {
+		return nil, invalidParams(fmt.Errorf("delays should not be negative"))
+	}
+	return policy, nil
+}
+
+// validateCallbackURL checks, that webhooks can be posted to a callback URL.
+func validateCallbackURL(callbackURL string) error {
+	if len(callbackURL) > 2048 {
+		return invalidParams(fmt.Errorf("callback url should be under 2049 characters"))
+	}
+	parsed, err := url.Parse(callbackURL)
+	if err != nil {
+		return invalidParams(err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return invalidParams(fmt.Errorf("callback url should be an absolute http(s) url"))
+	}
+	return nil
+}
+
 // SetParams describes input params for Set procedure.
 type SetParams struct {
-	ID        uuid.UUID `json:"id"`
-	ExecuteAt time.Time `json:"executeAt"`
-	Deadline  time.Time `json:"deadline"`
-	Payload   map[string]interface{}
+	ID          uuid.UUID `json:"id"`
+	ExecuteAt   time.Time `json:"executeAt"`
+	Deadline    time.Time `json:"deadline"`
+	Queue       string    `json:"queue"`
+	Priority    int       `json:"priority"`
+	Payload     map[string]interface{}
+	RetryPolicy *RetryParams `json:"retryPolicy"`
+	CallbackURL string       `json:"callbackURL"`
 }
 
 // Set accepts task that should be executed.
-// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Scheduler.Set", "params":[{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3", "executeAt":"2021-10-14T18:32:11+03:00","deadline":"2021-11-14T18:32:11+03:00","payload": {"type":"parse", "source": "example.com"}}], "id": "1"}' http://0.0.0.0:8000/rpc/v0
-func (handler *Scheduler) Set(params *SetParams, result *map[string]interface{}) error {
-	task := &domain.Task{
-		ID:        params.ID,
-		ExecuteAt: params.ExecuteAt.UTC(),
-		Deadline:  params.Deadline.UTC(),
-		Payload:   params.Payload,
-		Meta:      map[string]interface{}{},
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Scheduler.Set", "params":{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3", "executeAt":"2021-10-14T18:32:11+03:00","deadline":"2021-11-14T18:32:11+03:00","payload": {"type":"parse", "source": "example.com"}}, "id": "1"}' http://0.0.0.0:8000/rpc/v0
+func (handler *Scheduler) Set(ctx context.Context, params *SetParams, result *map[string]interface{}) error {
+	task, err := params.Task()
+	if err != nil {
+		return err
 	}
-	ctx := context.Background()
-	task, err := handler.svc.Set(ctx, task)
+	task, err = handler.svc.Set(ctx, task)
 	if err != nil {
 		return err
 	}
@@ -44,15 +108,96 @@ func (handler *Scheduler) Set(params *SetParams, result *map[string]interface{})
 	return nil
 }
 
+// Task validates params and returns a task to enqueue.
+func (params *SetParams) Task() (*domain.Task, error) {
+	if len(params.Queue) > 255 {
+		return nil, invalidParams(fmt.Errorf("queue name should be under 256 characters"))
+	}
+	if params.Priority < -domain.MaxPriority || params.Priority > domain.MaxPriority {
+		return nil, invalidParams(fmt.Errorf("priority should be between %d and %d", -domain.MaxPriority, domain.MaxPriority))
+	}
+	policy, err := params.RetryPolicy.RetryPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if params.CallbackURL != "" {
+		err = validateCallbackURL(params.CallbackURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &domain.Task{
+		ID:          params.ID,
+		ExecuteAt:   params.ExecuteAt.UTC(),
+		Deadline:    params.Deadline.UTC(),
+		Queue:       params.Queue,
+		Priority:    params.Priority,
+		Payload:     params.Payload,
+		RetryPolicy: policy,
+		CallbackURL: params.CallbackURL,
+		Meta:        map[string]interface{}{},
+	}, nil
+}
+
+// SetManyParams describes input params for SetMany procedure.
+type SetManyParams struct {
+	Tasks []*SetParams `json:"tasks"`
+}
+
+// SetMany accepts a batch of tasks, that are enqueued atomically.
+// Duplicates are reported per task and don't fail the batch.
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Scheduler.SetMany", "params":{"tasks":[{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3", "executeAt":"2021-10-14T18:32:11+03:00","deadline":"2021-11-14T18:32:11+03:00","payload": {"type":"parse", "source": "example.com"}}]}, "id": "1"}' http://0.0.0.0:8000/rpc/v0
+func (handler *Scheduler) SetMany(ctx context.Context, params *SetManyParams, result *map[string]interface{}) error {
+	if len(params.Tasks) == 0 || len(params.Tasks) > 1000 { // Hardcoded batch size
+		return invalidParams(fmt.Errorf("tasks amount should be between 1 and 1000"))
+	}
+	tasks := make([]*domain.Task, 0, len(params.Tasks))
+	for i, taskParams := range params.Tasks {
+		if taskParams == nil {
+			return invalidParams(fmt.Errorf("tasks[%d]: task is empty", i))
+		}
+		task, err := taskParams.Task()
+		if err != nil {
+			return invalidParams(fmt.Errorf("tasks[%d]: %w", i, err))
+		}
+		tasks = append(tasks, task)
+	}
+	results, err := handler.svc.SetMany(ctx, tasks)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"results": batchResults(results),
+	}
+	return nil
+}
+
+// batchResults represents batch results with per-item errors.
+func batchResults(results []domain.BatchResult) []map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(results))
+	for _, result := range results {
+		item := map[string]interface{}{
+			"id": result.ID,
+		}
+		if result.Err != nil {
+			item["error"] = map[string]interface{}{
+				"code":    domain.ErrorCode(result.Err),
+				"message": result.Err.Error(),
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
 // GetParams describes input params for Get procedure.
 type GetParams struct {
 	ID uuid.UUID `json:"id"`
 }
 
 // Get should be used for task state polling.
-// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Scheduler.Get", "params":[{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3"}], "id": "1"}' http://0.0.0.0:8000/rpc/v0
-func (handler *Scheduler) Get(params *GetParams, result *map[string]interface{}) error {
-	ctx := context.Background()
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Scheduler.Get", "params":{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3"}, "id": "1"}' http://0.0.0.0:8000/rpc/v0
+func (handler *Scheduler) Get(ctx context.Context, params *GetParams, result *map[string]interface{}) error {
 	task, err := handler.svc.Get(ctx, params.ID)
 	if err != nil {
 		return err
@@ -64,6 +209,51 @@ func (handler *Scheduler) Get(params *GetParams, result *map[string]interface{})
 	return nil
 }
 
+// Cancel withdraws a task. Processing task is cancelled when its worker reports back.
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Scheduler.Cancel", "params":{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3"}, "id": "1"}' http://0.0.0.0:8000/rpc/v0
+func (handler *Scheduler) Cancel(ctx context.Context, params *GetParams, result *map[string]interface{}) error {
+	task, err := handler.svc.Cancel(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"task": task,
+	}
+	return nil
+}
+
+// WaitParams describes input params for Wait procedure.
+type WaitParams struct {
+	ID      uuid.UUID `json:"id"`
+	Timeout string    `json:"timeout"`
+}
+
+// Wait blocks until a task is finished or timeout expires.
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Scheduler.Wait", "params":{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3", "timeout":"20s"}, "id": "1"}' http://0.0.0.0:8000/rpc/v0
+func (handler *Scheduler) Wait(ctx context.Context, params *WaitParams, result *map[string]interface{}) error {
+	var timeout time.Duration
+	var err error
+	if params.Timeout != "" {
+		timeout, err = time.ParseDuration(params.Timeout)
+		if err != nil {
+			return invalidParams(err)
+		}
+	}
+	if timeout < 0 || timeout > 30*time.Second { // Hardcoded long polling limit
+		return invalidParams(fmt.Errorf("timeout should be between 0s and 30s"))
+	}
+	task, err := handler.svc.Wait(ctx, params.ID, timeout)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"task":     task,
+		"meta":     task.Meta,
+		"finished": task.State.Finished(),
+	}
+	return nil
+}
+
 // Worker is a JSON RPC handler.
 type Worker struct {
 	svc domain.Worker
@@ -71,21 +261,45 @@ type Worker struct {
 
 // ClaimParams describes input params for Claim procedure.
 type ClaimParams struct {
-	Amount string `json:"amount"`
+	Amount string   `json:"amount"`
+	Queues []string `json:"queues"`
+	Lease  string   `json:"lease"`
+	Wait   string   `json:"wait"`
 }
 
 // Claim is for claiming one task or more for processing.
-// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Worker.Claim", "params":[{"amount":"3"}], "id": "1"}' http://0.0.0.0:8000/worker/v0
-func (handler *Worker) Claim(params *ClaimParams, result *map[string]interface{}) error {
-	ctx := context.Background()
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Worker.Claim", "params":{"amount":"3", "queues":["pdf"], "wait":"20s"}, "id": "1"}' http://0.0.0.0:8000/worker/v0
+func (handler *Worker) Claim(ctx context.Context, params *ClaimParams, result *map[string]interface{}) error {
 	amount, err := strconv.Atoi(params.Amount)
 	if err != nil {
-		return err
+		return invalidParams(err)
 	}
 	if amount >= 100 { // Hardcoded batch size
-		return fmt.Errorf("amount should be under 100")
+		return invalidParams(fmt.Errorf("amount should be under 100"))
+	}
+	var lease time.Duration
+	if params.Lease != "" {
+		lease, err = time.ParseDuration(params.Lease)
+		if err != nil {
+			return invalidParams(err)
+		}
+	}
+	var wait time.Duration
+	if params.Wait != "" {
+		wait, err = time.ParseDuration(params.Wait)
+		if err != nil {
+			return invalidParams(err)
+		}
+	}
+	if wait < 0 || wait > 30*time.Second { // Hardcoded long polling limit
+		return invalidParams(fmt.Errorf("wait should be between 0s and 30s"))
 	}
-	tasks, err := handler.svc.Claim(ctx, amount)
+	tasks, err := handler.svc.Claim(ctx, domain.ClaimRequest{
+		Amount: amount,
+		Queues: params.Queues,
+		Lease:  lease,
+		Wait:   wait,
+	})
 	if err != nil {
 		return err
 	}
@@ -104,10 +318,8 @@ type SucceedParams struct {
 }
 
 // Succeed marks task as done.
-// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Worker.Succeed", "params":[{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3","claimID":"f5dca270-be27-45aa-ae3a-6e5a600dd965","result": {"data": "job is done"}}], "id": "1"}' http://0.0.0.0:8000/worker/v0
-func (handler *Worker) Succeed(params *SucceedParams, result *map[string]interface{}) error {
-	ctx := context.Background()
-
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Worker.Succeed", "params":{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3","claimID":"f5dca270-be27-45aa-ae3a-6e5a600dd965","result": {"data": "job is done"}}, "id": "1"}' http://0.0.0.0:8000/worker/v0
+func (handler *Worker) Succeed(ctx context.Context, params *SucceedParams, result *map[string]interface{}) error {
 	err := handler.svc.Succeed(ctx, params.ID, params.ClaimID, params.Result)
 	if err != nil {
 		return err
@@ -118,6 +330,55 @@ func (handler *Worker) Succeed(params *SucceedParams, result *map[string]interfa
 	return nil
 }
 
+// HeartbeatParams describes input params for Heartbeat procedure.
+type HeartbeatParams struct {
+	ID      uuid.UUID `json:"id"`
+	ClaimID uuid.UUID `json:"claimID"`
+	Lease   string    `json:"lease"`
+}
+
+// Heartbeat prolongs a task lease, so the task isn't reclaimed by another worker.
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Worker.Heartbeat", "params":{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3","claimID":"f5dca270-be27-45aa-ae3a-6e5a600dd965","lease": "5m"}, "id": "1"}' http://0.0.0.0:8000/worker/v0
+func (handler *Worker) Heartbeat(ctx context.Context, params *HeartbeatParams, result *map[string]interface{}) error {
+	var (
+		lease time.Duration
+		err   error
+	)
+	if params.Lease != "" {
+		lease, err = time.ParseDuration(params.Lease)
+		if err != nil {
+			return invalidParams(err)
+		}
+	}
+	leasedUntil, err := handler.svc.Heartbeat(ctx, params.ID, params.ClaimID, lease)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"leasedUntil": leasedUntil,
+	}
+	return nil
+}
+
+// ReleaseParams describes input params for Release procedure.
+type ReleaseParams struct {
+	ID      uuid.UUID `json:"id"`
+	ClaimID uuid.UUID `json:"claimID"`
+}
+
+// Release gives a claimed task back unprocessed, so another worker claims it at once.
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Worker.Release", "params":{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3","claimID":"f5dca270-be27-45aa-ae3a-6e5a600dd965"}, "id": "1"}' http://0.0.0.0:8000/worker/v0
+func (handler *Worker) Release(ctx context.Context, params *ReleaseParams, result *map[string]interface{}) error {
+	err := handler.svc.Release(ctx, params.ID, params.ClaimID)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"message": "success",
+	}
+	return nil
+}
+
 // FailParams describes input params for Fail procedure.
 type FailParams struct {
 	ID      uuid.UUID `json:"id"`
@@ -126,12 +387,11 @@ type FailParams struct {
 }
 
 // Fail marks task as failed.
-// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Worker.Fail", "params":[{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3","claimID":"032b8d9e-8f73-4a4e-a850-e2ed716099bf","reason": "never give up"}], "id": "1"}' http://0.0.0.0:8000/worker/v0
-func (handler *Worker) Fail(params *FailParams, result *map[string]interface{}) error {
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Worker.Fail", "params":{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3","claimID":"032b8d9e-8f73-4a4e-a850-e2ed716099bf","reason": "never give up"}, "id": "1"}' http://0.0.0.0:8000/worker/v0
+func (handler *Worker) Fail(ctx context.Context, params *FailParams, result *map[string]interface{}) error {
 	if params.Reason == "" {
-		return fmt.Errorf("reason should not be empty")
+		return invalidParams(fmt.Errorf("reason should not be empty"))
 	}
-	ctx := context.Background()
 	err := handler.svc.Fail(ctx, params.ID, params.ClaimID, params.Reason)
 	if err != nil {
 		return err
@@ -141,3 +401,339 @@ func (handler *Worker) Fail(params *FailParams, result *map[string]interface{})
 	}
 	return nil
 }
+
+// SucceedManyParams describes input params for SucceedMany procedure.
+type SucceedManyParams struct {
+	Tasks []SucceedParams `json:"tasks"`
+}
+
+// SucceedMany marks tasks as done, a stale claim doesn't fail the others.
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Worker.SucceedMany", "params":{"tasks":[{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3","claimID":"f5dca270-be27-45aa-ae3a-6e5a600dd965","result": {"data": "job is done"}}]}, "id": "1"}' http://0.0.0.0:8000/worker/v0
+func (handler *Worker) SucceedMany(ctx context.Context, params *SucceedManyParams, result *map[string]interface{}) error {
+	if len(params.Tasks) == 0 || len(params.Tasks) > 100 { // Hardcoded batch size
+		return invalidParams(fmt.Errorf("tasks amount should be between 1 and 100"))
+	}
+	outcomes := make([]domain.Outcome, 0, len(params.Tasks))
+	for _, task := range params.Tasks {
+		outcomes = append(outcomes, domain.Outcome{
+			ID:      task.ID,
+			ClaimID: task.ClaimID,
+			Result:  task.Result,
+		})
+	}
+	results, err := handler.svc.SucceedMany(ctx, outcomes)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"results": batchResults(results),
+	}
+	return nil
+}
+
+// FailManyParams describes input params for FailMany procedure.
+type FailManyParams struct {
+	Tasks []FailParams `json:"tasks"`
+}
+
+// FailMany marks tasks as failed, a stale claim doesn't fail the others.
+// curl -X POST -H 'Auth: token' -d '{"jsonrpc": "2.0", "method": "Worker.FailMany", "params":{"tasks":[{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3","claimID":"032b8d9e-8f73-4a4e-a850-e2ed716099bf","reason": "never give up"}]}, "id": "1"}' http://0.0.0.0:8000/worker/v0
+func (handler *Worker) FailMany(ctx context.Context, params *FailManyParams, result *map[string]interface{}) error {
+	if len(params.Tasks) == 0 || len(params.Tasks) > 100 { // Hardcoded batch size
+		return invalidParams(fmt.Errorf("tasks amount should be between 1 and 100"))
+	}
+	outcomes := make([]domain.Outcome, 0, len(params.Tasks))
+	for i, task := range params.Tasks {
+		if task.Reason == "" {
+			return invalidParams(fmt.Errorf("tasks[%d]: reason should not be empty", i))
+		}
+		outcomes = append(outcomes, domain.Outcome{
+			ID:      task.ID,
+			ClaimID: task.ClaimID,
+			Reason:  task.Reason,
+		})
+	}
+	results, err := handler.svc.FailMany(ctx, outcomes)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"results": batchResults(results),
+	}
+	return nil
+}
+
+// Admin is a JSON RPC handler.
+type Admin struct {
+	svc domain.Admin
+}
+
+// ListTasksParams describes input params for ListTasks procedure.
+type ListTasksParams struct {
+	States      []string  `json:"states"`
+	Queues      []string  `json:"queues"`
+	CreatedFrom time.Time `json:"createdFrom"`
+	CreatedTo   time.Time `json:"createdTo"`
+	ExecuteFrom time.Time `json:"executeFrom"`
+	ExecuteTo   time.Time `json:"executeTo"`
+	DoneFrom    time.Time `json:"doneFrom"`
+	DoneTo      time.Time `json:"doneTo"`
+	MinAttempts *int      `json:"minAttempts"`
+	MaxAttempts *int      `json:"maxAttempts"`
+	OrderBy     string    `json:"orderBy"`
+	Order       string    `json:"order"`
+	Limit       int       `json:"limit"`
+	Cursor      string    `json:"cursor"`
+}
+
+// ListTasks returns a page of filtered tasks, the next page is requested with the returned cursor.
+// curl -X POST -H 'Auth: admintoken' -d '{"jsonrpc": "2.0", "method": "Admin.ListTasks", "params":{"states":["pending", "failed"], "createdFrom":"2021-10-14T00:00:00Z", "minAttempts":1, "orderBy":"executeAt", "order":"desc", "limit":50}, "id": "1"}' http://0.0.0.0:8000/admin/v0
+func (handler *Admin) ListTasks(ctx context.Context, params *ListTasksParams, result *map[string]interface{}) error {
+	limit := params.Limit
+	if limit == 0 {
+		limit = 100
+	}
+	if limit < 0 || limit > 1000 { // Hardcoded page size
+		return invalidParams(fmt.Errorf("limit should be between 1 and 1000"))
+	}
+	var desc bool
+	switch params.Order {
+	case "", "asc":
+	case "desc":
+		desc = true
+	default:
+		return invalidParams(fmt.Errorf("order should be asc or desc"))
+	}
+	states := make([]domain.State, 0, len(params.States))
+	for _, state := range params.States {
+		switch domain.State(state) {
+		case domain.StatePending, domain.StateProcessing, domain.StateSucceeded, domain.StateFailed,
+			domain.StateExhausted, domain.StateExpired, domain.StateCancelled:
+		default:
+			return invalidParams(fmt.Errorf("unknown state %q", state))
+		}
+		states = append(states, domain.State(state))
+	}
+	page, err := handler.svc.ListTasks(ctx, domain.ListTasksRequest{
+		Filter: domain.TaskFilter{
+			States:      states,
+			Queues:      params.Queues,
+			CreatedFrom: params.CreatedFrom,
+			CreatedTo:   params.CreatedTo,
+			ExecuteFrom: params.ExecuteFrom,
+			ExecuteTo:   params.ExecuteTo,
+			DoneFrom:    params.DoneFrom,
+			DoneTo:      params.DoneTo,
+			MinAttempts: params.MinAttempts,
+			MaxAttempts: params.MaxAttempts,
+		},
+		OrderBy: domain.TaskOrder(params.OrderBy),
+		Desc:    desc,
+		Limit:   limit,
+		Cursor:  params.Cursor,
+	})
+	if err != nil {
+		return err
+	}
+	tasks := make([]map[string]interface{}, 0, len(page.Tasks))
+	for _, task := range page.Tasks {
+		tasks = append(tasks, map[string]interface{}{
+			"task": task,
+			"meta": task.Meta,
+		})
+	}
+	*result = map[string]interface{}{
+		"tasks":      tasks,
+		"count":      len(tasks),
+		"nextCursor": page.NextCursor,
+	}
+	return nil
+}
+
+// DeadLetter is a JSON RPC handler.
+type DeadLetter struct {
+	svc domain.DeadLetters
+}
+
+// ListParams describes input params for List procedure.
+type ListParams struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// List returns dead letters ordered by death time.
+// curl -X POST -H 'Auth: admintoken' -d '{"jsonrpc": "2.0", "method": "DeadLetter.List", "params":{"limit":10, "offset":0}, "id": "1"}' http://0.0.0.0:8000/admin/v0
+func (handler *DeadLetter) List(ctx context.Context, params *ListParams, result *map[string]interface{}) error {
+	limit := params.Limit
+	if limit == 0 {
+		limit = 100
+	}
+	if limit < 0 || limit > 1000 { // Hardcoded page size
+		return invalidParams(fmt.Errorf("limit should be between 1 and 1000"))
+	}
+	if params.Offset < 0 {
+		return invalidParams(fmt.Errorf("offset should not be negative"))
+	}
+	letters, err := handler.svc.ListDeadLetters(ctx, limit, params.Offset)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"deadLetters": letters,
+		"count":       len(letters),
+	}
+	return nil
+}
+
+// Get returns a dead letter by task id.
+// curl -X POST -H 'Auth: admintoken' -d '{"jsonrpc": "2.0", "method": "DeadLetter.Get", "params":{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3"}, "id": "1"}' http://0.0.0.0:8000/admin/v0
+func (handler *DeadLetter) Get(ctx context.Context, params *GetParams, result *map[string]interface{}) error {
+	letter, err := handler.svc.GetDeadLetter(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"deadLetter": letter,
+	}
+	return nil
+}
+
+// Requeue moves a dead letter back to pending tasks.
+// curl -X POST -H 'Auth: admintoken' -d '{"jsonrpc": "2.0", "method": "DeadLetter.Requeue", "params":{"id":"bd954d5e-2b11-49a8-be81-2a53e25a9dc3"}, "id": "1"}' http://0.0.0.0:8000/admin/v0
+func (handler *DeadLetter) Requeue(ctx context.Context, params *GetParams, result *map[string]interface{}) error {
+	task, err := handler.svc.RequeueDeadLetter(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"task": task,
+	}
+	return nil
+}
+
+// PurgeParams describes input params for Purge procedure.
+type PurgeParams struct {
+	IDs []uuid.UUID `json:"ids"`
+}
+
+// Purge removes dead letters.
+// curl -X POST -H 'Auth: admintoken' -d '{"jsonrpc": "2.0", "method": "DeadLetter.Purge", "params":{"ids":["bd954d5e-2b11-49a8-be81-2a53e25a9dc3"]}, "id": "1"}' http://0.0.0.0:8000/admin/v0
+func (handler *DeadLetter) Purge(ctx context.Context, params *PurgeParams, result *map[string]interface{}) error {
+	if len(params.IDs) == 0 {
+		return invalidParams(fmt.Errorf("ids should not be empty"))
+	}
+	purged, err := handler.svc.PurgeDeadLetters(ctx, params.IDs)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"purged": purged,
+	}
+	return nil
+}
+
+// Schedule is a JSON RPC handler.
+type Schedule struct {
+	svc domain.Schedules
+}
+
+// ScheduleParams describes input params for Schedule.Create procedure.
+type ScheduleParams struct {
+	ID            uuid.UUID              `json:"id"`
+	Cron          string                 `json:"cron"`
+	Timezone      string                 `json:"timezone"`
+	Queue         string                 `json:"queue"`
+	Priority      int                    `json:"priority"`
+	Payload       map[string]interface{} `json:"payload"`
+	RetryPolicy   *RetryParams           `json:"retryPolicy"`
+	TTL           string                 `json:"ttl"`
+	MisfirePolicy string                 `json:"misfirePolicy"`
+}
+
+// Create registers a recurring task.
+// curl -X POST -H 'Auth: admintoken' -d '{"jsonrpc": "2.0", "method": "Schedule.Create", "params":{"id":"5c1ad3a4-6b2f-4f0e-9f0c-2a4c8e0d7f11", "cron":"*/5 * * * *", "timezone":"Europe/Moscow", "ttl":"1h", "payload": {"type":"report", "date": "{{scheduledAt}}"}}, "id": "1"}' http://0.0.0.0:8000/admin/v0
+func (handler *Schedule) Create(ctx context.Context, params *ScheduleParams, result *map[string]interface{}) error {
+	if len(params.Queue) > 255 {
+		return invalidParams(fmt.Errorf("queue name should be under 256 characters"))
+	}
+	if params.Priority < -domain.MaxPriority || params.Priority > domain.MaxPriority {
+		return invalidParams(fmt.Errorf("priority should be between %d and %d", -domain.MaxPriority, domain.MaxPriority))
+	}
+	policy, err := params.RetryPolicy.RetryPolicy()
+	if err != nil {
+		return err
+	}
+	ttl, err := time.ParseDuration(params.TTL)
+	if err != nil {
+		return invalidParams(err)
+	}
+	schedule := &domain.Schedule{
+		ID:            params.ID,
+		Cron:          params.Cron,
+		Timezone:      params.Timezone,
+		Queue:         params.Queue,
+		Priority:      params.Priority,
+		Payload:       params.Payload,
+		RetryPolicy:   policy,
+		TTL:           ttl,
+		MisfirePolicy: domain.MisfirePolicy(params.MisfirePolicy),
+	}
+	schedule, err = handler.svc.CreateSchedule(ctx, schedule)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"schedule": schedule,
+	}
+	return nil
+}
+
+// Get returns a schedule by id.
+// curl -X POST -H 'Auth: admintoken' -d '{"jsonrpc": "2.0", "method": "Schedule.Get", "params":{"id":"5c1ad3a4-6b2f-4f0e-9f0c-2a4c8e0d7f11"}, "id": "1"}' http://0.0.0.0:8000/admin/v0
+func (handler *Schedule) Get(ctx context.Context, params *GetParams, result *map[string]interface{}) error {
+	schedule, err := handler.svc.GetSchedule(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"schedule": schedule,
+	}
+	return nil
+}
+
+// List returns schedules ordered by creation time.
+// curl -X POST -H 'Auth: admintoken' -d '{"jsonrpc": "2.0", "method": "Schedule.List", "params":{"limit":10, "offset":0}, "id": "1"}' http://0.0.0.0:8000/admin/v0
+func (handler *Schedule) List(ctx context.Context, params *ListParams, result *map[string]interface{}) error {
+	limit := params.Limit
+	if limit == 0 {
+		limit = 100
+	}
+	if limit < 0 || limit > 1000 { // Hardcoded page size
+		return invalidParams(fmt.Errorf("limit should be between 1 and 1000"))
+	}
+	if params.Offset < 0 {
+		return invalidParams(fmt.Errorf("offset should not be negative"))
+	}
+	schedules, err := handler.svc.ListSchedules(ctx, limit, params.Offset)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"schedules": schedules,
+		"count":     len(schedules),
+	}
+	return nil
+}
+
+// Delete stops a recurring task, already enqueued tasks are kept.
+// curl -X POST -H 'Auth: admintoken' -d '{"jsonrpc": "2.0", "method": "Schedule.Delete", "params":{"id":"5c1ad3a4-6b2f-4f0e-9f0c-2a4c8e0d7f11"}, "id": "1"}' http://0.0.0.0:8000/admin/v0
+func (handler *Schedule) Delete(ctx context.Context, params *GetParams, result *map[string]interface{}) error {
+	err := handler.svc.DeleteSchedule(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	*result = map[string]interface{}{
+		"message": "success",
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package apiserv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	log "github.com/freundallein/scheduler/pkg/utils/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+	// codeServerError is used for domain errors without their own code.
+	codeServerError = -32000
+	// codeRequestTimeout means, that a call exceeded its server-side timeout.
+	codeRequestTimeout = -32098
+	// codeRequestCancelled means, that a call was interrupted by a client or server shutdown.
+	codeRequestCancelled = -32099
+)
+
+// errorCodes maps domain error codes to JSON-RPC error codes,
+// the domain code itself is sent as error data.
+var errorCodes = map[string]int{
+	domain.ErrInvalidArgument:  codeInvalidParams,
+	domain.ErrNoPendingTasks:   -32001,
+	domain.ErrDuplicateTask:    -32002,
+	domain.ErrTaskNotFound:     -32003,
+	domain.ErrStaleResult:      -32004,
+	domain.ErrDeadlineExceeded: -32005,
+	domain.ErrTaskCancelled:    -32006,
+	domain.ErrTaskFinished:     -32007,
+	domain.ErrScheduleNotFound: -32008,
+	domain.ErrRateLimited:      -32009,
+}
+
+const (
+	// maxBodySize limits a request body.
+	maxBodySize = 10 << 20
+	// maxBatchSize limits amount of calls in a batch request.
+	maxBatchSize = 100
+	// maxBatchConcurrency limits amount of concurrent calls of a batch, so a batch doesn't take the whole pool.
+	maxBatchConcurrency = 8
+)
+
+// rpcRequest is a JSON-RPC 2.0 request object.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	// ID is nil for notifications, that get no response.
+	ID json.RawMessage `json:"id"`
+}
+
+// rpcError is a JSON-RPC 2.0 error object.
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+	// retryAfter is sent in the Retry-After header, when a call is rate limited.
+	retryAfter time.Duration
+}
+
+// rpcResponse is a JSON-RPC 2.0 response object.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// invalidParams marks an error as a params validation error.
+func invalidParams(err error) error {
+	return domain.Error{Code: domain.ErrInvalidArgument, Message: err.Error(), Inner: err}
+}
+
+// newRPCError converts an error to a JSON-RPC error object.
+// Other errors may reveal internals, e.g. database ones, so they are logged instead of being sent.
+func newRPCError(method string, err error) *rpcError {
+	var domainErr domain.Error
+	if !errors.As(err, &domainErr) {
+		log.WithFields(log.Fields{
+			"method": method,
+			"err":    err,
+		}).Error("json_rpc_request_failure")
+		return &rpcError{Code: codeInternalError, Message: "internal error"}
+	}
+	code, ok := errorCodes[domainErr.Code]
+	if !ok {
+		code = codeServerError
+	}
+	return &rpcError{
+		Code:    code,
+		Message: err.Error(),
+		Data:    map[string]interface{}{"code": domainErr.Code},
+	}
+}
+
+// rateLimited returns an error of a call, that may be repeated after the delay.
+func rateLimited(delay time.Duration) *rpcError {
+	return &rpcError{
+		Code:    errorCodes[domain.ErrRateLimited],
+		Message: "rate limit exceeded",
+		Data: map[string]interface{}{
+			"code":       domain.ErrRateLimited,
+			"retryAfter": delay.Seconds(),
+		},
+		retryAfter: delay,
+	}
+}
+
+// rpcMethod is a registered handler method.
+type rpcMethod struct {
+	receiver reflect.Value
+	method   reflect.Method
+	params   reflect.Type
+	result   reflect.Type
+}
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// rpcServer serves JSON-RPC 2.0 requests with named or positional params,
+// batches and notifications.
+type rpcServer struct {
+	methods map[string]*rpcMethod
+	// timeout limits a call, unless the method has its own timeout.
+	timeout           time.Duration
+	methodTimeouts    map[string]time.Duration
+	requestsCancelled prometheus.Counter
+	requestsTimedOut  prometheus.Counter
+	limiter           *rateLimiter
+}
+
+func newRPCServer(svc *Service) *rpcServer {
+	return &rpcServer{
+		methods:           map[string]*rpcMethod{},
+		timeout:           svc.Timeout,
+		methodTimeouts:    svc.MethodTimeouts,
+		requestsCancelled: svc.requestsCancelled,
+		requestsTimedOut:  svc.requestsTimedOut,
+		limiter:           svc.limiter,
+	}
+}
+
+// Register publishes receiver's methods as "Type.Method" the same way net/rpc does,
+// methods should look like `func (t *T) Method(ctx context.Context, params *P, result *R) error`.
+func (s *rpcServer) Register(receiver interface{}) {
+	value := reflect.ValueOf(receiver)
+	name := reflect.Indirect(value).Type().Name()
+	for i := 0; i < value.Type().NumMethod(); i++ {
+		method := value.Type().Method(i)
+		mtype := method.Type
+		if mtype.NumIn() != 4 || mtype.NumOut() != 1 || mtype.Out(0) != errorType {
+			continue
+		}
+		if mtype.In(1) != contextType || mtype.In(2).Kind() != reflect.Ptr || mtype.In(3).Kind() != reflect.Ptr {
+			continue
+		}
+		s.methods[name+"."+method.Name] = &rpcMethod{
+			receiver: value,
+			method:   method,
+			params:   mtype.In(2).Elem(),
+			result:   mtype.In(3).Elem(),
+		}
+	}
+}
+
+// ServeHTTP handles a single request or a batch of requests.
+func (s *rpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		s.write(w, &rpcResponse{Error: &rpcError{Code: codeParseError, Message: err.Error()}})
+		return
+	}
+	body = bytes.TrimLeftFunc(body, unicode.IsSpace)
+	if !json.Valid(body) {
+		s.write(w, &rpcResponse{Error: &rpcError{Code: codeParseError, Message: "parse error"}})
+		return
+	}
+	ctx := r.Context()
+	if len(body) == 0 || body[0] != '[' {
+		s.write(w, s.call(ctx, body))
+		return
+	}
+	var batch []json.RawMessage
+	err = json.Unmarshal(body, &batch)
+	if err != nil {
+		s.write(w, &rpcResponse{Error: &rpcError{Code: codeParseError, Message: err.Error()}})
+		return
+	}
+	if len(batch) == 0 || len(batch) > maxBatchSize {
+		s.write(w, &rpcResponse{Error: &rpcError{
+			Code:    codeInvalidRequest,
+			Message: fmt.Sprintf("batch should have between 1 and %d requests", maxBatchSize),
+		}})
+		return
+	}
+	// Calls of a batch are independent, so they are served concurrently.
+	responses := make([]*rpcResponse, len(batch))
+	slots := make(chan struct{}, maxBatchConcurrency)
+	var wg sync.WaitGroup
+	for idx, request := range batch {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(idx int, request json.RawMessage) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			responses[idx] = s.call(ctx, request)
+		}(idx, request)
+	}
+	wg.Wait()
+	answered := make([]*rpcResponse, 0, len(responses))
+	for _, response := range responses {
+		if response != nil {
+			answered = append(answered, response)
+		}
+	}
+	if len(answered) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.write(w, answered)
+}
+
+// write sends responses, nil means there is nothing to answer.
+// Rate limited calls set the Retry-After header, a single one is answered with 429 status.
+func (s *rpcServer) write(w http.ResponseWriter, responses interface{}) {
+	status := http.StatusOK
+	var retryAfter time.Duration
+	if response, ok := responses.(*rpcResponse); ok {
+		if response == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		response.JSONRPC = "2.0"
+		if response.Error != nil && response.Error.retryAfter > 0 {
+			status = http.StatusTooManyRequests
+			retryAfter = response.Error.retryAfter
+		}
+	}
+	if batch, ok := responses.([]*rpcResponse); ok {
+		for _, response := range batch {
+			response.JSONRPC = "2.0"
+			if response.Error != nil && response.Error.retryAfter > retryAfter {
+				retryAfter = response.Error.retryAfter
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(responses)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("err_while_serving_json_rpc")
+	}
+}
+
+// call serves a single request, returns nil for a notification.
+func (s *rpcServer) call(ctx context.Context, raw json.RawMessage) *rpcResponse {
+	var request rpcRequest
+	err := json.Unmarshal(raw, &request)
+	if err != nil || request.JSONRPC != "2.0" || request.Method == "" || !validID(request.ID) {
+		return &rpcResponse{Error: &rpcError{Code: codeInvalidRequest, Message: "invalid request"}}
+	}
+	response := &rpcResponse{ID: request.ID}
+	result, rpcErr := s.invoke(ctx, &request)
+	if request.ID == nil {
+		return nil
+	}
+	if rpcErr != nil {
+		response.Error = rpcErr
+		return response
+	}
+	response.Result = result
+	return response
+}
+
+// validID checks, that id is a string, a number, null or absent.
+func validID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch r, _ := utf8.DecodeRune(id); {
+	case r == '"', r == '-', r == 'n', unicode.IsDigit(r):
+		return true
+	}
+	return false
+}
+
+// invoke decodes params, calls a method within its timeout and encodes its result.
+// A panic of a method is reported as an internal error, so it doesn't crash the server.
+func (s *rpcServer) invoke(ctx context.Context, request *rpcRequest) (encoded json.RawMessage, rpcErr *rpcError) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithFields(log.Fields{
+				"method": request.Method,
+				"panic":  r,
+				"stack":  string(debug.Stack()),
+			}).Error("json_rpc_request_panic")
+			encoded, rpcErr = nil, &rpcError{Code: codeInternalError, Message: "internal error"}
+		}
+	}()
+	method, ok := s.methods[request.Method]
+	if !ok {
+		return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q not found", request.Method)}
+	}
+	if delay, ok := s.limiter.allow(clientFrom(ctx), request.Method); !ok {
+		log.WithFields(log.Fields{
+			"client": clientFrom(ctx),
+			"method": request.Method,
+			"delay":  delay,
+		}).Debug("json_rpc_request_rate_limited")
+		return nil, rateLimited(delay)
+	}
+	params := reflect.New(method.params)
+	err := decodeParams(request.Params, params.Interface())
+	if err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	timeout, ok := s.methodTimeouts[request.Method]
+	if !ok {
+		timeout = s.timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	result := reflect.New(method.result)
+	returned := method.method.Func.Call([]reflect.Value{method.receiver, reflect.ValueOf(ctx), params, result})
+	if err, _ := returned[0].Interface().(error); err != nil {
+		if ctx.Err() != nil {
+			return nil, s.interrupted(request.Method, ctx.Err(), err)
+		}
+		return nil, newRPCError(request.Method, err)
+	}
+	encoded, err = json.Marshal(result.Interface())
+	if err != nil {
+		return nil, newRPCError(request.Method, err)
+	}
+	return encoded, nil
+}
+
+// interrupted reports a call, that failed because its context is done.
+func (s *rpcServer) interrupted(method string, ctxErr, err error) *rpcError {
+	fields := log.Fields{
+		"method": method,
+		"err":    err,
+	}
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		s.requestsTimedOut.Inc()
+		log.WithFields(fields).Warn("json_rpc_request_timed_out")
+		return &rpcError{Code: codeRequestTimeout, Message: "request timed out"}
+	}
+	s.requestsCancelled.Inc()
+	log.WithFields(fields).Info("json_rpc_request_cancelled")
+	return &rpcError{Code: codeRequestCancelled, Message: "request cancelled"}
+}
+
+// decodeParams decodes named params or a single positional param.
+// Absent params leave zero values.
+func decodeParams(raw json.RawMessage, params interface{}) error {
+	raw = bytes.TrimLeftFunc(raw, unicode.IsSpace)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil
+	}
+	switch raw[0] {
+	case '{':
+		return json.Unmarshal(raw, params)
+	case '[':
+		var positional []json.RawMessage
+		err := json.Unmarshal(raw, &positional)
+		if err != nil {
+			return err
+		}
+		switch len(positional) {
+		case 0:
+			return nil
+		case 1:
+			return json.Unmarshal(positional[0], params)
+		}
+		return fmt.Errorf("positional params should have a single element")
+	}
+	return fmt.Errorf("params should be an object or an array")
+}
@@ -0,0 +1,401 @@
+package apiserv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Probe is a handler with methods, that cover JSON-RPC server behaviour.
+type Probe struct {
+	mu     sync.Mutex
+	active int
+	peak   int
+}
+
+// ProbeParams describes input params of Probe methods.
+type ProbeParams struct {
+	Value string `json:"value"`
+	Code  string `json:"code"`
+	// Delay is a duration of Block call, 20ms by default.
+	Delay string `json:"delay"`
+}
+
+// Echo returns the value.
+func (p *Probe) Echo(ctx context.Context, params *ProbeParams, result *map[string]interface{}) error {
+	*result = map[string]interface{}{"value": params.Value}
+	return nil
+}
+
+// Fail returns a domain error with the code.
+func (p *Probe) Fail(ctx context.Context, params *ProbeParams, result *map[string]interface{}) error {
+	return domain.Error{Code: params.Code, Message: "probe failed"}
+}
+
+// Leak returns an error, that shouldn't be sent to a client.
+func (p *Probe) Leak(ctx context.Context, params *ProbeParams, result *map[string]interface{}) error {
+	return errors.New("password authentication failed for user scheduler")
+}
+
+// Crash panics.
+func (p *Probe) Crash(ctx context.Context, params *ProbeParams, result *map[string]interface{}) error {
+	panic("probe crashed")
+}
+
+// Block keeps track of concurrent calls, which last for the delay or until ctx is done.
+func (p *Probe) Block(ctx context.Context, params *ProbeParams, result *map[string]interface{}) error {
+	delay := 20 * time.Millisecond
+	if params.Delay != "" {
+		var err error
+		delay, err = time.ParseDuration(params.Delay)
+		if err != nil {
+			return err
+		}
+	}
+	p.mu.Lock()
+	p.active++
+	if p.active > p.peak {
+		p.peak = p.active
+	}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.active--
+		p.mu.Unlock()
+	}()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func newProbeServer(probe *Probe) *httptest.Server {
+	return httptest.NewServer(newProbeRPCServer(probe))
+}
+
+func newProbeRPCServer(probe *Probe) *rpcServer {
+	server := &rpcServer{
+		methods:           map[string]*rpcMethod{},
+		timeout:           time.Second,
+		requestsCancelled: prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_cancelled_total"}),
+		requestsTimedOut:  prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_timed_out_total"}),
+		limiter: newRateLimiter(nil, prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "requests_rate_limited_total"},
+			[]string{"client", "method"},
+		)),
+	}
+	server.Register(probe)
+	return server
+}
+
+// post sends a body and returns the response status and decoded body.
+func post(t *testing.T, url, body string) (int, interface{}) {
+	t.Helper()
+	response, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	defer response.Body.Close()
+	raw, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	if len(raw) == 0 {
+		return response.StatusCode, nil
+	}
+	var decoded interface{}
+	err = json.Unmarshal(raw, &decoded)
+	if err != nil {
+		t.Fatalf("Expected JSON, got: `%s`", raw)
+	}
+	return response.StatusCode, decoded
+}
+
+func TestRPCServer(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expected       string
+	}{
+		{
+			name:           "parse error",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Echo"`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32700, "message": "parse error"}, "id": null}`,
+		},
+		{
+			name:           "invalid version",
+			body:           `{"jsonrpc": "1.0", "method": "Probe.Echo", "id": 1}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request"}, "id": null}`,
+		},
+		{
+			name:           "invalid id",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Echo", "id": {"a": 1}}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request"}, "id": null}`,
+		},
+		{
+			name:           "method not found",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Missing", "id": 1}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32601, "message": "method \"Probe.Missing\" not found"}, "id": 1}`,
+		},
+		{
+			name:           "named params",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Echo", "params": {"value": "a"}, "id": "1"}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "result": {"value": "a"}, "id": "1"}`,
+		},
+		{
+			name:           "positional params",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Echo", "params": [{"value": "a"}], "id": 1}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "result": {"value": "a"}, "id": 1}`,
+		},
+		{
+			name:           "several positional params",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Echo", "params": [{"value": "a"}, {}], "id": 1}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32602, "message": "positional params should have a single element"}, "id": 1}`,
+		},
+		{
+			name:           "scalar params",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Echo", "params": "a", "id": 1}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32602, "message": "params should be an object or an array"}, "id": 1}`,
+		},
+		{
+			name:           "null id",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Echo", "params": {"value": "a"}, "id": null}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "result": {"value": "a"}, "id": null}`,
+		},
+		{
+			name:           "notification",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Echo", "params": {"value": "a"}}`,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "domain error",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Fail", "params": {"code": "task_not_found"}, "id": 1}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32003, "message": "probe failed", "data": {"code": "task_not_found"}}, "id": 1}`,
+		},
+		{
+			name:           "invalid argument",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Fail", "params": {"code": "invalid_argument"}, "id": 1}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32602, "message": "probe failed", "data": {"code": "invalid_argument"}}, "id": 1}`,
+		},
+		{
+			name:           "unknown domain error",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Fail", "params": {"code": "probe_failed"}, "id": 1}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32000, "message": "probe failed", "data": {"code": "probe_failed"}}, "id": 1}`,
+		},
+		{
+			name:           "internal error",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Leak", "id": 1}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32603, "message": "internal error"}, "id": 1}`,
+		},
+		{
+			name:           "panic",
+			body:           `{"jsonrpc": "2.0", "method": "Probe.Crash", "id": 1}`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32603, "message": "internal error"}, "id": 1}`,
+		},
+		{
+			name: "batch",
+			body: `[
+				{"jsonrpc": "2.0", "method": "Probe.Echo", "params": {"value": "a"}, "id": 1},
+				{"jsonrpc": "2.0", "method": "Probe.Crash", "id": 2},
+				{"jsonrpc": "2.0", "method": "Probe.Echo", "params": {"value": "b"}},
+				{"jsonrpc": "2.0", "method": "Probe.Missing", "id": 3},
+				1
+			]`,
+			expectedStatus: http.StatusOK,
+			expected: `[
+				{"jsonrpc": "2.0", "result": {"value": "a"}, "id": 1},
+				{"jsonrpc": "2.0", "error": {"code": -32603, "message": "internal error"}, "id": 2},
+				{"jsonrpc": "2.0", "error": {"code": -32601, "message": "method \"Probe.Missing\" not found"}, "id": 3},
+				{"jsonrpc": "2.0", "error": {"code": -32600, "message": "invalid request"}, "id": null}
+			]`,
+		},
+		{
+			name: "batch of notifications",
+			body: `[
+				{"jsonrpc": "2.0", "method": "Probe.Echo", "params": {"value": "a"}},
+				{"jsonrpc": "2.0", "method": "Probe.Fail", "params": {"code": "task_not_found"}}
+			]`,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "empty batch",
+			body:           `[]`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "batch should have between 1 and 100 requests"}, "id": null}`,
+		},
+		{
+			name:           "oversized batch",
+			body:           "[" + strings.Repeat(`{"jsonrpc": "2.0", "method": "Probe.Echo"},`, maxBatchSize) + `{"jsonrpc": "2.0", "method": "Probe.Echo"}]`,
+			expectedStatus: http.StatusOK,
+			expected:       `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "batch should have between 1 and 100 requests"}, "id": null}`,
+		},
+	}
+	server := newProbeServer(&Probe{})
+	defer server.Close()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status, observed := post(t, server.URL, tc.body)
+			if status != tc.expectedStatus {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expectedStatus, status)
+			}
+			var expected interface{}
+			if tc.expected != "" {
+				err := json.Unmarshal([]byte(tc.expected), &expected)
+				if err != nil {
+					t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+				}
+			}
+			if !reflect.DeepEqual(expected, observed) {
+				t.Errorf("Expected `%v`, got: `%v`", expected, observed)
+			}
+		})
+	}
+}
+
+func TestRPCServerBatchConcurrency(t *testing.T) {
+	probe := &Probe{}
+	server := newProbeServer(probe)
+	defer server.Close()
+	calls := make([]string, 0, maxBatchSize)
+	for i := 0; i < maxBatchSize; i++ {
+		calls = append(calls, fmt.Sprintf(`{"jsonrpc": "2.0", "method": "Probe.Block", "id": %d}`, i))
+	}
+	status, observed := post(t, server.URL, "["+strings.Join(calls, ",")+"]")
+	if status != http.StatusOK {
+		t.Errorf("Expected `%v`, got: `%v`", http.StatusOK, status)
+	}
+	responses, _ := observed.([]interface{})
+	if len(responses) != maxBatchSize {
+		t.Fatalf("Expected `%v` responses, got: `%v`", maxBatchSize, len(responses))
+	}
+	for i, response := range responses {
+		id := response.(map[string]interface{})["id"]
+		if id != float64(i) {
+			t.Errorf("Expected `%v`, got: `%v`", i, id)
+		}
+	}
+	if probe.peak < 2 || probe.peak > maxBatchConcurrency {
+		t.Errorf("Expected up to `%v` concurrent calls, got: `%v`", maxBatchConcurrency, probe.peak)
+	}
+}
+
+func TestRPCServerTimeout(t *testing.T) {
+	rpc := newProbeRPCServer(&Probe{})
+	rpc.methodTimeouts = map[string]time.Duration{"Probe.Block": 10 * time.Millisecond}
+	server := httptest.NewServer(rpc)
+	defer server.Close()
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "method timeout",
+			body:     `{"jsonrpc": "2.0", "method": "Probe.Block", "params": {"delay": "10s"}, "id": 1}`,
+			expected: `{"jsonrpc": "2.0", "error": {"code": -32098, "message": "request timed out"}, "id": 1}`,
+		},
+		{
+			name:     "within timeout",
+			body:     `{"jsonrpc": "2.0", "method": "Probe.Block", "params": {"delay": "1ms"}, "id": 1}`,
+			expected: `{"jsonrpc": "2.0", "result": null, "id": 1}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, observed := post(t, server.URL, tc.body)
+			var expected interface{}
+			err := json.Unmarshal([]byte(tc.expected), &expected)
+			if err != nil {
+				t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+			}
+			if !reflect.DeepEqual(expected, observed) {
+				t.Errorf("Expected `%v`, got: `%v`", expected, observed)
+			}
+		})
+	}
+	if observed := testutil.ToFloat64(rpc.requestsTimedOut); observed != 1 {
+		t.Errorf("Expected `%v`, got: `%v`", 1, observed)
+	}
+	if observed := testutil.ToFloat64(rpc.requestsCancelled); observed != 0 {
+		t.Errorf("Expected `%v`, got: `%v`", 0, observed)
+	}
+}
+
+func TestRPCServerCancelled(t *testing.T) {
+	rpc := newProbeRPCServer(&Probe{})
+	recorded := make(chan *httptest.ResponseRecorder, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The disconnected client doesn't receive the response, so it's recorded.
+		recorder := httptest.NewRecorder()
+		rpc.ServeHTTP(recorder, r)
+		recorded <- recorder
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	body := `{"jsonrpc": "2.0", "method": "Probe.Block", "params": {"delay": "10s"}, "id": 1}`
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = http.DefaultClient.Do(request)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected `%v`, got: `%v`", context.Canceled, err)
+	}
+	var recorder *httptest.ResponseRecorder
+	select {
+	case recorder = <-recorded:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the call to be cancelled with the client's request")
+	}
+	var observed, expected interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &observed)
+	if err != nil {
+		t.Fatalf("Expected JSON, got: `%s`", recorder.Body.Bytes())
+	}
+	err = json.Unmarshal([]byte(`{"jsonrpc": "2.0", "error": {"code": -32099, "message": "request cancelled"}, "id": 1}`), &expected)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	if !reflect.DeepEqual(expected, observed) {
+		t.Errorf("Expected `%v`, got: `%v`", expected, observed)
+	}
+	if observed := testutil.ToFloat64(rpc.requestsCancelled); observed != 1 {
+		t.Errorf("Expected `%v`, got: `%v`", 1, observed)
+	}
+	if observed := testutil.ToFloat64(rpc.requestsTimedOut); observed != 0 {
+		t.Errorf("Expected `%v`, got: `%v`", 0, observed)
+	}
+}
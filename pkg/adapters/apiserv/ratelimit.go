@@ -0,0 +1,179 @@
+package apiserv
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// anyName matches any client or method in RateLimit.
+const anyName = "*"
+
+// sweepInterval is how often idle buckets are dropped.
+const sweepInterval = time.Minute
+
+// RateLimit is a token bucket, that limits calls of every client separately.
+type RateLimit struct {
+	// Client is a client name, "*" matches any client.
+	Client string
+	// Method is a method name, e.g. "Scheduler.Set", "*" limits all calls of a client together.
+	Method string
+	// Rate is an amount of calls per second.
+	Rate float64
+	// Burst is a bucket size, an amount of calls, that can be made at once.
+	Burst int
+}
+
+// ParseRateLimits parses comma separated "client:method=rate/burst" limits,
+// e.g. "*:Scheduler.Set=10/50,billing:*=100/200".
+func ParseRateLimits(spec string) ([]RateLimit, error) {
+	var limits []RateLimit
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		eq := strings.Index(item, "=")
+		colon := strings.Index(item, ":")
+		slash := strings.LastIndex(item, "/")
+		if colon < 0 || eq < colon || slash < eq {
+			return nil, fmt.Errorf("rate limit %q should look like client:method=rate/burst", item)
+		}
+		limit := RateLimit{
+			Client: item[:colon],
+			Method: item[colon+1 : eq],
+		}
+		var err error
+		limit.Rate, err = strconv.ParseFloat(item[eq+1:slash], 64)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit %q: %w", item, err)
+		}
+		limit.Burst, err = strconv.Atoi(item[slash+1:])
+		if err != nil {
+			return nil, fmt.Errorf("rate limit %q: %w", item, err)
+		}
+		if limit.Client == "" || limit.Method == "" || limit.Rate <= 0 || limit.Burst < 1 {
+			return nil, fmt.Errorf("rate limit %q should have client, method, positive rate and burst", item)
+		}
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+// ruleKey identifies a RateLimit.
+type ruleKey struct {
+	client string
+	method string
+}
+
+// bucket is a client's token bucket of a rule.
+type bucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+	// refill is time to fill an empty bucket, an idle bucket is full after it.
+	refill time.Duration
+}
+
+// rateLimiter keeps token buckets of clients.
+type rateLimiter struct {
+	rules    map[ruleKey]RateLimit
+	rejected *prometheus.CounterVec
+
+	mu      sync.Mutex
+	buckets map[ruleKey]*bucket
+	swept   time.Time
+}
+
+func newRateLimiter(limits []RateLimit, rejected *prometheus.CounterVec) *rateLimiter {
+	rules := make(map[ruleKey]RateLimit, len(limits))
+	for _, limit := range limits {
+		rules[ruleKey{client: limit.Client, method: limit.Method}] = limit
+	}
+	return &rateLimiter{
+		rules:    rules,
+		rejected: rejected,
+		buckets:  map[ruleKey]*bucket{},
+	}
+}
+
+// rule returns the most specific limit of the client's method:
+// the client's method, all client's methods, the method of any client, any method of any client.
+func (l *rateLimiter) rule(client, method string) (RateLimit, bool) {
+	for _, key := range []ruleKey{
+		{client: client, method: method},
+		{client: client, method: anyName},
+		{client: anyName, method: method},
+		{client: anyName, method: anyName},
+	} {
+		if limit, ok := l.rules[key]; ok {
+			return limit, true
+		}
+	}
+	return RateLimit{}, false
+}
+
+// allow takes a token from the client's bucket,
+// returns how long to wait for a token, if the bucket is empty.
+func (l *rateLimiter) allow(client, method string) (time.Duration, bool) {
+	limit, ok := l.rule(client, method)
+	if !ok {
+		return 0, true
+	}
+	now := time.Now()
+	// Every client has own bucket of the rule, e.g. all methods of a client share a "*" bucket.
+	key := ruleKey{client: client, method: limit.Method}
+	l.mu.Lock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{
+			limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst),
+			refill:  time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second)),
+		}
+		l.buckets[key] = b
+	}
+	b.lastUsed = now
+	l.mu.Unlock()
+	reservation := b.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return 0, true
+	}
+	reservation.CancelAt(now)
+	// Rejections are counted by the rule, so names of clients and methods don't multiply series.
+	l.rejected.WithLabelValues(limit.Client, limit.Method).Inc()
+	return delay, false
+}
+
+// sweep drops buckets, that are full, they are the same as new ones.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.lastUsed) > b.refill {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// clientKey is a context key of an authenticated client name.
+type clientKey struct{}
+
+// withClient returns a context with the client name.
+func withClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// clientFrom returns the client name of the request context.
+func clientFrom(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
@@ -0,0 +1,189 @@
+package apiserv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/freundallein/scheduler/pkg/mock"
+	"github.com/freundallein/scheduler/pkg/scheduler"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newRejectedCounter() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "requests_rate_limited_total"},
+		[]string{"client", "method"},
+	)
+}
+
+func TestParseRateLimits(t *testing.T) {
+	tests := []struct {
+		spec        string
+		expected    []RateLimit
+		expectedErr bool
+	}{
+		{spec: ""},
+		{
+			spec: " *:Scheduler.Set=10/50, ,billing:*=0.5/1",
+			expected: []RateLimit{
+				{Client: "*", Method: "Scheduler.Set", Rate: 10, Burst: 50},
+				{Client: "billing", Method: "*", Rate: 0.5, Burst: 1},
+			},
+		},
+		{spec: "billing", expectedErr: true},
+		{spec: "billing:*", expectedErr: true},
+		{spec: "billing=10/50", expectedErr: true},
+		{spec: "billing:*=10", expectedErr: true},
+		{spec: "billing=1:*/2", expectedErr: true},
+		{spec: ":*=10/50", expectedErr: true},
+		{spec: "billing:=10/50", expectedErr: true},
+		{spec: "billing:*=ten/50", expectedErr: true},
+		{spec: "billing:*=10/fifty", expectedErr: true},
+		{spec: "billing:*=10/5/0", expectedErr: true},
+		{spec: "billing:*=0/50", expectedErr: true},
+		{spec: "billing:*=-1/50", expectedErr: true},
+		{spec: "billing:*=10/0", expectedErr: true},
+		{spec: "*:*=1/1,billing", expectedErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.spec, func(t *testing.T) {
+			observed, err := ParseRateLimits(tc.spec)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("Expected error: `%v`, got: `%v`", tc.expectedErr, err)
+			}
+			if !reflect.DeepEqual(observed, tc.expected) {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expected, observed)
+			}
+		})
+	}
+}
+
+func TestRateLimiterRule(t *testing.T) {
+	limits := []RateLimit{
+		{Client: "billing", Method: "Scheduler.Set", Rate: 1, Burst: 1},
+		{Client: "billing", Method: anyName, Rate: 2, Burst: 2},
+		{Client: anyName, Method: "Scheduler.Set", Rate: 3, Burst: 3},
+		{Client: anyName, Method: anyName, Rate: 4, Burst: 4},
+	}
+	tests := []struct {
+		client   string
+		method   string
+		expected RateLimit
+	}{
+		{client: "billing", method: "Scheduler.Set", expected: limits[0]},
+		{client: "billing", method: "Scheduler.Get", expected: limits[1]},
+		{client: "reports", method: "Scheduler.Set", expected: limits[2]},
+		{client: "reports", method: "Scheduler.Get", expected: limits[3]},
+	}
+	full := newRateLimiter(limits, newRejectedCounter())
+	for _, tc := range tests {
+		t.Run(tc.client+":"+tc.method, func(t *testing.T) {
+			observed, ok := full.rule(tc.client, tc.method)
+			if !ok || observed != tc.expected {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expected, observed)
+			}
+		})
+	}
+	partial := newRateLimiter(limits[:1], newRejectedCounter())
+	if observed, ok := partial.rule("reports", "Scheduler.Set"); ok {
+		t.Errorf("Expected no rule, got: `%v`", observed)
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	rejected := newRejectedCounter()
+	limiter := newRateLimiter([]RateLimit{{Client: anyName, Method: anyName, Rate: 1, Burst: 2}}, rejected)
+	for i := 0; i < 2; i++ {
+		if _, ok := limiter.allow("billing", "Scheduler.Set"); !ok {
+			t.Errorf("Expected call %d to be allowed", i)
+		}
+	}
+	delay, ok := limiter.allow("billing", "Scheduler.Get")
+	if ok || delay <= 0 || delay > time.Second {
+		t.Errorf("Expected call to be rejected for up to 1s, got: `%v`, `%v`", ok, delay)
+	}
+	// Every client has own bucket.
+	if _, ok := limiter.allow("reports", "Scheduler.Set"); !ok {
+		t.Errorf("Expected another client's call to be allowed")
+	}
+	if observed := testutil.ToFloat64(rejected.WithLabelValues(anyName, anyName)); observed != 1 {
+		t.Errorf("Expected `%v`, got: `%v`", 1, observed)
+	}
+	if observed := testutil.CollectAndCount(rejected); observed != 1 {
+		t.Errorf("Expected rejections counted by the rule only, got: `%v` series", observed)
+	}
+}
+
+func TestRateLimitedCall(t *testing.T) {
+	rejected := newRejectedCounter()
+	svc := New(
+		scheduler.New(&mock.Gateway{
+			FindByIDFn: func(id uuid.UUID) (*domain.Task, error) {
+				return &domain.Task{ID: id}, nil
+			},
+		}),
+		WithToken("token"),
+		WithRateLimits(RateLimit{Client: anyName, Method: "Scheduler.Get", Rate: 0.1, Burst: 1}),
+		WithRequestsRateLimited(rejected),
+	)
+	server := httptest.NewServer(svc.httpserv.Handler)
+	defer server.Close()
+	call := `{"jsonrpc": "2.0", "method": "Scheduler.Get", "params": {"id": "bd954d5e-2b11-49a8-be81-2a53e25a9dc3"}, "id": 1}`
+	tests := []struct {
+		name               string
+		body               string
+		expectedStatus     int
+		expectedRetryAfter string
+	}{
+		{name: "allowed", body: call, expectedStatus: http.StatusOK},
+		{name: "rejected", body: call, expectedStatus: http.StatusTooManyRequests, expectedRetryAfter: "10"},
+		{name: "rejected in batch", body: "[" + call + "]", expectedStatus: http.StatusOK, expectedRetryAfter: "10"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodPost, server.URL+"/rpc/v0", strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+			}
+			request.Header.Set("Auth", "token")
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != tc.expectedStatus {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expectedStatus, response.StatusCode)
+			}
+			if observed := response.Header.Get("Retry-After"); observed != tc.expectedRetryAfter {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expectedRetryAfter, observed)
+			}
+			if tc.expectedRetryAfter == "" {
+				return
+			}
+			var body interface{}
+			err = json.NewDecoder(response.Body).Decode(&body)
+			if err != nil {
+				t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+			}
+			if batch, ok := body.([]interface{}); ok {
+				body = batch[0]
+			}
+			rpcErr, _ := body.(map[string]interface{})["error"].(map[string]interface{})
+			data, _ := rpcErr["data"].(map[string]interface{})
+			if rpcErr["code"] != float64(errorCodes[domain.ErrRateLimited]) || data["code"] != domain.ErrRateLimited {
+				t.Errorf("Expected `%v` error, got: `%v`", domain.ErrRateLimited, rpcErr)
+			}
+		})
+	}
+	if observed := testutil.ToFloat64(rejected.WithLabelValues(anyName, "Scheduler.Get")); observed != 2 {
+		t.Errorf("Expected `%v`, got: `%v`", 2, observed)
+	}
+}
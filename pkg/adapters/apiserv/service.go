@@ -2,27 +2,20 @@ package apiserv
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"fmt"
 	"github.com/freundallein/scheduler/pkg/scheduler"
-	"io"
+	"net"
 	"net/http"
-	"net/rpc"
-	"net/rpc/jsonrpc"
+	"strings"
 	"time"
 
+	domain "github.com/freundallein/scheduler/pkg"
 	log "github.com/freundallein/scheduler/pkg/utils/logging"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// HttpConn adapts HTTP connection to ReadWriteCloser
-type HttpConn struct {
-	in  io.Reader
-	out io.Writer
-}
-
-func (c *HttpConn) Read(p []byte) (n int, err error)  { return c.in.Read(p) }
-func (c *HttpConn) Write(d []byte) (n int, err error) { return c.out.Write(d) }
-func (c *HttpConn) Close() error                      { return nil }
-
 // Service used as an endpoint for operations management.
 type Service struct {
 	httpserv *http.Server
@@ -32,66 +25,74 @@ type Service struct {
 	Token string
 	// WorkerToken is for worker's request authorization
 	WorkerToken string
+	// AdminToken is for admin's request authorization, admin API is disabled without it
+	AdminToken string
+	// Timeout limits a call, unless its method has a timeout in MethodTimeouts
+	Timeout time.Duration
+	// MethodTimeouts limits calls of methods, e.g. long polling "Scheduler.Wait"
+	MethodTimeouts map[string]time.Duration
+
+	tlsConfig *tls.Config
+	// workerCerts makes worker API accept verified client certificates,
+	// workerCertsRequired makes them the only way to access it.
+	workerCerts         bool
+	workerCertsRequired bool
+	authenticator       domain.Authenticator
+	bearerAuthenticator domain.Authenticator
+	rateLimits          []RateLimit
+	limiter             *rateLimiter
+	requestsCancelled   prometheus.Counter
+	requestsTimedOut    prometheus.Counter
+	requestsRateLimited *prometheus.CounterVec
 }
 
 // New returns service instance
 func New(service *scheduler.Service, opts ...Option) *Service {
-	svc := &Service{}
+	svc := &Service{
+		Timeout: 10 * time.Second,
+		// Long polling methods wait up to 30s.
+		MethodTimeouts: map[string]time.Duration{
+			"Scheduler.Wait": 40 * time.Second,
+			"Worker.Claim":   40 * time.Second,
+		},
+		requestsCancelled: prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_cancelled_total"}),
+		requestsTimedOut:  prometheus.NewCounter(prometheus.CounterOpts{Name: "requests_timed_out_total"}),
+		requestsRateLimited: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "requests_rate_limited_total"},
+			[]string{"client", "method"},
+		),
+	}
 	for _, opt := range opts {
 		opt(svc)
 	}
-	rpcServer := rpc.NewServer()
+	// Limits are shared by all APIs.
+	svc.limiter = newRateLimiter(svc.rateLimits, svc.requestsRateLimited)
+	rpcServer := newRPCServer(svc)
 	rpcServer.Register(&Scheduler{
 		svc: service,
 	})
-	rpcServer.Register(&Worker{
+	// Every API has its own RPC server, so its methods aren't available with other scopes.
+	workerServer := newRPCServer(svc)
+	workerServer.Register(&Worker{
 		svc: service,
 	})
 	mux := http.NewServeMux()
-	mux.HandleFunc(
-		"/rpc/v0",
-		func(w http.ResponseWriter, r *http.Request) {
-			auth := r.Header.Get("Auth")
-			if svc.Token != "" && auth != svc.Token {
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte("401 - not authorized"))
-				return
-			}
-			log.WithFields(log.Fields{
-				"auth": auth,
-			}).Debug("authentication_passed")
-			serverCodec := jsonrpc.NewServerCodec(&HttpConn{in: r.Body, out: w})
-			err := rpcServer.ServeRequest(serverCodec)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"err": err,
-				}).Error("err_while_serving_json_rpc")
-				return
-			}
-		},
-	)
-	mux.HandleFunc(
-		"/worker/v0",
-		func(w http.ResponseWriter, r *http.Request) {
-			auth := r.Header.Get("Auth")
-			if svc.WorkerToken != "" && auth != svc.WorkerToken {
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte("401 - not authorized"))
-				return
-			}
-			log.WithFields(log.Fields{
-				"auth": auth,
-			}).Debug("authentication_passed")
-			serverCodec := jsonrpc.NewServerCodec(&HttpConn{in: r.Body, out: w})
-			err := rpcServer.ServeRequest(serverCodec)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"err": err,
-				}).Error("err_while_serving_json_rpc")
-				return
-			}
-		},
-	)
+	mux.Handle("/rpc/v0", svc.authorized(domain.ScopeScheduler, svc.Token, rpcServer))
+	mux.Handle("/worker/v0", svc.authorized(domain.ScopeWorker, svc.WorkerToken, workerServer))
+	if svc.AdminToken != "" || svc.authenticator != nil || svc.bearerAuthenticator != nil {
+		adminServer := newRPCServer(svc)
+		adminServer.Register(&Admin{
+			svc: service,
+		})
+		// Dead letters and schedules of all producers are managed by operators only.
+		adminServer.Register(&DeadLetter{
+			svc: service,
+		})
+		adminServer.Register(&Schedule{
+			svc: service,
+		})
+		mux.Handle("/admin/v0", svc.authorized(domain.ScopeAdmin, svc.AdminToken, adminServer))
+	}
 	addr := fmt.Sprintf("0.0.0.0:%s", svc.Port)
 	svc.httpserv = &http.Server{
 		Handler:           mux,
@@ -104,11 +105,103 @@ func New(service *scheduler.Service, opts ...Option) *Service {
 	return svc
 }
 
-// Run starts the ops http server.
+// authorized serves requests of clients, that have access to the scope.
+func (svc *Service) authorized(scope domain.Scope, token string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, err := svc.authenticate(r, scope, token)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"scope": scope,
+				"err":   err,
+			}).Debug("authentication_failed")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("401 - not authorized"))
+			return
+		}
+		log.WithFields(log.Fields{
+			"scope":  scope,
+			"client": client,
+		}).Debug("authentication_passed")
+		handler.ServeHTTP(w, r.WithContext(withClient(r.Context(), client)))
+	})
+}
+
+// authenticate checks a client certificate on the worker API, if it's enabled,
+// then a bearer token in the Authorization header with the bearer authenticator,
+// otherwise the legacy Auth header with the scope's token and the authenticator.
+// Returns a name of the client. API is open, if nothing is configured.
+func (svc *Service) authenticate(r *http.Request, scope domain.Scope, token string) (string, error) {
+	if scope == domain.ScopeWorker && svc.workerCerts {
+		if name, ok := verifiedClient(r); ok {
+			return name, nil
+		}
+		if svc.workerCertsRequired {
+			return "", fmt.Errorf("client certificate is required")
+		}
+	}
+	if bearer, ok := bearerToken(r); ok {
+		if svc.bearerAuthenticator == nil {
+			return "", fmt.Errorf("bearer tokens aren't accepted")
+		}
+		return authorize(r.Context(), svc.bearerAuthenticator, bearer, scope)
+	}
+	auth := r.Header.Get("Auth")
+	if token != "" && subtle.ConstantTimeCompare([]byte(auth), []byte(token)) == 1 {
+		return fmt.Sprintf("%s_token", scope), nil
+	}
+	if svc.authenticator == nil {
+		if token == "" && svc.bearerAuthenticator == nil {
+			return "anonymous", nil
+		}
+		return "", fmt.Errorf("invalid token")
+	}
+	return authorize(r.Context(), svc.authenticator, auth, scope)
+}
+
+// authorize checks, that the token's credential grants the scope.
+func authorize(ctx context.Context, authenticator domain.Authenticator, token string, scope domain.Scope) (string, error) {
+	credential, err := authenticator.Authenticate(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	if !credential.Allows(scope) {
+		return "", fmt.Errorf("%q has no %s scope", credential.Name, scope)
+	}
+	return credential.Name, nil
+}
+
+// verifiedClient returns a common name of the client certificate, if TLS verified it.
+func verifiedClient(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName, true
+}
+
+// bearerToken returns a token of the "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+// Run starts the api http server, requests are cancelled with the context.
+// It's served over TLS, if TLS config is provided.
 func (svc *Service) Run(ctx context.Context) error {
+	svc.httpserv.BaseContext = func(net.Listener) context.Context {
+		return ctx
+	}
 	log.WithFields(log.Fields{
 		"addr": svc.httpserv.Addr,
+		"tls":  svc.tlsConfig != nil,
 	}).Info("api_svc_starting")
+	if svc.tlsConfig != nil {
+		svc.httpserv.TLSConfig = svc.tlsConfig
+		return svc.httpserv.ListenAndServeTLS("", "")
+	}
 	return svc.httpserv.ListenAndServe()
 }
 
@@ -0,0 +1,158 @@
+package apiserv
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/freundallein/scheduler/pkg/mock"
+	"github.com/freundallein/scheduler/pkg/scheduler"
+	"github.com/freundallein/scheduler/pkg/utils/certs/certstest"
+)
+
+// authenticatorFunc is a domain.Authenticator of a function.
+type authenticatorFunc func(ctx context.Context, token string) (*domain.Credential, error)
+
+func (f authenticatorFunc) Authenticate(ctx context.Context, token string) (*domain.Credential, error) {
+	return f(ctx, token)
+}
+
+func TestAuthorize(t *testing.T) {
+	authenticator := authenticatorFunc(func(ctx context.Context, token string) (*domain.Credential, error) {
+		if token != "billing:secret" {
+			return nil, fmt.Errorf("invalid credentials")
+		}
+		return &domain.Credential{Name: "billing", Scopes: []domain.Scope{domain.ScopeScheduler, domain.ScopeWorker}}, nil
+	})
+	tests := []struct {
+		name        string
+		token       string
+		scope       domain.Scope
+		expected    string
+		expectedErr bool
+	}{
+		{name: "granted scope", token: "billing:secret", scope: domain.ScopeWorker, expected: "billing"},
+		{name: "missing scope", token: "billing:secret", scope: domain.ScopeAdmin, expectedErr: true},
+		{name: "invalid token", token: "billing:wrong", scope: domain.ScopeScheduler, expectedErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			observed, err := authorize(context.Background(), authenticator, tc.token, tc.scope)
+			if (err != nil) != tc.expectedErr {
+				t.Errorf("Expected error: `%v`, got: `%v`", tc.expectedErr, err)
+			}
+			if observed != tc.expected {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expected, observed)
+			}
+		})
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	ca := certstest.NewCA(t)
+	pool := ca.Pool()
+	trusted := ca.Certificate(t, "reports", 2)
+	foreign := certstest.NewCA(t).Certificate(t, "reports", 2)
+	tests := []struct {
+		name           string
+		required       bool
+		path           string
+		certificate    *tls.Certificate
+		token          string
+		expectedStatus int
+		expectedErr    bool
+	}{
+		{
+			name:           "worker certificate",
+			required:       true,
+			path:           "/worker/v0",
+			certificate:    &trusted,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing required certificate",
+			required:       true,
+			path:           "/worker/v0",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "token instead of required certificate",
+			required:       true,
+			path:           "/worker/v0",
+			token:          "workertoken",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "token instead of optional certificate",
+			path:           "/worker/v0",
+			token:          "workertoken",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "foreign certificate",
+			required:    true,
+			path:        "/worker/v0",
+			certificate: &foreign,
+			expectedErr: true,
+		},
+		{
+			name:           "scheduler ignores certificate",
+			required:       true,
+			path:           "/rpc/v0",
+			certificate:    &trusted,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "scheduler token",
+			required:       true,
+			path:           "/rpc/v0",
+			certificate:    &trusted,
+			token:          "token",
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := New(
+				scheduler.New(&mock.Gateway{}),
+				WithToken("token"),
+				WithWorkerToken("workertoken"),
+				WithWorkerClientCerts(tc.required),
+			)
+			server := httptest.NewUnstartedServer(svc.httpserv.Handler)
+			server.TLS = &tls.Config{
+				Certificates: []tls.Certificate{ca.Certificate(t, "scheduler", 3)},
+				ClientAuth:   tls.VerifyClientCertIfGiven,
+				ClientCAs:    pool,
+			}
+			server.StartTLS()
+			defer server.Close()
+			config := &tls.Config{RootCAs: pool}
+			if tc.certificate != nil {
+				config.Certificates = []tls.Certificate{*tc.certificate}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+			request, err := http.NewRequest(http.MethodPost, server.URL+tc.path, strings.NewReader(`{"jsonrpc": "2.0", "method": "Missing.Method", "id": 1}`))
+			if err != nil {
+				t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+			}
+			request.Header.Set("Auth", tc.token)
+			response, err := client.Do(request)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("Expected error: `%v`, got: `%v`", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			response.Body.Close()
+			if response.StatusCode != tc.expectedStatus {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expectedStatus, response.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package credentials
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	log "github.com/freundallein/scheduler/pkg/utils/logging"
+)
+
+// hashPrefix marks a secret hash algorithm.
+const hashPrefix = "sha256:"
+
+// dummyHash is compared with secrets of unknown clients, so they take as long as known ones.
+var dummyHash = HashSecret("")
+
+// file is a credentials file layout.
+type file struct {
+	Clients []*domain.Credential `json:"clients"`
+}
+
+// Store authenticates API clients with credentials from a JSON file.
+// The file is reloaded, when it changes, so credentials are issued and revoked without restarts.
+type Store struct {
+	path           string
+	reloadInterval time.Duration
+
+	mu      sync.RWMutex
+	clients map[string]*domain.Credential
+	modTime time.Time
+}
+
+// New returns a domain.Authenticator implementation, the file should be valid.
+func New(path string, reloadInterval time.Duration) (*Store, error) {
+	store := &Store{
+		path:           path,
+		reloadInterval: reloadInterval,
+	}
+	_, err := store.reload()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Run reloads the file on changes until the context is cancelled.
+// Invalid file is skipped and the last valid credentials are kept.
+func (s *Store) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		reloaded, err := s.reload()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path": s.path,
+				"err":  err,
+			}).Error("credentials_reload_failed")
+			continue
+		}
+		if reloaded {
+			log.WithFields(log.Fields{
+				"path": s.path,
+			}).Info("credentials_reloaded")
+		}
+	}
+}
+
+// reload reads the file, if it was modified since the last read.
+func (s *Store) reload() (bool, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	modTime := s.modTime
+	s.mu.RUnlock()
+	if info.ModTime().Equal(modTime) {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return false, err
+	}
+	var parsed file
+	err = json.Unmarshal(data, &parsed)
+	if err != nil {
+		return false, err
+	}
+	clients := make(map[string]*domain.Credential, len(parsed.Clients))
+	for i, client := range parsed.Clients {
+		if client == nil || client.Name == "" {
+			return false, fmt.Errorf("clients[%d]: name should not be empty", i)
+		}
+		if strings.Contains(client.Name, ":") {
+			return false, fmt.Errorf("clients[%d]: name should not contain ':'", i)
+		}
+		if !strings.HasPrefix(client.SecretHash, hashPrefix) {
+			return false, fmt.Errorf("clients[%d]: secret hash should start with %q", i, hashPrefix)
+		}
+		for _, scope := range client.Scopes {
+			if !scope.Valid() {
+				return false, fmt.Errorf("clients[%d]: unknown scope %q", i, scope)
+			}
+		}
+		if _, ok := clients[client.Name]; ok {
+			return false, fmt.Errorf("clients[%d]: duplicate name %q", i, client.Name)
+		}
+		clients[client.Name] = client
+	}
+	s.mu.Lock()
+	s.clients = clients
+	s.modTime = info.ModTime()
+	s.mu.Unlock()
+	return true, nil
+}
+
+// Authenticate checks a token of the "name:secret" form.
+func (s *Store) Authenticate(ctx context.Context, token string) (*domain.Credential, error) {
+	var name, secret string
+	if idx := strings.Index(token, ":"); idx >= 0 {
+		name, secret = token[:idx], token[idx+1:]
+	}
+	s.mu.RLock()
+	client, known := s.clients[name]
+	s.mu.RUnlock()
+	expected := dummyHash
+	if known {
+		expected = client.SecretHash
+	}
+	if subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(expected)) != 1 || !known {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+	if client.Expired(time.Now()) {
+		return nil, fmt.Errorf("credentials of %q expired", client.Name)
+	}
+	return client, nil
+}
+
+// HashSecret returns a hash of a secret to store in the credentials file.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// NewSecret returns a random secret.
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,142 @@
+package credentials
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFile writes the credentials file with a modification time after the previous one.
+func writeFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	writeFile(t, path, `{"clients": [
+		{"name": "billing", "secretHash": "`+HashSecret("secret")+`", "scopes": ["scheduler"]},
+		{"name": "reports", "secretHash": "`+HashSecret("with:colon")+`", "scopes": ["worker"]},
+		{"name": "legacy", "secretHash": "`+HashSecret("secret")+`", "scopes": ["admin"], "expiresAt": "2000-01-01T00:00:00Z"}
+	]}`, time.Now())
+	store, err := New(path, time.Second)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	tests := []struct {
+		name        string
+		token       string
+		expected    string
+		expectedErr string
+	}{
+		{name: "valid", token: "billing:secret", expected: "billing"},
+		{name: "secret with colon", token: "reports:with:colon", expected: "reports"},
+		{name: "wrong secret", token: "billing:wrong", expectedErr: "invalid credentials"},
+		{name: "unknown client", token: "unknown:secret", expectedErr: "invalid credentials"},
+		{name: "without separator", token: "billingsecret", expectedErr: "invalid credentials"},
+		{name: "empty token", token: "", expectedErr: "invalid credentials"},
+		{name: "expired", token: "legacy:secret", expectedErr: `credentials of "legacy" expired`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			credential, err := store.Authenticate(context.Background(), tc.token)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Errorf("Expected `%v`, got: `%v`", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+			}
+			if credential.Name != tc.expected {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expected, credential.Name)
+			}
+		})
+	}
+}
+
+func TestNewInvalidFile(t *testing.T) {
+	hash := HashSecret("secret")
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "malformed", content: `{"clients": [`},
+		{name: "empty name", content: `{"clients": [{"name": "", "secretHash": "` + hash + `"}]}`},
+		{name: "name with colon", content: `{"clients": [{"name": "a:b", "secretHash": "` + hash + `"}]}`},
+		{name: "plain secret", content: `{"clients": [{"name": "billing", "secretHash": "secret"}]}`},
+		{name: "unknown scope", content: `{"clients": [{"name": "billing", "secretHash": "` + hash + `", "scopes": ["admn"]}]}`},
+		{
+			name: "duplicate names",
+			content: `{"clients": [
+				{"name": "billing", "secretHash": "` + hash + `", "scopes": ["scheduler"]},
+				{"name": "billing", "secretHash": "` + hash + `", "scopes": ["admin"]}
+			]}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "credentials.json")
+			writeFile(t, path, tc.content, time.Now())
+			_, err := New(path, time.Second)
+			if err == nil {
+				t.Errorf("Expected error, got: `%v`", err)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	modTime := time.Now().Add(-time.Hour)
+	writeFile(t, path, `{"clients": [{"name": "billing", "secretHash": "`+HashSecret("old")+`", "scopes": ["scheduler"]}]}`, modTime)
+	store, err := New(path, time.Second)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	reloaded, err := store.reload()
+	if reloaded || err != nil {
+		t.Errorf("Expected unchanged file to be skipped, got: `%v`, `%v`", reloaded, err)
+	}
+
+	modTime = modTime.Add(time.Minute)
+	writeFile(t, path, `{"clients": [{"name": "billing", "secretHash": "`+HashSecret("new")+`", "scopes": ["admn"]}]}`, modTime)
+	reloaded, err = store.reload()
+	if reloaded || err == nil {
+		t.Errorf("Expected invalid file to be rejected, got: `%v`, `%v`", reloaded, err)
+	}
+	_, err = store.Authenticate(ctx, "billing:old")
+	if err != nil {
+		t.Errorf("Expected the last valid credentials to be kept, got: `%v`", err)
+	}
+
+	modTime = modTime.Add(time.Minute)
+	writeFile(t, path, `{"clients": [{"name": "billing", "secretHash": "`+HashSecret("new")+`", "scopes": ["admin"]}]}`, modTime)
+	reloaded, err = store.reload()
+	if !reloaded || err != nil {
+		t.Fatalf("Expected file to be reloaded, got: `%v`, `%v`", reloaded, err)
+	}
+	_, err = store.Authenticate(ctx, "billing:old")
+	if err == nil {
+		t.Errorf("Expected revoked secret to fail, got: `%v`", err)
+	}
+	credential, err := store.Authenticate(ctx, "billing:new")
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	if len(credential.Scopes) != 1 || credential.Scopes[0] != "admin" {
+		t.Errorf("Expected `%v`, got: `%v`", "[admin]", credential.Scopes)
+	}
+}
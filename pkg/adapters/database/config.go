@@ -0,0 +1,4 @@
+package database
+
+// Option is used to configure TaskGateway.
+type Option func(gw *TaskGateway)
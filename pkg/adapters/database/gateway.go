@@ -3,10 +3,14 @@ package database
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	domain "github.com/freundallein/scheduler/pkg"
+	log "github.com/freundallein/scheduler/pkg/utils/logging"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -16,7 +20,7 @@ type TaskGateway struct {
 }
 
 // NewTaskGateway return task gateway implementation.
-func NewTaskGateway(dsn string) (domain.Gateway, error) {
+func NewTaskGateway(dsn string, opts ...Option) (domain.Gateway, error) {
 	poolConfig, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
@@ -31,120 +35,711 @@ func NewTaskGateway(dsn string) (domain.Gateway, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &TaskGateway{pool: pool}, nil
+	for _, upgrade := range schemeUpgrades {
+		_, err = pool.Exec(ctx, upgrade)
+		if err != nil {
+			return nil, err
+		}
+	}
+	gw := &TaskGateway{
+		pool: pool,
+	}
+	for _, opt := range opts {
+		opt(gw)
+	}
+	return gw, nil
 }
 
-//Create is for a task creation, returns a created task.
-func (gw *TaskGateway) Create(ctx context.Context, task *domain.Task) (*domain.Task, error) {
-	row := gw.pool.QueryRow(ctx, create, task.ID, task.ExecuteAt, task.Deadline, task.Payload, task.Meta)
+// scanTask reads a task from a row with task columns.
+func scanTask(row pgx.Row) (*domain.Task, error) {
+	task := &domain.Task{}
 	err := row.Scan(
 		&task.ID,
 		&task.ClaimID,
 		&task.State,
 		&task.ExecuteAt,
 		&task.Deadline,
+		&task.Queue,
+		&task.Priority,
 		&task.Payload,
 		&task.Result,
 		&task.Meta,
+		&task.RetryPolicy,
+		&task.CancelRequested,
+		&task.CallbackURL,
 		&task.CreatedAt,
+		&task.DoneAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+	task.ExecuteAt = task.ExecuteAt.UTC()
+	task.Deadline = task.Deadline.UTC()
+	return task, nil
+}
+
+// Create is for a task creation, returns a created task.
+func (gw *TaskGateway) Create(ctx context.Context, task *domain.Task) (*domain.Task, error) {
+	row := gw.pool.QueryRow(ctx, create, task.ID, task.ExecuteAt, task.Deadline, task.Queue, task.Priority, task.Payload, task.Meta, task.RetryPolicy, task.CallbackURL)
+	task, err := scanTask(row)
 	if err != nil {
 		if err.Error() == `ERROR: duplicate key value violates unique constraint "task_pkey" (SQLSTATE 23505)` {
 			return nil, domain.Error{Code: domain.ErrDuplicateTask, Inner: err, Message: "task already set"}
 		}
 		return nil, err
 	}
-	task.ExecuteAt = task.ExecuteAt.UTC()
-	task.Deadline = task.Deadline.UTC()
 	return task, nil
 }
 
+// taskRow is a task representation for multi-row inserts.
+type taskRow struct {
+	ID          uuid.UUID              `json:"id"`
+	ExecuteAt   time.Time              `json:"execute_at"`
+	Deadline    time.Time              `json:"deadline"`
+	Queue       string                 `json:"queue"`
+	Priority    int                    `json:"priority"`
+	Payload     map[string]interface{} `json:"payload"`
+	Meta        map[string]interface{} `json:"meta,omitempty"`
+	RetryPolicy *domain.RetryPolicy    `json:"retry_policy,omitempty"`
+	CallbackURL string                 `json:"callback_url,omitempty"`
+}
+
+// CreateMany makes records with new tasks with a single statement.
+// Tasks with existing IDs are skipped and reported with ErrDuplicateTask,
+// results are in the same order as tasks.
+func (gw *TaskGateway) CreateMany(ctx context.Context, tasks []*domain.Task) ([]domain.BatchResult, error) {
+	batch := make([]taskRow, 0, len(tasks))
+	for _, task := range tasks {
+		batch = append(batch, taskRow{
+			ID:          task.ID,
+			ExecuteAt:   task.ExecuteAt,
+			Deadline:    task.Deadline,
+			Queue:       task.Queue,
+			Priority:    task.Priority,
+			Payload:     task.Payload,
+			Meta:        task.Meta,
+			RetryPolicy: task.RetryPolicy,
+			CallbackURL: task.CallbackURL,
+		})
+	}
+	rows, err := gw.pool.Query(ctx, createMany, batch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	created := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		created[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	results := make([]domain.BatchResult, 0, len(tasks))
+	for _, task := range tasks {
+		result := domain.BatchResult{ID: task.ID}
+		if created[task.ID] {
+			// The same ID later in the batch is a duplicate.
+			delete(created, task.ID)
+		} else {
+			result.Err = domain.Error{Code: domain.ErrDuplicateTask, Message: "task already set"}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
 // FindByID returns a task by id.
 func (gw *TaskGateway) FindByID(ctx context.Context, id uuid.UUID) (*domain.Task, error) {
-	task := &domain.Task{}
 	row := gw.pool.QueryRow(ctx, findByID, id)
-	err := row.Scan(
-		&task.ID,
-		&task.ClaimID,
-		&task.State,
-		&task.ExecuteAt,
-		&task.Deadline,
-		&task.Payload,
-		&task.Result,
-		&task.Meta,
-		&task.CreatedAt,
-		&task.DoneAt,
-	)
+	task, err := scanTask(row)
 	if err != nil {
 		if err.Error() == "no rows in result set" {
 			return nil, domain.Error{Code: domain.ErrTaskNotFound, Message: "task not found"}
 		}
 		return nil, err
 	}
-	task.ExecuteAt = task.ExecuteAt.UTC()
-	task.Deadline = task.Deadline.UTC()
 	return task, nil
 }
 
+// taskOrderColumns maps sort orders to columns, it's used for query formatting.
+var taskOrderColumns = map[domain.TaskOrder]string{
+	domain.OrderByCreatedAt: "created_at",
+	domain.OrderByExecuteAt: "execute_at",
+	domain.OrderByDoneAt:    "done_at",
+}
+
+// ListTasks returns filtered tasks sorted by query.OrderBy and id.
+// Tasks without a sort key, e.g. unfinished ones for OrderByDoneAt, are skipped.
+func (gw *TaskGateway) ListTasks(ctx context.Context, query domain.TaskQuery) ([]*domain.Task, error) {
+	column, ok := taskOrderColumns[query.OrderBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown order %q", query.OrderBy)
+	}
+	direction, operator := "asc", ">"
+	if query.Desc {
+		direction, operator = "desc", "<"
+	}
+	var states []string
+	for _, state := range query.Filter.States {
+		states = append(states, string(state))
+	}
+	var (
+		afterAt interface{}
+		afterID interface{}
+	)
+	if query.After != nil {
+		afterAt, afterID = query.After.At, query.After.ID
+	}
+	filter := query.Filter
+	rows, err := gw.pool.Query(
+		ctx,
+		fmt.Sprintf(listTasks, column, direction, operator),
+		nullStrings(states),
+		nullStrings(filter.Queues),
+		nullTime(filter.CreatedFrom),
+		nullTime(filter.CreatedTo),
+		nullTime(filter.ExecuteFrom),
+		nullTime(filter.ExecuteTo),
+		nullTime(filter.DoneFrom),
+		nullTime(filter.DoneTo),
+		filter.MinAttempts,
+		filter.MaxAttempts,
+		afterAt,
+		afterID,
+		query.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tasks := make([]*domain.Task, 0)
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// nullTime turns zero time into NULL.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// nullStrings turns an empty slice into NULL.
+func nullStrings(values []string) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
 // ClaimPending locks and returns pending (or next-attempt failed) task.
-func (gw *TaskGateway) ClaimPending(ctx context.Context, amount int) ([]*domain.Task, error) {
+// Tasks are claimed from any queue, unless request names the queues.
+// Urgent tasks are claimed first, waiting tasks are aged with request.Aging.
+func (gw *TaskGateway) ClaimPending(ctx context.Context, request domain.ClaimRequest) ([]*domain.Task, error) {
 	tasks := make([]*domain.Task, 0)
-	rows, err := gw.pool.Query(ctx, claimPending, domain.StateProcessing, domain.StateSucceeded, amount)
+	query := fmt.Sprintf(claimPending, claimOrder)
+	args := []interface{}{domain.StateProcessing, request.Amount, request.Lease, nullStrings(request.Queues)}
+	if request.Aging > 0 {
+		query = fmt.Sprintf(claimPending, agedClaimOrder)
+		args = append(args, request.Aging.Seconds(), domain.MaxPriority)
+	}
+	rows, err := gw.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	for rows.Next() {
-		task := &domain.Task{}
-		err := rows.Scan(
-			&task.ID,
-			&task.ClaimID,
-			&task.State,
-			&task.ExecuteAt,
-			&task.Deadline,
-			&task.Payload,
-			&task.Result,
-			&task.Meta,
-			&task.CreatedAt,
-		)
-		task.ExecuteAt = task.ExecuteAt.UTC()
-		task.Deadline = task.Deadline.UTC()
+		task, err := scanTask(rows)
 		if err != nil {
 			return nil, err
 		}
 		tasks = append(tasks, task)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	if len(tasks) == 0 {
 		return nil, domain.Error{Code: domain.ErrNoPendingTasks, Message: "no pending tasks"}
 	}
 	return tasks, nil
 }
 
+// NextExecuteAt returns the earliest execution time of claimable tasks in queues.
+// Any queue is considered, if queues are empty.
+func (gw *TaskGateway) NextExecuteAt(ctx context.Context, queues []string) (time.Time, error) {
+	var executeAt *time.Time
+	err := gw.pool.QueryRow(ctx, nextExecuteAt, nullStrings(queues)).Scan(&executeAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if executeAt == nil {
+		return time.Time{}, domain.Error{Code: domain.ErrNoPendingTasks, Message: "no pending tasks"}
+	}
+	return executeAt.UTC(), nil
+}
+
+const (
+	// minListenDelay is a delay before the first attempt to listen again after a failure.
+	minListenDelay = 100 * time.Millisecond
+	// maxListenDelay limits delays between attempts to listen, while the database is unavailable.
+	maxListenDelay = 10 * time.Second
+)
+
+// ListenTasks calls notify, when a task becomes claimable or finished.
+// It holds a dedicated connection and blocks until ctx is done.
+// A lost connection is reestablished with backoff, undecodable notifications are skipped.
+func (gw *TaskGateway) ListenTasks(ctx context.Context, notify func(event domain.TaskEvent)) error {
+	delay := minListenDelay
+	for {
+		listening, err := gw.listenTasks(ctx, notify)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if listening {
+			delay = minListenDelay
+		}
+		log.WithFields(log.Fields{
+			"err":   err,
+			"delay": delay.String(),
+		}).Error("database_listen_tasks_failure")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxListenDelay {
+			delay = maxListenDelay
+		}
+	}
+}
+
+// listenTasks calls notify on a dedicated connection until it fails,
+// reports whether the connection was listening before the failure.
+func (gw *TaskGateway) listenTasks(ctx context.Context, notify func(event domain.TaskEvent)) (bool, error) {
+	conn, err := gw.pool.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+	_, err = conn.Exec(ctx, listenTasks)
+	if err != nil {
+		return false, err
+	}
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		var event domain.TaskEvent
+		err = json.Unmarshal([]byte(notification.Payload), &event)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"payload": notification.Payload,
+			}).Warning("database_task_notification_skipped")
+			continue
+		}
+		notify(event)
+	}
+}
+
+// Cancel withdraws a task. Pending and failed tasks become cancelled at once,
+// processing task is marked as cancel-requested until its worker reports back.
+func (gw *TaskGateway) Cancel(ctx context.Context, id uuid.UUID) (*domain.Task, bool, error) {
+	row := gw.pool.QueryRow(ctx, cancel, id)
+	task, err := scanTask(row)
+	if err == nil {
+		return task, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, err
+	}
+	task, err = gw.FindByID(ctx, id)
+	if err != nil {
+		return nil, false, err
+	}
+	if task.State != domain.StateCancelled && !task.CancelRequested {
+		return nil, false, domain.Error{Code: domain.ErrTaskFinished, Message: "task is already finished"}
+	}
+	return task, false, nil
+}
+
 // MarkAsSucceeded marks a task as succefully processed.
+// Result, that came after the task's deadline, is rejected and the task becomes expired.
 func (gw *TaskGateway) MarkAsSucceeded(ctx context.Context, id, claimID uuid.UUID, result map[string]interface{}) error {
 	resultJSON, err := json.Marshal(result)
 	if err != nil {
 		return err
 	}
-	tag, err := gw.pool.Exec(ctx, markAsSucceeded, domain.StateSucceeded, id, claimID, string(resultJSON))
+	tx, err := gw.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var overdue, cancelRequested bool
+	row := tx.QueryRow(ctx, lockSucceeded, id, claimID)
+	err = row.Scan(&overdue, &cancelRequested)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Error{Code: domain.ErrStaleResult, Message: "result is stale"}
+		}
+		return err
+	}
+	if cancelRequested {
+		return gw.finishCancelled(ctx, tx, id)
+	}
+	if overdue {
+		_, err = tx.Exec(ctx, markAsExpired, id)
+		if err != nil {
+			return err
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			return err
+		}
+		return domain.Error{Code: domain.ErrDeadlineExceeded, Message: "deadline exceeded"}
+	}
+	tag, err := tx.Exec(ctx, markAsSucceeded, domain.StateSucceeded, id, claimID, string(resultJSON))
 	if err != nil {
 		return err
 	}
 	if tag.RowsAffected() != 1 {
 		return domain.Error{Code: domain.ErrStaleResult, Message: "result is stale"}
 	}
-	return nil
+	return tx.Commit(ctx)
 }
 
-// MarkAsFailed marks a task as failed.
+// MarkAsFailed marks a task as failed and plans the next attempt according to the task's retry policy.
+// If there are no attempts left, the task becomes exhausted.
 func (gw *TaskGateway) MarkAsFailed(ctx context.Context, id, claimID uuid.UUID, reason string) error {
-	tag, err := gw.pool.Exec(ctx, markAsFailed, domain.StateFailed, id, claimID, map[string]string{"failReason": reason})
+	tx, err := gw.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var (
+		attempts        int
+		policy          *domain.RetryPolicy
+		cancelRequested bool
+	)
+	row := tx.QueryRow(ctx, lockClaimed, id, claimID)
+	err = row.Scan(&attempts, &policy, &cancelRequested)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Error{Code: domain.ErrStaleResult, Message: "result is stale"}
+		}
+		return err
+	}
+	if cancelRequested {
+		return gw.finishCancelled(ctx, tx, id)
+	}
+	attempts++
+	state := domain.StateFailed
+	if policy.Exhausted(attempts) {
+		state = domain.StateExhausted
+	}
+	executeAt := time.Now().UTC().Add(policy.Backoff(attempts))
+	meta := map[string]interface{}{
+		"failReason": reason,
+		"attempts":   attempts,
+	}
+	failure := domain.Failure{
+		Attempt:  attempts,
+		Reason:   reason,
+		FailedAt: time.Now().UTC(),
+	}
+	tag, err := tx.Exec(ctx, markAsFailed, state, id, claimID, executeAt, meta, failure)
 	if err != nil {
 		return err
 	}
 	if tag.RowsAffected() != 1 {
 		return domain.Error{Code: domain.ErrStaleResult, Message: "result is stale"}
 	}
-	return nil
+	return tx.Commit(ctx)
+}
+
+// outcomeRow is an outcome representation for multi-row updates.
+type outcomeRow struct {
+	ID      uuid.UUID              `json:"id"`
+	ClaimID uuid.UUID              `json:"claim_id"`
+	Result  map[string]interface{} `json:"result,omitempty"`
+	Reason  string                 `json:"reason,omitempty"`
+}
+
+// MarkManyAsSucceeded marks tasks as successfully processed with a single statement.
+// Errors are reported per task the same way as MarkAsSucceeded does,
+// results are in the same order as outcomes.
+func (gw *TaskGateway) MarkManyAsSucceeded(ctx context.Context, outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	batch := make([]outcomeRow, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		batch = append(batch, outcomeRow{
+			ID:      outcome.ID,
+			ClaimID: outcome.ClaimID,
+			Result:  outcome.Result,
+		})
+	}
+	rows, err := gw.pool.Query(ctx, markManyAsSucceeded, batch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	states := map[uuid.UUID]domain.State{}
+	for rows.Next() {
+		var (
+			id    uuid.UUID
+			state domain.State
+		)
+		err := rows.Scan(&id, &state)
+		if err != nil {
+			return nil, err
+		}
+		states[id] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return outcomeResults(outcomes, states), nil
+}
+
+// failureRow is a failed task with its next attempt for multi-row updates.
+type failureRow struct {
+	ID        uuid.UUID    `json:"id"`
+	ClaimID   uuid.UUID    `json:"claim_id"`
+	Reason    string       `json:"reason"`
+	State     domain.State `json:"state"`
+	Attempts  int          `json:"attempts"`
+	ExecuteAt time.Time    `json:"execute_at"`
+}
+
+// claimedTask is a locked task, which next attempt is planned.
+type claimedTask struct {
+	claimID  uuid.UUID
+	attempts int
+	policy   *domain.RetryPolicy
+}
+
+// MarkManyAsFailed marks tasks as failed and plans their next attempts like MarkAsFailed does.
+// Tasks are locked with a single statement, then updated with another one,
+// results are in the same order as outcomes.
+func (gw *TaskGateway) MarkManyAsFailed(ctx context.Context, outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	batch := make([]outcomeRow, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		batch = append(batch, outcomeRow{
+			ID:      outcome.ID,
+			ClaimID: outcome.ClaimID,
+		})
+	}
+	tx, err := gw.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	claimed, err := lockManyClaimedTasks(ctx, tx, batch)
+	if err != nil {
+		return nil, err
+	}
+	// Attempts are planned with domain.RetryPolicy, so a batch fails tasks the same way as MarkAsFailed.
+	planned := make([]failureRow, 0, len(claimed))
+	for _, outcome := range outcomes {
+		task, ok := claimed[outcome.ID]
+		if !ok || task.claimID != outcome.ClaimID {
+			continue
+		}
+		// Repeated outcomes are stale.
+		delete(claimed, outcome.ID)
+		attempts := task.attempts + 1
+		state := domain.StateFailed
+		if task.policy.Exhausted(attempts) {
+			state = domain.StateExhausted
+		}
+		planned = append(planned, failureRow{
+			ID:        outcome.ID,
+			ClaimID:   outcome.ClaimID,
+			Reason:    outcome.Reason,
+			State:     state,
+			Attempts:  attempts,
+			ExecuteAt: time.Now().UTC().Add(task.policy.Backoff(attempts)),
+		})
+	}
+	states := map[uuid.UUID]domain.State{}
+	if len(planned) > 0 {
+		rows, err := tx.Query(ctx, markManyAsFailed, planned)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				id    uuid.UUID
+				state domain.State
+			)
+			err := rows.Scan(&id, &state)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			states[id] = state
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return outcomeResults(outcomes, states), nil
+}
+
+// lockManyClaimedTasks locks claimed tasks of the batch, their attempts are planned by the caller.
+func lockManyClaimedTasks(ctx context.Context, tx pgx.Tx, batch []outcomeRow) (map[uuid.UUID]claimedTask, error) {
+	rows, err := tx.Query(ctx, lockManyClaimed, batch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	claimed := map[uuid.UUID]claimedTask{}
+	for rows.Next() {
+		var (
+			id   uuid.UUID
+			task claimedTask
+		)
+		err := rows.Scan(&id, &task.claimID, &task.attempts, &task.policy)
+		if err != nil {
+			return nil, err
+		}
+		claimed[id] = task
+	}
+	return claimed, rows.Err()
+}
+
+// outcomeResults reports tasks' new states as errors of single-task operations.
+// Missing and repeated tasks are reported as stale results.
+func outcomeResults(outcomes []domain.Outcome, states map[uuid.UUID]domain.State) []domain.BatchResult {
+	results := make([]domain.BatchResult, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		result := domain.BatchResult{ID: outcome.ID}
+		state, ok := states[outcome.ID]
+		delete(states, outcome.ID)
+		switch {
+		case !ok:
+			result.Err = domain.Error{Code: domain.ErrStaleResult, Message: "result is stale"}
+		case state == domain.StateCancelled:
+			result.Err = domain.Error{Code: domain.ErrTaskCancelled, Message: "task was cancelled"}
+		case state == domain.StateExpired:
+			result.Err = domain.Error{Code: domain.ErrDeadlineExceeded, Message: "deadline exceeded"}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// ExtendLease prolongs a claimed task lease, returns the new lease expiration time.
+// Lease of a cancelled or overdue task isn't prolonged.
+func (gw *TaskGateway) ExtendLease(ctx context.Context, id, claimID uuid.UUID, lease time.Duration) (time.Time, error) {
+	tx, err := gw.pool.Begin(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback(ctx)
+	var overdue, cancelRequested bool
+	row := tx.QueryRow(ctx, lockLeased, id, claimID)
+	err = row.Scan(&overdue, &cancelRequested)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, domain.Error{Code: domain.ErrStaleResult, Message: "claim is stale"}
+		}
+		return time.Time{}, err
+	}
+	if cancelRequested {
+		return time.Time{}, gw.finishCancelled(ctx, tx, id)
+	}
+	if overdue {
+		_, err = tx.Exec(ctx, markAsExpired, id)
+		if err != nil {
+			return time.Time{}, err
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Time{}, domain.Error{Code: domain.ErrDeadlineExceeded, Message: "deadline exceeded"}
+	}
+	var leasedUntil time.Time
+	row = tx.QueryRow(ctx, extendLease, id, claimID, lease)
+	err = row.Scan(&leasedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return leasedUntil.UTC(), tx.Commit(ctx)
+}
+
+// ReleaseTask makes a claimed task claimable at once, its attempts aren't changed.
+// A cancelled or overdue task is finished instead.
+func (gw *TaskGateway) ReleaseTask(ctx context.Context, id, claimID uuid.UUID) error {
+	tx, err := gw.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var overdue, cancelRequested bool
+	row := tx.QueryRow(ctx, lockLeased, id, claimID)
+	err = row.Scan(&overdue, &cancelRequested)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Error{Code: domain.ErrStaleResult, Message: "claim is stale"}
+		}
+		return err
+	}
+	if cancelRequested {
+		return gw.finishCancelled(ctx, tx, id)
+	}
+	if overdue {
+		_, err = tx.Exec(ctx, markAsExpired, id)
+		if err != nil {
+			return err
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			return err
+		}
+		return domain.Error{Code: domain.ErrDeadlineExceeded, Message: "deadline exceeded"}
+	}
+	_, err = tx.Exec(ctx, releaseTask, id, claimID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// finishCancelled marks a cancel-requested task as cancelled within tx
+// and reports the cancellation to a worker.
+func (gw *TaskGateway) finishCancelled(ctx context.Context, tx pgx.Tx, id uuid.UUID) error {
+	_, err := tx.Exec(ctx, markAsCancelled, id)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	return domain.Error{Code: domain.ErrTaskCancelled, Message: "task was cancelled"}
 }
 
 // DeleteStaleTasks removes stale tasks.
@@ -155,3 +750,326 @@ func (gw *TaskGateway) DeleteStaleTasks(ctx context.Context, staleHours int) (in
 	}
 	return tag.RowsAffected(), nil
 }
+
+// CancelAbandonedTasks marks cancel-requested tasks with an expired lease as cancelled.
+func (gw *TaskGateway) CancelAbandonedTasks(ctx context.Context) (int64, error) {
+	tag, err := gw.pool.Exec(ctx, cancelAbandonedTasks)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ExpireOverdueTasks marks unfinished tasks after their deadline as expired.
+func (gw *TaskGateway) ExpireOverdueTasks(ctx context.Context) (int64, error) {
+	tag, err := gw.pool.Exec(ctx, expireOverdueTasks)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// MoveExhaustedTasks moves exhausted tasks to dead letters.
+func (gw *TaskGateway) MoveExhaustedTasks(ctx context.Context) (int64, error) {
+	tag, err := gw.pool.Exec(ctx, moveExhaustedTasks)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ListDeadLetters returns dead letters ordered by death time.
+func (gw *TaskGateway) ListDeadLetters(ctx context.Context, limit, offset int) ([]*domain.DeadLetter, error) {
+	letters := make([]*domain.DeadLetter, 0)
+	rows, err := gw.pool.Query(ctx, listDeadLetters, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		letter := &domain.DeadLetter{}
+		err := rows.Scan(
+			&letter.ID,
+			&letter.ExecuteAt,
+			&letter.Deadline,
+			&letter.Queue,
+			&letter.Priority,
+			&letter.Payload,
+			&letter.RetryPolicy,
+			&letter.FailReason,
+			&letter.Attempts,
+			&letter.History,
+			&letter.CreatedAt,
+			&letter.DeadAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+// FindDeadLetterByID returns a dead letter by task id.
+func (gw *TaskGateway) FindDeadLetterByID(ctx context.Context, id uuid.UUID) (*domain.DeadLetter, error) {
+	letter := &domain.DeadLetter{}
+	row := gw.pool.QueryRow(ctx, findDeadLetterByID, id)
+	err := row.Scan(
+		&letter.ID,
+		&letter.ExecuteAt,
+		&letter.Deadline,
+		&letter.Queue,
+		&letter.Priority,
+		&letter.Payload,
+		&letter.RetryPolicy,
+		&letter.FailReason,
+		&letter.Attempts,
+		&letter.History,
+		&letter.CreatedAt,
+		&letter.DeadAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.Error{Code: domain.ErrTaskNotFound, Message: "dead letter not found"}
+		}
+		return nil, err
+	}
+	return letter, nil
+}
+
+// RequeueDeadLetter moves a dead letter back to task as a pending one.
+func (gw *TaskGateway) RequeueDeadLetter(ctx context.Context, id uuid.UUID) (*domain.Task, error) {
+	row := gw.pool.QueryRow(ctx, requeueDeadLetter, id)
+	task, err := scanTask(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.Error{Code: domain.ErrTaskNotFound, Message: "dead letter not found"}
+		}
+		if err.Error() == `ERROR: duplicate key value violates unique constraint "task_pkey" (SQLSTATE 23505)` {
+			return nil, domain.Error{Code: domain.ErrDuplicateTask, Inner: err, Message: "task already set"}
+		}
+		return nil, err
+	}
+	return task, nil
+}
+
+// PurgeDeadLetters removes dead letters by task ids.
+func (gw *TaskGateway) PurgeDeadLetters(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	tag, err := gw.pool.Exec(ctx, purgeDeadLetters, ids)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// scanSchedule reads a schedule from a row with schedule columns.
+func scanSchedule(row pgx.Row) (*domain.Schedule, error) {
+	schedule := &domain.Schedule{}
+	err := row.Scan(
+		&schedule.ID,
+		&schedule.Cron,
+		&schedule.Timezone,
+		&schedule.Queue,
+		&schedule.Priority,
+		&schedule.Payload,
+		&schedule.RetryPolicy,
+		&schedule.TTL,
+		&schedule.MisfirePolicy,
+		&schedule.NextRunAt,
+		&schedule.LastRunAt,
+		&schedule.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	schedule.NextRunAt = schedule.NextRunAt.UTC()
+	return schedule, nil
+}
+
+// CreateSchedule makes record with new schedule.
+func (gw *TaskGateway) CreateSchedule(ctx context.Context, schedule *domain.Schedule) (*domain.Schedule, error) {
+	row := gw.pool.QueryRow(
+		ctx,
+		createSchedule,
+		schedule.ID,
+		schedule.Cron,
+		schedule.Timezone,
+		schedule.Queue,
+		schedule.Priority,
+		schedule.Payload,
+		schedule.RetryPolicy,
+		schedule.TTL,
+		schedule.MisfirePolicy,
+		schedule.NextRunAt,
+	)
+	schedule, err := scanSchedule(row)
+	if err != nil {
+		if err.Error() == `ERROR: duplicate key value violates unique constraint "schedule_pkey" (SQLSTATE 23505)` {
+			return nil, domain.Error{Code: domain.ErrDuplicateTask, Inner: err, Message: "schedule already set"}
+		}
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// FindScheduleByID returns a schedule by id.
+func (gw *TaskGateway) FindScheduleByID(ctx context.Context, id uuid.UUID) (*domain.Schedule, error) {
+	row := gw.pool.QueryRow(ctx, findScheduleByID, id)
+	schedule, err := scanSchedule(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, domain.Error{Code: domain.ErrScheduleNotFound, Message: "schedule not found"}
+		}
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// ListSchedules returns schedules ordered by creation time.
+func (gw *TaskGateway) ListSchedules(ctx context.Context, limit, offset int) ([]*domain.Schedule, error) {
+	return gw.querySchedules(ctx, listSchedules, limit, offset)
+}
+
+// DeleteSchedule removes a schedule.
+func (gw *TaskGateway) DeleteSchedule(ctx context.Context, id uuid.UUID) error {
+	tag, err := gw.pool.Exec(ctx, deleteSchedule, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return domain.Error{Code: domain.ErrScheduleNotFound, Message: "schedule not found"}
+	}
+	return nil
+}
+
+// FindDueSchedules returns schedules with the next tick before `until`.
+func (gw *TaskGateway) FindDueSchedules(ctx context.Context, until time.Time, limit int) ([]*domain.Schedule, error) {
+	return gw.querySchedules(ctx, findDueSchedules, until, limit)
+}
+
+func (gw *TaskGateway) querySchedules(ctx context.Context, query string, args ...interface{}) ([]*domain.Schedule, error) {
+	schedules := make([]*domain.Schedule, 0)
+	rows, err := gw.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// AdvanceSchedule moves schedule's next tick from `from` to `to` and creates tasks atomically.
+// Tasks with existing IDs are skipped. If the next tick was already moved, nothing happens.
+func (gw *TaskGateway) AdvanceSchedule(ctx context.Context, id uuid.UUID, from, to time.Time, tasks []*domain.Task) (int64, error) {
+	tx, err := gw.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	var lastRunAt *time.Time
+	for _, task := range tasks {
+		if lastRunAt == nil || task.ExecuteAt.After(*lastRunAt) {
+			executeAt := task.ExecuteAt
+			lastRunAt = &executeAt
+		}
+	}
+	tag, err := tx.Exec(ctx, advanceSchedule, id, from, to, lastRunAt)
+	if err != nil {
+		return 0, err
+	}
+	if tag.RowsAffected() != 1 {
+		return 0, nil
+	}
+	var created int64
+	for _, task := range tasks {
+		tag, err := tx.Exec(
+			ctx,
+			createScheduled,
+			task.ID,
+			task.ExecuteAt,
+			task.Deadline,
+			task.Queue,
+			task.Priority,
+			task.Payload,
+			task.Meta,
+			task.RetryPolicy,
+		)
+		if err != nil {
+			return 0, err
+		}
+		created += tag.RowsAffected()
+	}
+	return created, tx.Commit(ctx)
+}
+
+// ClaimWebhooks locks due webhooks for delivery during lease.
+func (gw *TaskGateway) ClaimWebhooks(ctx context.Context, limit int, lease time.Duration) ([]*domain.Webhook, error) {
+	webhooks := make([]*domain.Webhook, 0)
+	rows, err := gw.pool.Query(ctx, claimWebhooks, limit, lease)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		webhook := &domain.Webhook{}
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.TaskID,
+			&webhook.URL,
+			&webhook.Payload,
+			&webhook.State,
+			&webhook.Attempts,
+			&webhook.LastError,
+			&webhook.NextAttemptAt,
+			&webhook.CreatedAt,
+			&webhook.DeliveredAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// MarkWebhookDelivered marks a webhook as delivered.
+func (gw *TaskGateway) MarkWebhookDelivered(ctx context.Context, id int64) error {
+	_, err := gw.pool.Exec(ctx, markWebhookDelivered, id)
+	return err
+}
+
+// RetryWebhook postpones a webhook delivery after a failed attempt.
+func (gw *TaskGateway) RetryWebhook(ctx context.Context, id int64, reason string, retryAt time.Time) error {
+	_, err := gw.pool.Exec(ctx, retryWebhook, id, reason, retryAt)
+	return err
+}
+
+// MarkWebhookFailed marks a webhook without attempts left as failed.
+func (gw *TaskGateway) MarkWebhookFailed(ctx context.Context, id int64, reason string) error {
+	_, err := gw.pool.Exec(ctx, markWebhookFailed, id, reason)
+	return err
+}
+
+// DeleteStaleWebhooks removes delivered and failed webhooks.
+func (gw *TaskGateway) DeleteStaleWebhooks(ctx context.Context, staleHours int) (int64, error) {
+	tag, err := gw.pool.Exec(ctx, deleteStaleWebhooks, staleHours)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -3,35 +3,79 @@ package database
 const (
 	create = `
 	insert into 
-		task(id, execute_at, deadline, payload, meta) 
+		task(id, execute_at, deadline, queue, priority, payload, meta, retry_policy, callback_url) 
 	values 
-		($1, $2, $3, $4, $5)
-	returning id, claim_id, state, execute_at, deadline, payload, result, meta, task.created_at;
+		($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	returning id, claim_id, state, execute_at, deadline, queue, priority, payload, result, meta, retry_policy, cancel_requested, callback_url, task.created_at, task.done_at;
+`
+	createMany = `
+	insert into
+		task(id, execute_at, deadline, queue, priority, payload, meta, retry_policy, callback_url)
+	select
+		id, execute_at, deadline, queue, priority, payload, COALESCE(meta, '{}'), retry_policy, COALESCE(callback_url, '')
+	from jsonb_to_recordset($1::jsonb) as t(
+		id uuid,
+		execute_at timestamp with time zone,
+		deadline timestamp with time zone,
+		queue text,
+		priority integer,
+		payload JSONB,
+		meta JSONB,
+		retry_policy JSONB,
+		callback_url text
+	)
+	on conflict (id) do nothing
+	returning id;
 `
 	findByID = `
 	select
-		id, claim_id, state, execute_at, deadline, payload, result, meta, task.created_at, task.done_at
+		id, claim_id, state, execute_at, deadline, queue, priority, payload, result, meta, retry_policy, cancel_requested, callback_url, task.created_at, task.done_at
 	from 
 		task 
 	where id=$1;
 `
+	// listTasks is formatted with a sort column, a sort direction and a keyset comparison operator.
+	listTasks = `
+	select
+		id, claim_id, state, execute_at, deadline, queue, priority, payload, result, meta, retry_policy, cancel_requested, callback_url, task.created_at, task.done_at
+	from
+		task
+	where
+		($1::text[] is null or state::text = any($1))
+		and ($2::text[] is null or queue = any($2))
+		and ($3::timestamp with time zone is null or created_at >= $3)
+		and ($4::timestamp with time zone is null or created_at < $4)
+		and ($5::timestamp with time zone is null or execute_at >= $5)
+		and ($6::timestamp with time zone is null or execute_at < $6)
+		and ($7::timestamp with time zone is null or done_at >= $7)
+		and ($8::timestamp with time zone is null or done_at < $8)
+		and ($9::integer is null or COALESCE(meta->>'attempts', '0')::int >= $9)
+		and ($10::integer is null or COALESCE(meta->>'attempts', '0')::int <= $10)
+		and %[1]s is not null
+		and ($11::timestamp with time zone is null or (%[1]s, id) %[3]s ($11, $12::uuid))
+	order by %[1]s %[2]s, id %[2]s
+	limit $13;
+`
+	// claimPending is formatted with an order of claimed tasks.
 	claimPending = `
 	with claimed_tasks as (
 		select 
 			id 
 		from task 
 		where 
-			state <> $2 
+			state in ('pending', 'processing', 'failed')
+			and ($4::text[] is null or queue = any($4))
 			and execute_at <= current_timestamp
-			--and deadline >= current_timestamp
-		order by execute_at
-		limit $3
+			and deadline >= current_timestamp
+			and not cancel_requested
+		order by %s
+		limit $2
 		for update skip locked
 	)
 	update task 
 	set 
 		state = $1, 
-		execute_at = current_timestamp + interval '1 minute',
+		execute_at = current_timestamp + $3,
 		claim_id = uuid_generate_v4()
 	from claimed_tasks
 	where task.id = claimed_tasks.id
@@ -41,10 +85,103 @@ const (
 		task.state, 
 		task.execute_at, 
 		task.deadline, 
+		task.queue,
+		task.priority,
 		task.payload, 
 		task.result, 
 		task.meta,
-		task.created_at;
+		task.retry_policy,
+		task.cancel_requested,
+		task.callback_url,
+		task.created_at,
+		task.done_at;
+`
+	// claimOrder matches the task_priority_claim index.
+	claimOrder = `priority desc, execute_at`
+	// agedClaimOrder raises priority by one every $5 seconds of waiting, up to $6.
+	agedClaimOrder = `least(priority + floor(extract(epoch from current_timestamp - execute_at)::float8 / $5::float8)::bigint, $6) desc, execute_at`
+
+	cancel = `
+	update task
+	set
+		state = case
+			when state = 'processing' and execute_at > current_timestamp then state
+			else 'cancelled'
+		end,
+		claim_id = case
+			when state = 'processing' and execute_at > current_timestamp then claim_id
+			else null
+		end,
+		done_at = case
+			when state = 'processing' and execute_at > current_timestamp then null
+			else current_timestamp
+		end,
+		cancel_requested = true
+	where
+		id = $1
+		and state in ('pending', 'processing', 'failed')
+		and not cancel_requested
+	returning id, claim_id, state, execute_at, deadline, queue, priority, payload, result, meta, retry_policy, cancel_requested, callback_url, task.created_at, task.done_at;
+`
+	markAsCancelled = `
+	update task
+	set
+		state = 'cancelled',
+		claim_id = null,
+		done_at = current_timestamp
+	where
+		id = $1;
+`
+	lockSucceeded = `
+	select
+		deadline < current_timestamp,
+		cancel_requested
+	from
+		task
+	where
+		id = $1
+		and claim_id = $2
+	for update;
+`
+	markAsExpired = `
+	update task
+	set
+		state = 'expired',
+		claim_id = null,
+		done_at = current_timestamp
+	where
+		id = $1;
+`
+	lockLeased = `
+	select
+		deadline < current_timestamp,
+		cancel_requested
+	from
+		task
+	where
+		id = $1
+		and claim_id = $2
+		and state = 'processing'
+	for update;
+`
+	extendLease = `
+	update task
+	set
+		execute_at = current_timestamp + $3
+	where
+		id = $1
+		and claim_id = $2
+	returning execute_at;
+`
+	releaseTask = `
+	update task
+	set
+		state = 'pending',
+		execute_at = current_timestamp,
+		claim_id = null
+	where
+		id = $1
+		and claim_id = $2;
 `
 	markAsSucceeded = `
 	update task
@@ -56,13 +193,97 @@ const (
 	where 
 		id = $2
 		and claim_id = $3;
+`
+	markManyAsSucceeded = `
+	update task
+	set
+		state = case
+			when task.cancel_requested then 'cancelled'::task_state
+			when task.deadline < current_timestamp then 'expired'::task_state
+			else 'succeeded'::task_state
+		end,
+		claim_id = null,
+		result = case
+			when task.cancel_requested or task.deadline < current_timestamp then task.result
+			else COALESCE(outcomes.result, '{}')
+		end,
+		done_at = current_timestamp
+	from jsonb_to_recordset($1::jsonb) as outcomes(id uuid, claim_id uuid, result JSONB)
+	where
+		task.id = outcomes.id
+		and task.claim_id = outcomes.claim_id
+	returning task.id, task.state;
+`
+	lockManyClaimed = `
+	select
+		task.id,
+		task.claim_id,
+		COALESCE(task.meta->>'attempts', '0')::int,
+		task.retry_policy
+	from
+		task
+		join jsonb_to_recordset($1::jsonb) as outcomes(id uuid, claim_id uuid)
+			on task.id = outcomes.id and task.claim_id = outcomes.claim_id
+	for update of task;
+`
+	// markManyAsFailed applies next attempts planned by domain.RetryPolicy.
+	markManyAsFailed = `
+	update task
+	set
+		state = case
+			when task.cancel_requested then 'cancelled'::task_state
+			else planned.state
+		end,
+		claim_id = null,
+		done_at = case when task.cancel_requested then current_timestamp else task.done_at end,
+		execute_at = case
+			when task.cancel_requested then task.execute_at
+			else planned.execute_at
+		end,
+		meta = case
+			when task.cancel_requested then task.meta
+			else task.meta || jsonb_build_object(
+				'failReason', planned.reason,
+				'attempts', planned.attempts,
+				'history', COALESCE(task.meta->'history', '[]'::jsonb) || jsonb_build_array(jsonb_build_object(
+					'attempt', planned.attempts,
+					'reason', planned.reason,
+					'failedAt', current_timestamp
+				))
+			)
+		end
+	from jsonb_to_recordset($1::jsonb) as planned(
+		id uuid,
+		claim_id uuid,
+		reason text,
+		state task_state,
+		attempts integer,
+		execute_at timestamp with time zone
+	)
+	where
+		task.id = planned.id
+		and task.claim_id = planned.claim_id
+	returning task.id, task.state;
+`
+	lockClaimed = `
+	select
+		COALESCE(meta->>'attempts','0')::int,
+		retry_policy,
+		cancel_requested
+	from
+		task
+	where
+		id = $1
+		and claim_id = $2
+	for update;
 `
 	markAsFailed = `
 	update task
 	set 
 		state = $1,
 		claim_id = null,
-		meta = meta::jsonb || $4 || CONCAT('{"attempts":', COALESCE(meta->>'attempts','0')::int + 1, '}')::jsonb
+		execute_at = $4,
+		meta = meta::jsonb || $5 || jsonb_build_object('history', COALESCE(meta->'history', '[]'::jsonb) || $6::jsonb)
 	where 
 		id = $2
 		and claim_id = $3;
@@ -71,6 +292,210 @@ const (
 	delete from 
 		task
 	where
-		state = 'succeeded' and done_at < current_timestamp - $1 * '1 hour'::interval;
+		state in ('succeeded', 'expired', 'cancelled') and done_at < current_timestamp - $1 * '1 hour'::interval;
+`
+	cancelAbandonedTasks = `
+	update task
+	set
+		state = 'cancelled',
+		claim_id = null,
+		done_at = current_timestamp
+	where
+		state = 'processing'
+		and cancel_requested
+		and execute_at < current_timestamp;
+`
+	expireOverdueTasks = `
+	update task
+	set
+		state = 'expired',
+		claim_id = null,
+		done_at = current_timestamp
+	where
+		deadline < current_timestamp
+		and (
+			state in ('pending', 'failed')
+			or (state = 'processing' and execute_at < current_timestamp)
+		);
+`
+	moveExhaustedTasks = `
+	with exhausted_tasks as (
+		delete from 
+			task
+		where
+			state = 'exhausted'
+		returning *
+	)
+	insert into 
+		dead_letter(id, execute_at, deadline, queue, priority, payload, retry_policy, fail_reason, attempts, history, meta, callback_url, created_at)
+	select
+		id,
+		execute_at,
+		deadline,
+		queue,
+		priority,
+		payload,
+		retry_policy,
+		COALESCE(meta->>'failReason', ''),
+		COALESCE(meta->>'attempts', '0')::int,
+		COALESCE(meta->'history', '[]'::jsonb),
+		meta,
+		callback_url,
+		created_at
+	from exhausted_tasks;
+`
+	listDeadLetters = `
+	select
+		id, execute_at, deadline, queue, priority, payload, retry_policy, fail_reason, attempts, history, created_at, dead_at
+	from
+		dead_letter
+	order by dead_at, id
+	limit $1
+	offset $2;
+`
+	findDeadLetterByID = `
+	select
+		id, execute_at, deadline, queue, priority, payload, retry_policy, fail_reason, attempts, history, created_at, dead_at
+	from
+		dead_letter
+	where id=$1;
+`
+	requeueDeadLetter = `
+	with requeued as (
+		delete from
+			dead_letter
+		where id = $1
+		returning *
+	)
+	insert into
+		task(id, execute_at, deadline, queue, priority, payload, meta, retry_policy, callback_url)
+	select
+		id,
+		current_timestamp,
+		deadline,
+		queue,
+		priority,
+		payload,
+		jsonb_build_object('history', history),
+		retry_policy,
+		callback_url
+	from requeued
+	returning id, claim_id, state, execute_at, deadline, queue, priority, payload, result, meta, retry_policy, cancel_requested, callback_url, task.created_at, task.done_at;
+`
+	purgeDeadLetters = `
+	delete from
+		dead_letter
+	where id = any($1);
+`
+	createSchedule = `
+	insert into
+		schedule(id, cron, timezone, queue, priority, payload, retry_policy, ttl, misfire_policy, next_run_at)
+	values
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	returning id, cron, timezone, queue, priority, payload, retry_policy, ttl, misfire_policy, next_run_at, last_run_at, created_at;
+`
+	findScheduleByID = `
+	select
+		id, cron, timezone, queue, priority, payload, retry_policy, ttl, misfire_policy, next_run_at, last_run_at, created_at
+	from
+		schedule
+	where id=$1;
+`
+	listSchedules = `
+	select
+		id, cron, timezone, queue, priority, payload, retry_policy, ttl, misfire_policy, next_run_at, last_run_at, created_at
+	from
+		schedule
+	order by created_at, id
+	limit $1
+	offset $2;
+`
+	deleteSchedule = `
+	delete from
+		schedule
+	where id=$1;
+`
+	findDueSchedules = `
+	select
+		id, cron, timezone, queue, priority, payload, retry_policy, ttl, misfire_policy, next_run_at, last_run_at, created_at
+	from
+		schedule
+	where next_run_at <= $1
+	order by next_run_at, id
+	limit $2;
+`
+	advanceSchedule = `
+	update schedule
+	set
+		next_run_at = $3,
+		last_run_at = COALESCE($4, last_run_at)
+	where
+		id = $1
+		and next_run_at = $2;
+`
+	createScheduled = `
+	insert into 
+		task(id, execute_at, deadline, queue, priority, payload, meta, retry_policy) 
+	values 
+		($1, $2, $3, $4, $5, $6, $7, $8)
+	on conflict (id) do nothing;
+`
+	nextExecuteAt = `
+	select
+		min(execute_at)
+	from task
+	where
+		state in ('pending', 'processing', 'failed')
+		and ($1::text[] is null or queue = any($1))
+		and deadline >= current_timestamp
+		and not cancel_requested;
+`
+	listenTasks   = `listen task_state;`
+	claimWebhooks = `
+	with claimed_webhooks as (
+		select
+			id
+		from webhook
+		where
+			state = 'pending'
+			and next_attempt_at <= current_timestamp
+		order by next_attempt_at, id
+		limit $1
+		for update skip locked
+	)
+	update webhook
+	set
+		attempts = attempts + 1,
+		next_attempt_at = current_timestamp + $2
+	from claimed_webhooks
+	where webhook.id = claimed_webhooks.id
+	returning webhook.id, task_id, url, payload, state, attempts, last_error, next_attempt_at, created_at, delivered_at;
+`
+	markWebhookDelivered = `
+	update webhook
+	set
+		state = 'delivered',
+		delivered_at = current_timestamp
+	where id = $1;
+`
+	retryWebhook = `
+	update webhook
+	set
+		last_error = $2,
+		next_attempt_at = $3
+	where id = $1;
+`
+	markWebhookFailed = `
+	update webhook
+	set
+		state = 'failed',
+		last_error = $2
+	where id = $1;
+`
+	deleteStaleWebhooks = `
+	delete from
+		webhook
+	where
+		state <> 'pending' and created_at < current_timestamp - $1 * '1 hour'::interval;
 `
 )
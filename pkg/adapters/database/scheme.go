@@ -26,7 +26,7 @@ create table if not exists task (
     result JSONB not null default '{}',
     meta JSONB not null default '{}',
 	created_at timestamp with time zone not null default current_timestamp,
-	done_at timestamp with time zone not null,
+	done_at timestamp with time zone,
 	primary key(id)
 ) with (
 	autovacuum_vacuum_threshold = 100,
@@ -35,7 +35,131 @@ create table if not exists task (
 	autovacuum_vacuum_cost_limit = 200
 );
 
-create index if not exists task_state on task (execute_at, id) where state <> 'succeeded';
-
--- TODO: add failure table
+-- Unfinished tasks have no done_at, databases created before it was nullable are fixed.
+alter table task alter column done_at drop not null;
 `
+
+// schemeUpgrades are applied one by one after initialScheme,
+// new enum values can't be used within the transaction that adds them.
+var schemeUpgrades = []string{
+	`alter type task_state add value if not exists 'exhausted';`,
+	`alter table task add column if not exists retry_policy JSONB;`,
+	`drop index if exists task_state;`,
+	`create index if not exists task_claim on task (execute_at, id) where state in ('pending', 'processing', 'failed');`,
+	`create index if not exists task_exhausted on task (id) where state = 'exhausted';`,
+	`create table if not exists dead_letter (
+		id uuid not null,
+		execute_at timestamp with time zone not null,
+		deadline timestamp with time zone not null,
+		payload JSONB not null,
+		retry_policy JSONB,
+		fail_reason text not null default '',
+		attempts integer not null default 0,
+		history JSONB not null default '[]',
+		meta JSONB not null default '{}',
+		created_at timestamp with time zone not null,
+		dead_at timestamp with time zone not null default current_timestamp,
+		primary key(id)
+	);`,
+	`create index if not exists dead_letter_dead_at on dead_letter (dead_at, id);`,
+	`alter type task_state add value if not exists 'expired';`,
+	`create index if not exists task_deadline on task (deadline) where state in ('pending', 'processing', 'failed');`,
+	`alter type task_state add value if not exists 'cancelled';`,
+	`alter table task add column if not exists cancel_requested boolean not null default false;`,
+	`alter table task add column if not exists queue text not null default 'default';`,
+	`alter table dead_letter add column if not exists queue text not null default 'default';`,
+	`create index if not exists task_queue_claim on task (queue, execute_at, id) where state in ('pending', 'processing', 'failed');`,
+	`alter table task add column if not exists priority integer not null default 0;`,
+	`alter table dead_letter add column if not exists priority integer not null default 0;`,
+	`create table if not exists schedule (
+		id uuid not null,
+		cron text not null,
+		timezone text not null default 'UTC',
+		queue text not null default 'default',
+		priority integer not null default 0,
+		payload JSONB not null,
+		retry_policy JSONB,
+		ttl interval not null,
+		misfire_policy text not null default 'once',
+		next_run_at timestamp with time zone not null,
+		last_run_at timestamp with time zone,
+		created_at timestamp with time zone not null default current_timestamp,
+		primary key(id)
+	);`,
+	`create index if not exists schedule_next_run_at on schedule (next_run_at, id);`,
+	`create or replace function notify_task_state() returns trigger as $$
+	begin
+		perform pg_notify('task_state', json_build_object('id', new.id, 'queue', new.queue, 'state', new.state)::text);
+		return new;
+	end;
+	$$ language plpgsql;`,
+	`drop trigger if exists task_claimable on task;`,
+	`drop function if exists notify_task_claimable();`,
+	// Triggers are created once, a concurrently booting replica may create it first.
+	`do $$
+	begin
+		if not exists (select 1 from pg_trigger where tgname = 'task_state' and tgrelid = 'task'::regclass) then
+			create trigger task_state
+				after insert or update of state, execute_at on task
+				for each row
+				when (new.state <> 'processing')
+				execute procedure notify_task_state();
+		end if;
+	exception
+		when duplicate_object then null;
+	end
+	$$;`,
+	`alter table task add column if not exists callback_url text not null default '';`,
+	`alter table dead_letter add column if not exists callback_url text not null default '';`,
+	`create table if not exists webhook (
+		id bigserial not null,
+		task_id uuid not null,
+		url text not null,
+		payload JSONB not null,
+		state text not null default 'pending',
+		attempts integer not null default 0,
+		last_error text not null default '',
+		next_attempt_at timestamp with time zone not null default current_timestamp,
+		created_at timestamp with time zone not null default current_timestamp,
+		delivered_at timestamp with time zone,
+		primary key(id)
+	);`,
+	`create index if not exists webhook_pending on webhook (next_attempt_at, id) where state = 'pending';`,
+	`create or replace function enqueue_task_webhook() returns trigger as $$
+	begin
+		insert into webhook(task_id, url, payload)
+		values (new.id, new.callback_url, jsonb_build_object(
+			'id', new.id,
+			'state', new.state,
+			'queue', new.queue,
+			'payload', new.payload,
+			'result', new.result,
+			'failReason', COALESCE(new.meta->>'failReason', ''),
+			'attempts', COALESCE(new.meta->>'attempts', '0')::int,
+			'createdAt', new.created_at,
+			'doneAt', new.done_at
+		));
+		return new;
+	end;
+	$$ language plpgsql;`,
+	`do $$
+	begin
+		if not exists (select 1 from pg_trigger where tgname = 'task_webhook' and tgrelid = 'task'::regclass) then
+			create trigger task_webhook
+				after update of state on task
+				for each row
+				when (
+					new.callback_url <> ''
+					and new.state is distinct from old.state
+					and new.state in ('succeeded', 'exhausted', 'expired', 'cancelled')
+				)
+				execute procedure enqueue_task_webhook();
+		end if;
+	exception
+		when duplicate_object then null;
+	end
+	$$;`,
+	`create index if not exists task_created_at on task (created_at, id);`,
+	`drop index if exists task_queue_priority_claim;`,
+	`create index if not exists task_priority_claim on task (priority desc, execute_at) where state in ('pending', 'processing', 'failed');`,
+}
@@ -0,0 +1,59 @@
+package jwtauth
+
+import "time"
+
+// Option is used to configure Verifier.
+type Option func(v *Verifier)
+
+// WithHMACSecret makes Verifier accept tokens signed with the secret (HS256, HS384, HS512).
+func WithHMACSecret(secret []byte) Option {
+	return func(v *Verifier) {
+		v.hmacSecret = secret
+	}
+}
+
+// WithJWKSFile makes Verifier accept tokens signed with keys of the JWKS file (RS*, PS*, ES*, EdDSA),
+// a key is chosen by the token's kid header.
+func WithJWKSFile(path string) Option {
+	return func(v *Verifier) {
+		v.jwksPath = path
+	}
+}
+
+// WithReloadInterval configures how often the JWKS file is checked for changes.
+// Non-positive interval is ignored.
+func WithReloadInterval(interval time.Duration) Option {
+	return func(v *Verifier) {
+		if interval > 0 {
+			v.reloadInterval = interval
+		}
+	}
+}
+
+// WithIssuer makes Verifier require the iss claim.
+func WithIssuer(issuer string) Option {
+	return func(v *Verifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience makes Verifier require the aud claim.
+func WithAudience(audience string) Option {
+	return func(v *Verifier) {
+		v.audience = audience
+	}
+}
+
+// WithTenantClaim configures a claim with a client's tenant, "tenant" by default.
+func WithTenantClaim(claim string) Option {
+	return func(v *Verifier) {
+		v.tenantClaim = claim
+	}
+}
+
+// WithScopesClaim configures a claim with allowed APIs, "scope" by default.
+func WithScopesClaim(claim string) Option {
+	return func(v *Verifier) {
+		v.scopesClaim = claim
+	}
+}
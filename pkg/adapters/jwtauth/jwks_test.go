@@ -0,0 +1,90 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestParseJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+	rsaKey := jwk{Kid: "rsa", Kty: "RSA", N: encodeInt(keys.rsa.N), E: encodeInt(big.NewInt(int64(keys.rsa.E)))}
+	ecKey := jwk{Kid: "ec", Kty: "EC", Crv: "P-256", X: encodeInt(keys.ec.X), Y: encodeInt(keys.ec.Y)}
+	edKey := jwk{Kid: "ed25519", Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(keys.ed25519.Public().(ed25519.PublicKey))}
+	modify := func(key jwk, change func(key *jwk)) jwk {
+		change(&key)
+		return key
+	}
+	tests := []struct {
+		name        string
+		keys        []jwk
+		expected    []string
+		expectedErr bool
+	}{
+		{name: "rsa", keys: []jwk{rsaKey}, expected: []string{"rsa"}},
+		{name: "ec", keys: []jwk{ecKey}, expected: []string{"ec"}},
+		{name: "okp", keys: []jwk{edKey}, expected: []string{"ed25519"}},
+		{
+			name:     "encryption key skipped",
+			keys:     []jwk{rsaKey, modify(ecKey, func(key *jwk) { key.Use = "enc" })},
+			expected: []string{"rsa"},
+		},
+		{name: "duplicate kid", keys: []jwk{rsaKey, modify(ecKey, func(key *jwk) { key.Kid = "rsa" })}, expectedErr: true},
+		{name: "unsupported key type", keys: []jwk{modify(rsaKey, func(key *jwk) { key.Kty = "oct" })}, expectedErr: true},
+		{name: "empty modulus", keys: []jwk{modify(rsaKey, func(key *jwk) { key.N = "" })}, expectedErr: true},
+		{name: "invalid exponent", keys: []jwk{modify(rsaKey, func(key *jwk) { key.E = encodeInt(big.NewInt(1)) })}, expectedErr: true},
+		{name: "malformed modulus", keys: []jwk{modify(rsaKey, func(key *jwk) { key.N = "not base64!" })}, expectedErr: true},
+		{name: "unsupported ec curve", keys: []jwk{modify(ecKey, func(key *jwk) { key.Crv = "P-192" })}, expectedErr: true},
+		{name: "ec curve mismatch", keys: []jwk{modify(ecKey, func(key *jwk) { key.Crv = "P-384" })}, expectedErr: true},
+		{name: "point off the curve", keys: []jwk{modify(ecKey, func(key *jwk) { key.Y = encodeInt(big.NewInt(1)) })}, expectedErr: true},
+		{name: "unsupported okp curve", keys: []jwk{modify(edKey, func(key *jwk) { key.Crv = "X25519" })}, expectedErr: true},
+		{name: "short okp key", keys: []jwk{modify(edKey, func(key *jwk) { key.X = "AQID" })}, expectedErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(jwkSet{Keys: tc.keys})
+			if err != nil {
+				t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+			}
+			parsed, err := parseJWKS(data)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("Expected error: `%v`, got: `%v`", tc.expectedErr, err)
+			}
+			if len(parsed) != len(tc.expected) {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expected, parsed)
+			}
+			for _, kid := range tc.expected {
+				if _, ok := parsed[kid]; !ok {
+					t.Errorf("Expected `%v` key, got: `%v`", kid, parsed)
+				}
+			}
+		})
+	}
+	parsed, err := parseJWKS([]byte(`{"keys": [`))
+	if err == nil {
+		t.Errorf("Expected error, got: `%v`", parsed)
+	}
+}
+
+func TestPublicKeyTypes(t *testing.T) {
+	keys := newTestKeys(t)
+	rsaKey := jwk{Kty: "RSA", N: encodeInt(keys.rsa.N), E: encodeInt(big.NewInt(int64(keys.rsa.E)))}
+	ecKey := jwk{Kty: "EC", Crv: "P-256", X: encodeInt(keys.ec.X), Y: encodeInt(keys.ec.Y)}
+	edKey := jwk{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(keys.ed25519.Public().(ed25519.PublicKey))}
+	key, err := rsaKey.publicKey()
+	if public, ok := key.(*rsa.PublicKey); err != nil || !ok || !public.Equal(&keys.rsa.PublicKey) {
+		t.Errorf("Expected `%v`, got: `%v`, `%v`", keys.rsa.PublicKey, key, err)
+	}
+	key, err = ecKey.publicKey()
+	if public, ok := key.(*ecdsa.PublicKey); err != nil || !ok || !public.Equal(&keys.ec.PublicKey) {
+		t.Errorf("Expected `%v`, got: `%v`, `%v`", keys.ec.PublicKey, key, err)
+	}
+	key, err = edKey.publicKey()
+	if public, ok := key.(ed25519.PublicKey); err != nil || !ok || !public.Equal(keys.ed25519.Public()) {
+		t.Errorf("Expected `%v`, got: `%v`, `%v`", keys.ed25519.Public(), key, err)
+	}
+}
@@ -0,0 +1,193 @@
+package jwtauth
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	log "github.com/freundallein/scheduler/pkg/utils/logging"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	hmacMethods = []string{"HS256", "HS384", "HS512"}
+	keyMethods  = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// Verifier authenticates API clients with signed JWTs.
+// Tokens are signed with a shared HMAC secret or with keys, which public parts are in a JWKS file.
+// The file is reloaded, when it changes, so keys are rotated without restarts.
+type Verifier struct {
+	hmacSecret     []byte
+	jwksPath       string
+	reloadInterval time.Duration
+	issuer         string
+	audience       string
+	tenantClaim    string
+	scopesClaim    string
+	parser         *jwt.Parser
+
+	mu      sync.RWMutex
+	keys    map[string]interface{}
+	modTime time.Time
+}
+
+// New returns a domain.Authenticator implementation, an HMAC secret or a valid JWKS file is required.
+func New(opts ...Option) (*Verifier, error) {
+	verifier := &Verifier{
+		reloadInterval: 10 * time.Second,
+		tenantClaim:    "tenant",
+		scopesClaim:    "scope",
+	}
+	for _, opt := range opts {
+		opt(verifier)
+	}
+	var methods []string
+	if len(verifier.hmacSecret) > 0 {
+		methods = append(methods, hmacMethods...)
+	}
+	if verifier.jwksPath != "" {
+		_, err := verifier.reload()
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, keyMethods...)
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("neither HMAC secret nor JWKS file is configured")
+	}
+	verifier.parser = jwt.NewParser(jwt.WithValidMethods(methods))
+	return verifier, nil
+}
+
+// Run reloads the JWKS file on changes until the context is cancelled.
+// Invalid file is skipped and the last valid keys are kept.
+func (v *Verifier) Run(ctx context.Context) error {
+	if v.jwksPath == "" {
+		<-ctx.Done()
+		return nil
+	}
+	ticker := time.NewTicker(v.reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		reloaded, err := v.reload()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path": v.jwksPath,
+				"err":  err,
+			}).Error("jwks_reload_failed")
+			continue
+		}
+		if reloaded {
+			log.WithFields(log.Fields{
+				"path": v.jwksPath,
+			}).Info("jwks_reloaded")
+		}
+	}
+}
+
+// reload reads the JWKS file, if it was modified since the last read.
+func (v *Verifier) reload() (bool, error) {
+	info, err := os.Stat(v.jwksPath)
+	if err != nil {
+		return false, err
+	}
+	v.mu.RLock()
+	modTime := v.modTime
+	v.mu.RUnlock()
+	if info.ModTime().Equal(modTime) {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(v.jwksPath)
+	if err != nil {
+		return false, err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return false, err
+	}
+	v.mu.Lock()
+	v.keys = keys
+	v.modTime = info.ModTime()
+	v.mu.Unlock()
+	return true, nil
+}
+
+// key returns a key to verify the token's signature with.
+func (v *Verifier) key(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return v.hmacSecret, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	key, ok := v.keys[kid]
+	if !ok && kid == "" && len(v.keys) == 1 {
+		// A token may omit kid, when there is the only key.
+		for _, key = range v.keys {
+			ok = true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// Authenticate checks a token's signature and claims, the tenant claim becomes a credential name
+// and the scope claim lists allowed APIs. Tokens should expire.
+func (v *Verifier) Authenticate(ctx context.Context, token string) (*domain.Credential, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, v.key)
+	if err != nil {
+		return nil, err
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("token has no exp claim")
+	}
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return nil, fmt.Errorf("token has invalid issuer")
+	}
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return nil, fmt.Errorf("token has invalid audience")
+	}
+	tenant, _ := claims[v.tenantClaim].(string)
+	if tenant == "" {
+		return nil, fmt.Errorf("token has no %s claim", v.tenantClaim)
+	}
+	expiresAt := time.Unix(int64(exp), 0).UTC()
+	return &domain.Credential{
+		Name:      tenant,
+		Scopes:    scopes(claims[v.scopesClaim]),
+		ExpiresAt: &expiresAt,
+	}, nil
+}
+
+// scopes parses a space separated string, e.g. "scheduler worker", or a list of strings.
+func scopes(claim interface{}) []domain.Scope {
+	var result []domain.Scope
+	switch value := claim.(type) {
+	case string:
+		for _, scope := range strings.Fields(value) {
+			result = append(result, domain.Scope(scope))
+		}
+	case []interface{}:
+		for _, item := range value {
+			if scope, ok := item.(string); ok {
+				result = append(result, domain.Scope(scope))
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,314 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testKeys are signing keys, which public parts are in the test JWKS file.
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	return &testKeys{rsa: rsaKey, ec: ecKey, ed25519: edKey}
+}
+
+func encodeInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+// jwks returns a JWKS file with public keys.
+func (k *testKeys) jwks(t *testing.T) string {
+	t.Helper()
+	data, err := json.Marshal(jwkSet{Keys: []jwk{
+		{Kid: "rsa", Kty: "RSA", Use: "sig", N: encodeInt(k.rsa.N), E: encodeInt(big.NewInt(int64(k.rsa.E)))},
+		{Kid: "ec", Kty: "EC", Crv: "P-256", X: encodeInt(k.ec.X), Y: encodeInt(k.ec.Y)},
+		{Kid: "ed25519", Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(k.ed25519.Public().(ed25519.PublicKey))},
+	}})
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	return path
+}
+
+// publicPEM returns the RSA public key, that an attacker may use as an HMAC secret.
+func (k *testKeys) publicPEM(t *testing.T) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&k.rsa.PublicKey)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	return signed
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys := newTestKeys(t)
+	secret := []byte("secret")
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"tenant": "billing", "scope": "scheduler worker", "exp": exp}
+	}
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		claims[key] = value
+		return claims
+	}
+	without := func(key string) jwt.MapClaims {
+		claims := valid()
+		delete(claims, key)
+		return claims
+	}
+	verifier, err := New(WithHMACSecret(secret), WithJWKSFile(keys.jwks(t)))
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	jwksOnly, err := New(WithJWKSFile(keys.jwks(t)))
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	scoped, err := New(WithHMACSecret(secret), WithIssuer("https://issuer"), WithAudience("scheduler"))
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	tests := []struct {
+		name           string
+		verifier       *Verifier
+		token          string
+		expectedScopes []domain.Scope
+		expectedErr    bool
+	}{
+		{
+			name:           "hmac",
+			verifier:       verifier,
+			token:          sign(t, jwt.SigningMethodHS256, "", secret, valid()),
+			expectedScopes: []domain.Scope{domain.ScopeScheduler, domain.ScopeWorker},
+		},
+		{
+			name:           "rsa",
+			verifier:       verifier,
+			token:          sign(t, jwt.SigningMethodRS256, "rsa", keys.rsa, valid()),
+			expectedScopes: []domain.Scope{domain.ScopeScheduler, domain.ScopeWorker},
+		},
+		{
+			name:           "ecdsa",
+			verifier:       verifier,
+			token:          sign(t, jwt.SigningMethodES256, "ec", keys.ec, valid()),
+			expectedScopes: []domain.Scope{domain.ScopeScheduler, domain.ScopeWorker},
+		},
+		{
+			name:           "ed25519",
+			verifier:       verifier,
+			token:          sign(t, jwt.SigningMethodEdDSA, "ed25519", keys.ed25519, valid()),
+			expectedScopes: []domain.Scope{domain.ScopeScheduler, domain.ScopeWorker},
+		},
+		{
+			name:           "scopes array",
+			verifier:       verifier,
+			token:          sign(t, jwt.SigningMethodHS256, "", secret, with("scope", []string{"admin", "worker"})),
+			expectedScopes: []domain.Scope{domain.ScopeAdmin, domain.ScopeWorker},
+		},
+		{
+			name:     "no scopes",
+			verifier: verifier,
+			token:    sign(t, jwt.SigningMethodHS256, "", secret, without("scope")),
+		},
+		{
+			name:        "alg none",
+			verifier:    verifier,
+			token:       sign(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, valid()),
+			expectedErr: true,
+		},
+		{
+			name:        "hmac with public key",
+			verifier:    jwksOnly,
+			token:       sign(t, jwt.SigningMethodHS256, "rsa", keys.publicPEM(t), valid()),
+			expectedErr: true,
+		},
+		{
+			name:        "hmac with public key and secret",
+			verifier:    verifier,
+			token:       sign(t, jwt.SigningMethodHS256, "rsa", keys.publicPEM(t), valid()),
+			expectedErr: true,
+		},
+		{
+			name:        "wrong secret",
+			verifier:    verifier,
+			token:       sign(t, jwt.SigningMethodHS256, "", []byte("wrong"), valid()),
+			expectedErr: true,
+		},
+		{
+			name:        "unknown kid",
+			verifier:    verifier,
+			token:       sign(t, jwt.SigningMethodRS256, "unknown", keys.rsa, valid()),
+			expectedErr: true,
+		},
+		{
+			name:        "key of another kid",
+			verifier:    verifier,
+			token:       sign(t, jwt.SigningMethodES256, "rsa", keys.ec, valid()),
+			expectedErr: true,
+		},
+		{
+			name:        "missing exp",
+			verifier:    verifier,
+			token:       sign(t, jwt.SigningMethodHS256, "", secret, without("exp")),
+			expectedErr: true,
+		},
+		{
+			name:        "expired",
+			verifier:    verifier,
+			token:       sign(t, jwt.SigningMethodHS256, "", secret, with("exp", time.Now().Add(-time.Minute).Unix())),
+			expectedErr: true,
+		},
+		{
+			name:        "nbf in the future",
+			verifier:    verifier,
+			token:       sign(t, jwt.SigningMethodHS256, "", secret, with("nbf", time.Now().Add(time.Minute).Unix())),
+			expectedErr: true,
+		},
+		{
+			name:        "missing tenant",
+			verifier:    verifier,
+			token:       sign(t, jwt.SigningMethodHS256, "", secret, without("tenant")),
+			expectedErr: true,
+		},
+		{
+			name:     "issuer and audience",
+			verifier: scoped,
+			token: sign(t, jwt.SigningMethodHS256, "", secret, jwt.MapClaims{
+				"tenant": "billing", "scope": "scheduler", "exp": exp, "iss": "https://issuer", "aud": []string{"scheduler", "reports"},
+			}),
+			expectedScopes: []domain.Scope{domain.ScopeScheduler},
+		},
+		{
+			name:        "wrong issuer",
+			verifier:    scoped,
+			token:       sign(t, jwt.SigningMethodHS256, "", secret, jwt.MapClaims{"tenant": "billing", "exp": exp, "iss": "https://other", "aud": "scheduler"}),
+			expectedErr: true,
+		},
+		{
+			name:        "missing issuer",
+			verifier:    scoped,
+			token:       sign(t, jwt.SigningMethodHS256, "", secret, jwt.MapClaims{"tenant": "billing", "exp": exp, "aud": "scheduler"}),
+			expectedErr: true,
+		},
+		{
+			name:        "wrong audience",
+			verifier:    scoped,
+			token:       sign(t, jwt.SigningMethodHS256, "", secret, jwt.MapClaims{"tenant": "billing", "exp": exp, "iss": "https://issuer", "aud": "reports"}),
+			expectedErr: true,
+		},
+		{
+			name:        "missing audience",
+			verifier:    scoped,
+			token:       sign(t, jwt.SigningMethodHS256, "", secret, jwt.MapClaims{"tenant": "billing", "exp": exp, "iss": "https://issuer"}),
+			expectedErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			credential, err := tc.verifier.Authenticate(context.Background(), tc.token)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("Expected error: `%v`, got: `%v`", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if credential.Name != "billing" {
+				t.Errorf("Expected `%v`, got: `%v`", "billing", credential.Name)
+			}
+			if !reflect.DeepEqual(credential.Scopes, tc.expectedScopes) {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expectedScopes, credential.Scopes)
+			}
+			if credential.ExpiresAt == nil || credential.ExpiresAt.Unix() != exp {
+				t.Errorf("Expected `%v`, got: `%v`", exp, credential.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithoutKid(t *testing.T) {
+	keys := newTestKeys(t)
+	data, err := json.Marshal(jwkSet{Keys: []jwk{
+		{Kid: "ec", Kty: "EC", Crv: "P-256", X: encodeInt(keys.ec.X), Y: encodeInt(keys.ec.Y)},
+	}})
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	verifier, err := New(WithJWKSFile(path))
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	token := sign(t, jwt.SigningMethodES256, "", keys.ec, jwt.MapClaims{"tenant": "billing", "exp": time.Now().Add(time.Hour).Unix()})
+	_, err = verifier.Authenticate(context.Background(), token)
+	if err != nil {
+		t.Errorf("Expected the only key to be used, got: `%v`", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New()
+	if err == nil {
+		t.Errorf("Expected error, got: `%v`", err)
+	}
+	_, err = New(WithJWKSFile(filepath.Join(t.TempDir(), "missing.json")))
+	if err == nil {
+		t.Errorf("Expected error, got: `%v`", err)
+	}
+}
@@ -0,0 +1,4 @@
+package memory
+
+// Option is used to configure TaskGateway.
+type Option func(gw *TaskGateway)
@@ -0,0 +1,1147 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/google/uuid"
+)
+
+// TaskGateway keeps tasks in memory, it's used for tests and local development.
+// It follows the database gateway semantics, but nothing survives a restart.
+type TaskGateway struct {
+	mu          sync.Mutex
+	tasks       map[uuid.UUID]*domain.Task
+	deadLetters map[uuid.UUID]*deadLetter
+	schedules   map[uuid.UUID]*domain.Schedule
+	webhooks    map[int64]*domain.Webhook
+	lastWebhook int64
+	listeners   map[*listener]struct{}
+}
+
+// deadLetter keeps task fields, that domain.DeadLetter doesn't show.
+type deadLetter struct {
+	domain.DeadLetter
+	callbackURL string
+}
+
+// listener queues events of a ListenTasks call, so gateway never waits for notify.
+type listener struct {
+	mu     sync.Mutex
+	events []domain.TaskEvent
+	signal chan struct{}
+}
+
+func (l *listener) push(event domain.TaskEvent) {
+	l.mu.Lock()
+	l.events = append(l.events, event)
+	l.mu.Unlock()
+	select {
+	case l.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (l *listener) pop() []domain.TaskEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := l.events
+	l.events = nil
+	return events
+}
+
+// NewTaskGateway return in-memory task gateway implementation.
+func NewTaskGateway(opts ...Option) domain.Gateway {
+	gw := &TaskGateway{
+		tasks:       map[uuid.UUID]*domain.Task{},
+		deadLetters: map[uuid.UUID]*deadLetter{},
+		schedules:   map[uuid.UUID]*domain.Schedule{},
+		webhooks:    map[int64]*domain.Webhook{},
+		listeners:   map[*listener]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(gw)
+	}
+	return gw
+}
+
+// now is an analogue of current_timestamp.
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// normalize copies a map through JSON, the same way JSONB columns do.
+func normalize(value map[string]interface{}) (map[string]interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized map[string]interface{}
+	err = json.Unmarshal(data, &normalized)
+	if err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// copyJSON deeply copies a decoded JSON value.
+func copyJSON(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			copied[key] = copyJSON(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for idx, item := range value {
+			copied[idx] = copyJSON(item)
+		}
+		return copied
+	}
+	return value
+}
+
+func copyMap(value map[string]interface{}) map[string]interface{} {
+	if value == nil {
+		return nil
+	}
+	return copyJSON(value).(map[string]interface{})
+}
+
+func copyPolicy(policy *domain.RetryPolicy) *domain.RetryPolicy {
+	if policy == nil {
+		return nil
+	}
+	copied := *policy
+	return &copied
+}
+
+// copyTask detaches a task from the storage, so callers can't change it.
+func copyTask(task *domain.Task) *domain.Task {
+	copied := *task
+	if task.ClaimID != nil {
+		claimID := *task.ClaimID
+		copied.ClaimID = &claimID
+	}
+	copied.RetryPolicy = copyPolicy(task.RetryPolicy)
+	copied.Payload = copyMap(task.Payload)
+	copied.Result = copyMap(task.Result)
+	copied.Meta = copyMap(task.Meta)
+	return &copied
+}
+
+// newTask makes a pending task record with column defaults.
+func newTask(task *domain.Task, createdAt time.Time) (*domain.Task, error) {
+	payload, err := normalize(task.Payload)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := normalize(task.Meta)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		meta = map[string]interface{}{}
+	}
+	return &domain.Task{
+		ID:          task.ID,
+		State:       domain.StatePending,
+		ExecuteAt:   task.ExecuteAt.UTC(),
+		Deadline:    task.Deadline.UTC(),
+		Queue:       task.Queue,
+		Priority:    task.Priority,
+		Payload:     payload,
+		RetryPolicy: copyPolicy(task.RetryPolicy),
+		CallbackURL: task.CallbackURL,
+		Result:      map[string]interface{}{},
+		Meta:        meta,
+		CreatedAt:   createdAt,
+	}, nil
+}
+
+// insert stores a new task record and notifies listeners.
+func (gw *TaskGateway) insert(task *domain.Task) {
+	gw.tasks[task.ID] = task
+	gw.publish(task)
+}
+
+// publish notifies listeners about a task state like the task_state trigger does,
+// processing tasks are skipped.
+func (gw *TaskGateway) publish(task *domain.Task) {
+	if task.State == domain.StateProcessing {
+		return
+	}
+	event := domain.TaskEvent{ID: task.ID, Queue: task.Queue, State: task.State}
+	for l := range gw.listeners {
+		l.push(event)
+	}
+}
+
+// setState moves a task to a new state and notifies listeners.
+// A finished task with a callback URL enqueues a webhook like the task_webhook trigger does.
+func (gw *TaskGateway) setState(task *domain.Task, state domain.State, at time.Time) {
+	previous := task.State
+	task.State = state
+	if state != previous && state.Finished() && task.CallbackURL != "" {
+		gw.enqueueWebhook(task, at)
+	}
+	gw.publish(task)
+}
+
+// finish releases a task's claim and moves it to a finished state.
+func (gw *TaskGateway) finish(task *domain.Task, state domain.State, at time.Time) {
+	task.ClaimID = nil
+	task.DoneAt = sql.NullTime{Time: at, Valid: true}
+	gw.setState(task, state, at)
+}
+
+// active reports whether a task in the state may be claimed.
+func active(state domain.State) bool {
+	switch state {
+	case domain.StatePending, domain.StateProcessing, domain.StateFailed:
+		return true
+	}
+	return false
+}
+
+// attempts reads an amount of failed attempts from task meta.
+func attempts(meta map[string]interface{}) int {
+	switch value := meta["attempts"].(type) {
+	case float64:
+		return int(value)
+	case int:
+		return value
+	}
+	return 0
+}
+
+// failReason reads the last failure reason from task meta.
+func failReason(meta map[string]interface{}) string {
+	reason, _ := meta["failReason"].(string)
+	return reason
+}
+
+// history reads failed attempts from task meta.
+func history(meta map[string]interface{}) ([]domain.Failure, error) {
+	failures := make([]domain.Failure, 0)
+	value, ok := meta["history"]
+	if !ok {
+		return failures, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &failures)
+	if err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
+
+// Create is for a task creation, returns a created task.
+func (gw *TaskGateway) Create(ctx context.Context, task *domain.Task) (*domain.Task, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if _, ok := gw.tasks[task.ID]; ok {
+		return nil, domain.Error{Code: domain.ErrDuplicateTask, Message: "task already set"}
+	}
+	record, err := newTask(task, now())
+	if err != nil {
+		return nil, err
+	}
+	gw.insert(record)
+	return copyTask(record), nil
+}
+
+// CreateMany makes records with new tasks at once.
+// Tasks with existing IDs are skipped and reported with ErrDuplicateTask,
+// results are in the same order as tasks.
+func (gw *TaskGateway) CreateMany(ctx context.Context, tasks []*domain.Task) ([]domain.BatchResult, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	at := now()
+	records := make([]*domain.Task, 0, len(tasks))
+	for _, task := range tasks {
+		record, err := newTask(task, at)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	results := make([]domain.BatchResult, 0, len(records))
+	for _, record := range records {
+		result := domain.BatchResult{ID: record.ID}
+		if _, ok := gw.tasks[record.ID]; ok {
+			result.Err = domain.Error{Code: domain.ErrDuplicateTask, Message: "task already set"}
+		} else {
+			gw.insert(record)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// FindByID returns a task by id.
+func (gw *TaskGateway) FindByID(ctx context.Context, id uuid.UUID) (*domain.Task, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	task, ok := gw.tasks[id]
+	if !ok {
+		return nil, domain.Error{Code: domain.ErrTaskNotFound, Message: "task not found"}
+	}
+	return copyTask(task), nil
+}
+
+// sortKey returns a task's sort key for an order, tasks without a key are skipped.
+func sortKey(task *domain.Task, order domain.TaskOrder) (time.Time, bool) {
+	switch order {
+	case domain.OrderByExecuteAt:
+		return task.ExecuteAt, true
+	case domain.OrderByDoneAt:
+		return task.DoneAt.Time, task.DoneAt.Valid
+	}
+	return task.CreatedAt, true
+}
+
+// compareKeys compares tasks' sort keys the same way as the (key, id) row comparison.
+func compareKeys(at time.Time, id uuid.UUID, other time.Time, otherID uuid.UUID) int {
+	switch {
+	case at.Before(other):
+		return -1
+	case at.After(other):
+		return 1
+	}
+	return bytes.Compare(id[:], otherID[:])
+}
+
+// inRange reports whether t is within [from, to), zero bounds aren't checked.
+func inRange(t, from, to time.Time) bool {
+	if !from.IsZero() && t.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !t.Before(to) {
+		return false
+	}
+	return true
+}
+
+// matches reports whether a task passes a filter.
+func matches(task *domain.Task, filter domain.TaskFilter) bool {
+	if len(filter.States) > 0 {
+		found := false
+		for _, state := range filter.States {
+			found = found || task.State == state
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(filter.Queues) > 0 && !contains(filter.Queues, task.Queue) {
+		return false
+	}
+	if !inRange(task.CreatedAt, filter.CreatedFrom, filter.CreatedTo) {
+		return false
+	}
+	if !inRange(task.ExecuteAt, filter.ExecuteFrom, filter.ExecuteTo) {
+		return false
+	}
+	if !filter.DoneFrom.IsZero() || !filter.DoneTo.IsZero() {
+		if !task.DoneAt.Valid || !inRange(task.DoneAt.Time, filter.DoneFrom, filter.DoneTo) {
+			return false
+		}
+	}
+	taskAttempts := attempts(task.Meta)
+	if filter.MinAttempts != nil && taskAttempts < *filter.MinAttempts {
+		return false
+	}
+	if filter.MaxAttempts != nil && taskAttempts > *filter.MaxAttempts {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ListTasks returns filtered tasks sorted by query.OrderBy and id.
+// Tasks without a sort key, e.g. unfinished ones for OrderByDoneAt, are skipped.
+func (gw *TaskGateway) ListTasks(ctx context.Context, query domain.TaskQuery) ([]*domain.Task, error) {
+	switch query.OrderBy {
+	case domain.OrderByCreatedAt, domain.OrderByExecuteAt, domain.OrderByDoneAt:
+	default:
+		return nil, fmt.Errorf("unknown order %q", query.OrderBy)
+	}
+	direction := 1
+	if query.Desc {
+		direction = -1
+	}
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	tasks := make([]*domain.Task, 0)
+	for _, task := range gw.tasks {
+		at, ok := sortKey(task, query.OrderBy)
+		if !ok || !matches(task, query.Filter) {
+			continue
+		}
+		if query.After != nil && compareKeys(at, task.ID, query.After.At, query.After.ID)*direction <= 0 {
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		at, _ := sortKey(tasks[i], query.OrderBy)
+		other, _ := sortKey(tasks[j], query.OrderBy)
+		return compareKeys(at, tasks[i].ID, other, tasks[j].ID)*direction < 0
+	})
+	if query.Limit >= 0 && len(tasks) > query.Limit {
+		tasks = tasks[:query.Limit]
+	}
+	for idx, task := range tasks {
+		tasks[idx] = copyTask(task)
+	}
+	return tasks, nil
+}
+
+// claimable reports whether a task in queues may be claimed, when its execution time comes.
+func claimable(task *domain.Task, queues []string, at time.Time) bool {
+	if !active(task.State) || task.CancelRequested || task.Deadline.Before(at) {
+		return false
+	}
+	return len(queues) == 0 || contains(queues, task.Queue)
+}
+
+// agedPriority raises task's priority by one every aging of waiting after its execution time,
+// up to domain.MaxPriority. Zero aging keeps the priority.
+func agedPriority(task *domain.Task, aging time.Duration, at time.Time) int64 {
+	priority := int64(task.Priority)
+	if aging <= 0 {
+		return priority
+	}
+	priority += int64(at.Sub(task.ExecuteAt) / aging)
+	if priority > domain.MaxPriority {
+		return domain.MaxPriority
+	}
+	return priority
+}
+
+// ClaimPending locks and returns pending (or next-attempt failed) task.
+// Tasks are claimed from any queue, unless request names the queues.
+// Urgent tasks are claimed first, waiting tasks are aged with request.Aging.
+func (gw *TaskGateway) ClaimPending(ctx context.Context, request domain.ClaimRequest) ([]*domain.Task, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	at := now()
+	candidates := make([]*domain.Task, 0)
+	for _, task := range gw.tasks {
+		if claimable(task, request.Queues, at) && !task.ExecuteAt.After(at) {
+			candidates = append(candidates, task)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		left, right := agedPriority(candidates[i], request.Aging, at), agedPriority(candidates[j], request.Aging, at)
+		if left != right {
+			return left > right
+		}
+		return compareKeys(candidates[i].ExecuteAt, candidates[i].ID, candidates[j].ExecuteAt, candidates[j].ID) < 0
+	})
+	if request.Amount >= 0 && len(candidates) > request.Amount {
+		candidates = candidates[:request.Amount]
+	}
+	tasks := make([]*domain.Task, 0, len(candidates))
+	for _, task := range candidates {
+		claimID := uuid.New()
+		task.ClaimID = &claimID
+		task.ExecuteAt = at.Add(request.Lease)
+		gw.setState(task, domain.StateProcessing, at)
+		tasks = append(tasks, copyTask(task))
+	}
+	if len(tasks) == 0 {
+		return nil, domain.Error{Code: domain.ErrNoPendingTasks, Message: "no pending tasks"}
+	}
+	return tasks, nil
+}
+
+// NextExecuteAt returns the earliest execution time of claimable tasks in queues.
+// Any queue is considered, if queues are empty.
+func (gw *TaskGateway) NextExecuteAt(ctx context.Context, queues []string) (time.Time, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	at := now()
+	var executeAt time.Time
+	for _, task := range gw.tasks {
+		if !claimable(task, queues, at) {
+			continue
+		}
+		if executeAt.IsZero() || task.ExecuteAt.Before(executeAt) {
+			executeAt = task.ExecuteAt
+		}
+	}
+	if executeAt.IsZero() {
+		return time.Time{}, domain.Error{Code: domain.ErrNoPendingTasks, Message: "no pending tasks"}
+	}
+	return executeAt, nil
+}
+
+// ListenTasks calls notify, when a task becomes claimable or finished.
+// It blocks until ctx is done.
+func (gw *TaskGateway) ListenTasks(ctx context.Context, notify func(event domain.TaskEvent)) error {
+	l := &listener{signal: make(chan struct{}, 1)}
+	gw.mu.Lock()
+	gw.listeners[l] = struct{}{}
+	gw.mu.Unlock()
+	defer func() {
+		gw.mu.Lock()
+		delete(gw.listeners, l)
+		gw.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-l.signal:
+			for _, event := range l.pop() {
+				notify(event)
+			}
+		}
+	}
+}
+
+// Cancel withdraws a task. Pending and failed tasks become cancelled at once,
+// processing task is marked as cancel-requested until its worker reports back.
+func (gw *TaskGateway) Cancel(ctx context.Context, id uuid.UUID) (*domain.Task, bool, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	task, ok := gw.tasks[id]
+	if !ok {
+		return nil, false, domain.Error{Code: domain.ErrTaskNotFound, Message: "task not found"}
+	}
+	at := now()
+	switch {
+	case !active(task.State) || task.CancelRequested:
+		if task.State != domain.StateCancelled && !task.CancelRequested {
+			return nil, false, domain.Error{Code: domain.ErrTaskFinished, Message: "task is already finished"}
+		}
+		return copyTask(task), false, nil
+	case task.State == domain.StateProcessing && task.ExecuteAt.After(at):
+		task.CancelRequested = true
+	default:
+		task.CancelRequested = true
+		gw.finish(task, domain.StateCancelled, at)
+	}
+	return copyTask(task), true, nil
+}
+
+// claimed returns a task with a claim.
+func (gw *TaskGateway) claimed(id, claimID uuid.UUID) (*domain.Task, error) {
+	task, ok := gw.tasks[id]
+	if !ok || task.ClaimID == nil || *task.ClaimID != claimID {
+		return nil, domain.Error{Code: domain.ErrStaleResult, Message: "result is stale"}
+	}
+	return task, nil
+}
+
+// MarkAsSucceeded marks a task as succefully processed.
+// Result, that came after the task's deadline, is rejected and the task becomes expired.
+func (gw *TaskGateway) MarkAsSucceeded(ctx context.Context, id, claimID uuid.UUID, result map[string]interface{}) error {
+	result, err := normalize(result)
+	if err != nil {
+		return err
+	}
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return gw.markAsSucceeded(id, claimID, result, now())
+}
+
+// markAsSucceeded is MarkAsSucceeded with a normalized result, gw.mu is held by a caller.
+func (gw *TaskGateway) markAsSucceeded(id, claimID uuid.UUID, result map[string]interface{}, at time.Time) error {
+	task, err := gw.claimed(id, claimID)
+	if err != nil {
+		return err
+	}
+	if task.CancelRequested {
+		gw.finish(task, domain.StateCancelled, at)
+		return domain.Error{Code: domain.ErrTaskCancelled, Message: "task was cancelled"}
+	}
+	if task.Deadline.Before(at) {
+		gw.finish(task, domain.StateExpired, at)
+		return domain.Error{Code: domain.ErrDeadlineExceeded, Message: "deadline exceeded"}
+	}
+	task.Result = result
+	gw.finish(task, domain.StateSucceeded, at)
+	return nil
+}
+
+// MarkAsFailed marks a task as failed and plans the next attempt according to the task's retry policy.
+// If there are no attempts left, the task becomes exhausted.
+func (gw *TaskGateway) MarkAsFailed(ctx context.Context, id, claimID uuid.UUID, reason string) error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return gw.markAsFailed(id, claimID, reason, now())
+}
+
+// markAsFailed is MarkAsFailed, gw.mu is held by a caller.
+func (gw *TaskGateway) markAsFailed(id, claimID uuid.UUID, reason string, at time.Time) error {
+	task, err := gw.claimed(id, claimID)
+	if err != nil {
+		return err
+	}
+	if task.CancelRequested {
+		gw.finish(task, domain.StateCancelled, at)
+		return domain.Error{Code: domain.ErrTaskCancelled, Message: "task was cancelled"}
+	}
+	taskAttempts := attempts(task.Meta) + 1
+	state := domain.StateFailed
+	if task.RetryPolicy.Exhausted(taskAttempts) {
+		state = domain.StateExhausted
+	}
+	meta := copyMap(task.Meta)
+	failures, _ := meta["history"].([]interface{})
+	meta["failReason"] = reason
+	meta["attempts"] = taskAttempts
+	meta["history"] = append(failures, domain.Failure{
+		Attempt:  taskAttempts,
+		Reason:   reason,
+		FailedAt: at,
+	})
+	meta, err = normalize(meta)
+	if err != nil {
+		return err
+	}
+	task.Meta = meta
+	task.ClaimID = nil
+	task.ExecuteAt = at.Add(task.RetryPolicy.Backoff(taskAttempts))
+	gw.setState(task, state, at)
+	return nil
+}
+
+// MarkManyAsSucceeded marks tasks as successfully processed at once.
+// Errors are reported per task the same way as MarkAsSucceeded does,
+// results are in the same order as outcomes.
+func (gw *TaskGateway) MarkManyAsSucceeded(ctx context.Context, outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	taskResults := make([]map[string]interface{}, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		result, err := normalize(outcome.Result)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = map[string]interface{}{}
+		}
+		taskResults = append(taskResults, result)
+	}
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	at := now()
+	results := make([]domain.BatchResult, 0, len(outcomes))
+	for idx, outcome := range outcomes {
+		results = append(results, domain.BatchResult{
+			ID:  outcome.ID,
+			Err: gw.markAsSucceeded(outcome.ID, outcome.ClaimID, taskResults[idx], at),
+		})
+	}
+	return results, nil
+}
+
+// MarkManyAsFailed marks tasks as failed and plans their next attempts like MarkAsFailed does,
+// results are in the same order as outcomes.
+func (gw *TaskGateway) MarkManyAsFailed(ctx context.Context, outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	at := now()
+	results := make([]domain.BatchResult, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		err := gw.markAsFailed(outcome.ID, outcome.ClaimID, outcome.Reason, at)
+		if err != nil && domain.ErrorCode(err) == "" {
+			return nil, err
+		}
+		results = append(results, domain.BatchResult{ID: outcome.ID, Err: err})
+	}
+	return results, nil
+}
+
+// ExtendLease prolongs a claimed task lease, returns the new lease expiration time.
+// Lease of a cancelled or overdue task isn't prolonged.
+func (gw *TaskGateway) ExtendLease(ctx context.Context, id, claimID uuid.UUID, lease time.Duration) (time.Time, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	task, err := gw.claimed(id, claimID)
+	if err != nil || task.State != domain.StateProcessing {
+		return time.Time{}, domain.Error{Code: domain.ErrStaleResult, Message: "claim is stale"}
+	}
+	at := now()
+	if task.CancelRequested {
+		gw.finish(task, domain.StateCancelled, at)
+		return time.Time{}, domain.Error{Code: domain.ErrTaskCancelled, Message: "task was cancelled"}
+	}
+	if task.Deadline.Before(at) {
+		gw.finish(task, domain.StateExpired, at)
+		return time.Time{}, domain.Error{Code: domain.ErrDeadlineExceeded, Message: "deadline exceeded"}
+	}
+	task.ExecuteAt = at.Add(lease)
+	return task.ExecuteAt, nil
+}
+
+// ReleaseTask makes a claimed task claimable at once, its attempts aren't changed.
+// A cancelled or overdue task is finished instead.
+func (gw *TaskGateway) ReleaseTask(ctx context.Context, id, claimID uuid.UUID) error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	task, err := gw.claimed(id, claimID)
+	if err != nil || task.State != domain.StateProcessing {
+		return domain.Error{Code: domain.ErrStaleResult, Message: "claim is stale"}
+	}
+	at := now()
+	if task.CancelRequested {
+		gw.finish(task, domain.StateCancelled, at)
+		return domain.Error{Code: domain.ErrTaskCancelled, Message: "task was cancelled"}
+	}
+	if task.Deadline.Before(at) {
+		gw.finish(task, domain.StateExpired, at)
+		return domain.Error{Code: domain.ErrDeadlineExceeded, Message: "deadline exceeded"}
+	}
+	task.ClaimID = nil
+	task.ExecuteAt = at
+	gw.setState(task, domain.StatePending, at)
+	return nil
+}
+
+// DeleteStaleTasks removes stale tasks.
+func (gw *TaskGateway) DeleteStaleTasks(ctx context.Context, staleHours int) (int64, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	staleAt := now().Add(-time.Duration(staleHours) * time.Hour)
+	var deleted int64
+	for id, task := range gw.tasks {
+		switch task.State {
+		case domain.StateSucceeded, domain.StateExpired, domain.StateCancelled:
+		default:
+			continue
+		}
+		if task.DoneAt.Valid && task.DoneAt.Time.Before(staleAt) {
+			delete(gw.tasks, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// CancelAbandonedTasks marks cancel-requested tasks with an expired lease as cancelled.
+func (gw *TaskGateway) CancelAbandonedTasks(ctx context.Context) (int64, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	at := now()
+	var cancelled int64
+	for _, task := range gw.tasks {
+		if task.State == domain.StateProcessing && task.CancelRequested && task.ExecuteAt.Before(at) {
+			gw.finish(task, domain.StateCancelled, at)
+			cancelled++
+		}
+	}
+	return cancelled, nil
+}
+
+// ExpireOverdueTasks marks unfinished tasks after their deadline as expired.
+func (gw *TaskGateway) ExpireOverdueTasks(ctx context.Context) (int64, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	at := now()
+	var expired int64
+	for _, task := range gw.tasks {
+		if !task.Deadline.Before(at) {
+			continue
+		}
+		switch {
+		case task.State == domain.StatePending, task.State == domain.StateFailed:
+		case task.State == domain.StateProcessing && task.ExecuteAt.Before(at):
+		default:
+			continue
+		}
+		gw.finish(task, domain.StateExpired, at)
+		expired++
+	}
+	return expired, nil
+}
+
+// MoveExhaustedTasks moves exhausted tasks to dead letters.
+func (gw *TaskGateway) MoveExhaustedTasks(ctx context.Context) (int64, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	at := now()
+	var moved int64
+	for id, task := range gw.tasks {
+		if task.State != domain.StateExhausted {
+			continue
+		}
+		failures, err := history(task.Meta)
+		if err != nil {
+			return moved, err
+		}
+		gw.deadLetters[id] = &deadLetter{
+			DeadLetter: domain.DeadLetter{
+				ID:          task.ID,
+				ExecuteAt:   task.ExecuteAt,
+				Deadline:    task.Deadline,
+				Queue:       task.Queue,
+				Priority:    task.Priority,
+				Payload:     task.Payload,
+				RetryPolicy: task.RetryPolicy,
+				FailReason:  failReason(task.Meta),
+				Attempts:    attempts(task.Meta),
+				History:     failures,
+				CreatedAt:   task.CreatedAt,
+				DeadAt:      at,
+			},
+			callbackURL: task.CallbackURL,
+		}
+		delete(gw.tasks, id)
+		moved++
+	}
+	return moved, nil
+}
+
+// copyDeadLetter detaches a dead letter from the storage, so callers can't change it.
+func copyDeadLetter(letter *deadLetter) *domain.DeadLetter {
+	copied := letter.DeadLetter
+	copied.Payload = copyMap(letter.Payload)
+	copied.RetryPolicy = copyPolicy(letter.RetryPolicy)
+	copied.History = append([]domain.Failure{}, letter.History...)
+	return &copied
+}
+
+// ListDeadLetters returns dead letters ordered by death time.
+func (gw *TaskGateway) ListDeadLetters(ctx context.Context, limit, offset int) ([]*domain.DeadLetter, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	sorted := make([]*deadLetter, 0, len(gw.deadLetters))
+	for _, letter := range gw.deadLetters {
+		sorted = append(sorted, letter)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return compareKeys(sorted[i].DeadAt, sorted[i].ID, sorted[j].DeadAt, sorted[j].ID) < 0
+	})
+	letters := make([]*domain.DeadLetter, 0)
+	for _, letter := range page(len(sorted), limit, offset) {
+		letters = append(letters, copyDeadLetter(sorted[letter]))
+	}
+	return letters, nil
+}
+
+// page returns indexes of a limit/offset page of n items.
+func page(n, limit, offset int) []int {
+	indexes := make([]int, 0)
+	for idx := offset; idx < n && idx < offset+limit; idx++ {
+		if idx >= 0 {
+			indexes = append(indexes, idx)
+		}
+	}
+	return indexes
+}
+
+// FindDeadLetterByID returns a dead letter by task id.
+func (gw *TaskGateway) FindDeadLetterByID(ctx context.Context, id uuid.UUID) (*domain.DeadLetter, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	letter, ok := gw.deadLetters[id]
+	if !ok {
+		return nil, domain.Error{Code: domain.ErrTaskNotFound, Message: "dead letter not found"}
+	}
+	return copyDeadLetter(letter), nil
+}
+
+// RequeueDeadLetter moves a dead letter back to task as a pending one.
+func (gw *TaskGateway) RequeueDeadLetter(ctx context.Context, id uuid.UUID) (*domain.Task, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	letter, ok := gw.deadLetters[id]
+	if !ok {
+		return nil, domain.Error{Code: domain.ErrTaskNotFound, Message: "dead letter not found"}
+	}
+	if _, ok := gw.tasks[id]; ok {
+		return nil, domain.Error{Code: domain.ErrDuplicateTask, Message: "task already set"}
+	}
+	at := now()
+	task, err := newTask(&domain.Task{
+		ID:          letter.ID,
+		ExecuteAt:   at,
+		Deadline:    letter.Deadline,
+		Queue:       letter.Queue,
+		Priority:    letter.Priority,
+		Payload:     letter.Payload,
+		Meta:        map[string]interface{}{"history": letter.History},
+		RetryPolicy: letter.RetryPolicy,
+		CallbackURL: letter.callbackURL,
+	}, at)
+	if err != nil {
+		return nil, err
+	}
+	delete(gw.deadLetters, id)
+	gw.insert(task)
+	return copyTask(task), nil
+}
+
+// PurgeDeadLetters removes dead letters by task ids.
+func (gw *TaskGateway) PurgeDeadLetters(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	var purged int64
+	for _, id := range ids {
+		if _, ok := gw.deadLetters[id]; ok {
+			delete(gw.deadLetters, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// copySchedule detaches a schedule from the storage, so callers can't change it.
+func copySchedule(schedule *domain.Schedule) *domain.Schedule {
+	copied := *schedule
+	copied.Payload = copyMap(schedule.Payload)
+	copied.RetryPolicy = copyPolicy(schedule.RetryPolicy)
+	return &copied
+}
+
+// CreateSchedule makes record with new schedule.
+func (gw *TaskGateway) CreateSchedule(ctx context.Context, schedule *domain.Schedule) (*domain.Schedule, error) {
+	payload, err := normalize(schedule.Payload)
+	if err != nil {
+		return nil, err
+	}
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if _, ok := gw.schedules[schedule.ID]; ok {
+		return nil, domain.Error{Code: domain.ErrDuplicateTask, Message: "schedule already set"}
+	}
+	record := copySchedule(schedule)
+	record.Payload = payload
+	record.NextRunAt = schedule.NextRunAt.UTC()
+	record.LastRunAt = sql.NullTime{}
+	record.CreatedAt = now()
+	gw.schedules[record.ID] = record
+	return copySchedule(record), nil
+}
+
+// FindScheduleByID returns a schedule by id.
+func (gw *TaskGateway) FindScheduleByID(ctx context.Context, id uuid.UUID) (*domain.Schedule, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	schedule, ok := gw.schedules[id]
+	if !ok {
+		return nil, domain.Error{Code: domain.ErrScheduleNotFound, Message: "schedule not found"}
+	}
+	return copySchedule(schedule), nil
+}
+
+// ListSchedules returns schedules ordered by creation time.
+func (gw *TaskGateway) ListSchedules(ctx context.Context, limit, offset int) ([]*domain.Schedule, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	sorted := make([]*domain.Schedule, 0, len(gw.schedules))
+	for _, schedule := range gw.schedules {
+		sorted = append(sorted, schedule)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return compareKeys(sorted[i].CreatedAt, sorted[i].ID, sorted[j].CreatedAt, sorted[j].ID) < 0
+	})
+	schedules := make([]*domain.Schedule, 0)
+	for _, idx := range page(len(sorted), limit, offset) {
+		schedules = append(schedules, copySchedule(sorted[idx]))
+	}
+	return schedules, nil
+}
+
+// DeleteSchedule removes a schedule.
+func (gw *TaskGateway) DeleteSchedule(ctx context.Context, id uuid.UUID) error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if _, ok := gw.schedules[id]; !ok {
+		return domain.Error{Code: domain.ErrScheduleNotFound, Message: "schedule not found"}
+	}
+	delete(gw.schedules, id)
+	return nil
+}
+
+// FindDueSchedules returns schedules with the next tick before `until`.
+func (gw *TaskGateway) FindDueSchedules(ctx context.Context, until time.Time, limit int) ([]*domain.Schedule, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	due := make([]*domain.Schedule, 0)
+	for _, schedule := range gw.schedules {
+		if !schedule.NextRunAt.After(until) {
+			due = append(due, schedule)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return compareKeys(due[i].NextRunAt, due[i].ID, due[j].NextRunAt, due[j].ID) < 0
+	})
+	schedules := make([]*domain.Schedule, 0)
+	for _, idx := range page(len(due), limit, 0) {
+		schedules = append(schedules, copySchedule(due[idx]))
+	}
+	return schedules, nil
+}
+
+// AdvanceSchedule moves schedule's next tick from `from` to `to` and creates tasks atomically.
+// Tasks with existing IDs are skipped. If the next tick was already moved, nothing happens.
+func (gw *TaskGateway) AdvanceSchedule(ctx context.Context, id uuid.UUID, from, to time.Time, tasks []*domain.Task) (int64, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	schedule, ok := gw.schedules[id]
+	if !ok || !schedule.NextRunAt.Equal(from) {
+		return 0, nil
+	}
+	at := now()
+	records := make([]*domain.Task, 0, len(tasks))
+	for _, task := range tasks {
+		record, err := newTask(task, at)
+		if err != nil {
+			return 0, err
+		}
+		records = append(records, record)
+	}
+	for _, record := range records {
+		if !schedule.LastRunAt.Valid || record.ExecuteAt.After(schedule.LastRunAt.Time) {
+			schedule.LastRunAt = sql.NullTime{Time: record.ExecuteAt, Valid: true}
+		}
+	}
+	schedule.NextRunAt = to.UTC()
+	var created int64
+	for _, record := range records {
+		if _, ok := gw.tasks[record.ID]; ok {
+			continue
+		}
+		gw.insert(record)
+		created++
+	}
+	return created, nil
+}
+
+// enqueueWebhook makes a delivery of a finished task to its callback URL.
+func (gw *TaskGateway) enqueueWebhook(task *domain.Task, at time.Time) {
+	// Payload is kept decoded like a JSONB column.
+	var doneAt interface{}
+	if task.DoneAt.Valid {
+		doneAt = task.DoneAt.Time.Format(time.RFC3339Nano)
+	}
+	gw.lastWebhook++
+	gw.webhooks[gw.lastWebhook] = &domain.Webhook{
+		ID:     gw.lastWebhook,
+		TaskID: task.ID,
+		URL:    task.CallbackURL,
+		Payload: map[string]interface{}{
+			"id":         task.ID.String(),
+			"state":      string(task.State),
+			"queue":      task.Queue,
+			"payload":    copyMap(task.Payload),
+			"result":     copyMap(task.Result),
+			"failReason": failReason(task.Meta),
+			"attempts":   float64(attempts(task.Meta)),
+			"createdAt":  task.CreatedAt.Format(time.RFC3339Nano),
+			"doneAt":     doneAt,
+		},
+		State:         domain.WebhookPending,
+		NextAttemptAt: at,
+		CreatedAt:     at,
+	}
+}
+
+// copyWebhook detaches a webhook from the storage, so callers can't change it.
+func copyWebhook(webhook *domain.Webhook) *domain.Webhook {
+	copied := *webhook
+	copied.Payload = copyMap(webhook.Payload)
+	return &copied
+}
+
+// ClaimWebhooks locks due webhooks for delivery during lease.
+func (gw *TaskGateway) ClaimWebhooks(ctx context.Context, limit int, lease time.Duration) ([]*domain.Webhook, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	at := now()
+	due := make([]*domain.Webhook, 0)
+	for _, webhook := range gw.webhooks {
+		if webhook.State == domain.WebhookPending && !webhook.NextAttemptAt.After(at) {
+			due = append(due, webhook)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	webhooks := make([]*domain.Webhook, 0)
+	for _, idx := range page(len(due), limit, 0) {
+		webhook := due[idx]
+		webhook.Attempts++
+		webhook.NextAttemptAt = at.Add(lease)
+		webhooks = append(webhooks, copyWebhook(webhook))
+	}
+	return webhooks, nil
+}
+
+// MarkWebhookDelivered marks a webhook as delivered.
+func (gw *TaskGateway) MarkWebhookDelivered(ctx context.Context, id int64) error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if webhook, ok := gw.webhooks[id]; ok {
+		webhook.State = domain.WebhookDelivered
+		webhook.DeliveredAt = sql.NullTime{Time: now(), Valid: true}
+	}
+	return nil
+}
+
+// RetryWebhook postpones a webhook delivery after a failed attempt.
+func (gw *TaskGateway) RetryWebhook(ctx context.Context, id int64, reason string, retryAt time.Time) error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if webhook, ok := gw.webhooks[id]; ok {
+		webhook.LastError = reason
+		webhook.NextAttemptAt = retryAt.UTC()
+	}
+	return nil
+}
+
+// MarkWebhookFailed marks a webhook without attempts left as failed.
+func (gw *TaskGateway) MarkWebhookFailed(ctx context.Context, id int64, reason string) error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if webhook, ok := gw.webhooks[id]; ok {
+		webhook.State = domain.WebhookFailed
+		webhook.LastError = reason
+	}
+	return nil
+}
+
+// DeleteStaleWebhooks removes delivered and failed webhooks.
+func (gw *TaskGateway) DeleteStaleWebhooks(ctx context.Context, staleHours int) (int64, error) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	staleAt := now().Add(-time.Duration(staleHours) * time.Hour)
+	var deleted int64
+	for id, webhook := range gw.webhooks {
+		if webhook.State != domain.WebhookPending && webhook.CreatedAt.Before(staleAt) {
+			delete(gw.webhooks, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
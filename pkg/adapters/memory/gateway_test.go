@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/freundallein/scheduler/pkg/gatewaytest"
+)
+
+func TestTaskGateway(t *testing.T) {
+	gatewaytest.Run(t, func(t *testing.T) domain.Gateway {
+		return NewTaskGateway()
+	})
+}
@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+)
+
+const (
+	// SignatureHeader contains HMAC-SHA256 of the request body, e.g. "sha256=<hex>".
+	SignatureHeader = "X-Scheduler-Signature"
+	// DeliveryHeader contains a delivery identifier, it's the same for every attempt.
+	DeliveryHeader = "X-Scheduler-Delivery"
+)
+
+// Sender delivers webhooks with HTTP POST requests.
+type Sender struct {
+	secret  []byte
+	httpcli *http.Client
+
+	allowedNetworks []*net.IPNet
+}
+
+// Option is used to configure Sender.
+type Option func(s *Sender)
+
+// WithAllowedNetworks allows callbacks to non-public addresses of networks.
+func WithAllowedNetworks(networks ...*net.IPNet) Option {
+	return func(s *Sender) {
+		s.allowedNetworks = append(s.allowedNetworks, networks...)
+	}
+}
+
+// ParseNetworks parses comma separated CIDR networks, e.g. "10.0.0.0/8,fd00::/8".
+func ParseNetworks(spec string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// New returns a domain.WebhookSender implementation.
+// Requests are signed with secret, so receivers can verify them.
+// Callback URLs can't point to loopback, private and link-local addresses, unless they are allowed,
+// so tasks can't make scheduler reach internal services.
+func New(secret string, timeout time.Duration, opts ...Option) *Sender {
+	s := &Sender{
+		secret: []byte(secret),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		// Addresses are checked after resolution, so a callback host can't be rebound to an internal one.
+		Control: s.control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Proxy would be dialed instead of a callback host.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	s.httpcli = &http.Client{Timeout: timeout, Transport: transport}
+	return s
+}
+
+// control refuses connections to addresses, that callbacks aren't allowed to reach.
+func (s *Sender) control(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("callback address %q is not an ip", host)
+	}
+	if !s.allowed(ip) {
+		return fmt.Errorf("callback address %s is not allowed", ip)
+	}
+	return nil
+}
+
+// allowed reports whether callbacks may reach ip.
+func (s *Sender) allowed(ip net.IP) bool {
+	for _, network := range s.allowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return public(ip)
+}
+
+// sharedAddressSpace is used by carrier-grade NAT, it's not public either.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// public reports whether ip is a global unicast address outside of private networks.
+func public(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// Send posts a webhook payload, any non-2xx response is treated as a failure.
+func (s *Sender) Send(ctx context.Context, webhook *domain.Webhook) error {
+	body, err := json.Marshal(webhook.Payload)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(DeliveryHeader, strconv.FormatInt(webhook.ID, 10))
+	request.Header.Set(SignatureHeader, Sign(s.secret, body))
+	response, err := s.httpcli.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 4096))
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns a signature header value for body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature matches body, receivers should use it.
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
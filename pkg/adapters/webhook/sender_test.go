@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/google/uuid"
+)
+
+func TestSend(t *testing.T) {
+	loopback, err := ParseNetworks("127.0.0.0/8, ::1/128")
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	tests := []struct {
+		name      string
+		opts      []Option
+		delivered bool
+	}{
+		{
+			name: "loopback refused",
+		},
+		{
+			name:      "allowed network",
+			opts:      []Option{WithAllowedNetworks(loopback...)},
+			delivered: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			delivered := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				delivered = Verify([]byte("secret"), body, r.Header.Get(SignatureHeader))
+			}))
+			defer server.Close()
+			sender := New("secret", time.Second, tc.opts...)
+			err := sender.Send(context.Background(), &domain.Webhook{
+				ID:      1,
+				TaskID:  uuid.New(),
+				URL:     server.URL,
+				Payload: map[string]interface{}{"state": "succeeded"},
+			})
+			if tc.delivered && err != nil {
+				t.Errorf("Expected `%v`, got: `%v`", nil, err)
+			}
+			if !tc.delivered && err == nil {
+				t.Errorf("Expected error, got: `%v`", err)
+			}
+			if delivered != tc.delivered {
+				t.Errorf("Expected `%v`, got: `%v`", tc.delivered, delivered)
+			}
+		})
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{ip: "93.184.216.34", expected: true},
+		{ip: "2606:2800:220:1:248:1893:25c8:1946", expected: true},
+		{ip: "127.0.0.1"},
+		{ip: "::1"},
+		{ip: "10.1.2.3"},
+		{ip: "172.16.0.1"},
+		{ip: "192.168.1.1"},
+		{ip: "169.254.169.254"},
+		{ip: "100.64.0.1"},
+		{ip: "0.0.0.0"},
+		{ip: "fd00::1"},
+		{ip: "fe80::1"},
+		{ip: "::ffff:127.0.0.1"},
+	}
+	sender := New("secret", time.Second)
+	for _, tc := range tests {
+		t.Run(tc.ip, func(t *testing.T) {
+			if observed := sender.allowed(net.ParseIP(tc.ip)); observed != tc.expected {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expected, observed)
+			}
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks("10.1.0.0/16,,fd00::/8")
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	if len(networks) != 2 || !networks[0].Contains(net.ParseIP("10.1.2.3")) {
+		t.Errorf("Expected 2 networks, got: `%v`", networks)
+	}
+	_, err = ParseNetworks("10.1.0.0")
+	if err == nil {
+		t.Errorf("Expected error, got: `%v`", err)
+	}
+}
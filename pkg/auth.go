@@ -0,0 +1,60 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// Scope grants access to one of the APIs.
+type Scope string
+
+const (
+	// ScopeScheduler grants access to the public scheduler API.
+	ScopeScheduler Scope = "scheduler"
+	// ScopeWorker grants access to the worker API.
+	ScopeWorker Scope = "worker"
+	// ScopeAdmin grants access to the admin API.
+	ScopeAdmin Scope = "admin"
+)
+
+// Valid reports whether the scope is one of the known ones.
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeScheduler, ScopeWorker, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// Credential describes a named API client.
+type Credential struct {
+	// Name identifies a client, e.g. a team.
+	Name string `json:"name"`
+	// SecretHash is a hash of the client's secret, the secret itself is never stored.
+	SecretHash string `json:"secretHash"`
+	// Scopes are APIs, that the client is allowed to call.
+	Scopes []Scope `json:"scopes"`
+	// ExpiresAt is a time, when the credential stops working, nil means never.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Allows reports whether the credential grants the scope.
+func (c *Credential) Allows(scope Scope) bool {
+	for _, granted := range c.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the credential doesn't work at the moment.
+func (c *Credential) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !now.Before(*c.ExpiresAt)
+}
+
+// Authenticator checks a token presented by an API client.
+type Authenticator interface {
+	// Authenticate returns the client's credential, if the token is valid.
+	Authenticate(ctx context.Context, token string) (*Credential, error)
+}
@@ -1,41 +1,23 @@
 package client
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"fmt"
 	domain "github.com/freundallein/scheduler/pkg"
 	"github.com/google/uuid"
-	"io/ioutil"
-	"net/http"
 	"strconv"
 	"time"
 )
 
-type rpcResponse struct {
-	// ID is current response ID.
-	// Should be equals request ID.
-	ID string `json:"id"`
-	// Error shows error message
-	Error string `json:"error,omitempty"`
-}
-
 // Scheduler implements client for a public interface domain.Scheduler.
 type Scheduler struct {
-	url         string
-	accessToken string
-	httpcli     *http.Client
+	rpcClient
 }
 
 // NewScheduler returns an instance of Scheduler.
 func NewScheduler(address string, timeout time.Duration, opts ...SchedulerOption) *Scheduler {
-	client := &http.Client{
-		Timeout: timeout,
-	}
 	scheduler := &Scheduler{
-		url:     fmt.Sprintf("http://%s/rpc/v0", address),
-		httpcli: client,
+		rpcClient: newRPCClient(address, "/rpc/v0", timeout),
 	}
 	for _, opt := range opts {
 		opt(scheduler)
@@ -43,114 +25,165 @@ func NewScheduler(address string, timeout time.Duration, opts ...SchedulerOption
 	return scheduler
 }
 
-func (s *Scheduler) makeRequest(payload map[string]interface{}) (interface{}, error) {
-	requestBody, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
+// Set allows to enqueue task.
+func (s *Scheduler) Set(executeAt, deadline time.Time, payload map[string]interface{}, opts ...TaskOption) (*uuid.UUID, error) {
+	return s.SetContext(context.Background(), executeAt, deadline, payload, opts...)
+}
+
+// SetContext allows to enqueue task, it's retried according to the client's retry policy.
+func (s *Scheduler) SetContext(ctx context.Context, executeAt, deadline time.Time, payload map[string]interface{}, opts ...TaskOption) (*uuid.UUID, error) {
+	taskID := uuid.New()
+	params := map[string]interface{}{
+		"id":        taskID,
+		"executeAt": executeAt,
+		"deadline":  deadline,
+		"payload":   payload,
 	}
-	request, err := http.NewRequest("POST", s.url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return nil, err
+	for _, opt := range opts {
+		opt(params)
 	}
-	request.Header.Set("Content-Type", "application/json")
-	if s.accessToken != "" {
-		request.Header.Set("Auth", s.accessToken)
+	attempts, err := s.retryCall(ctx, "Scheduler.Set", params, nil)
+	// The task is generated by the client, so it's set by a previous attempt,
+	// which response was lost.
+	if attempts > 1 && domain.ErrorCode(err) == domain.ErrDuplicateTask {
+		err = nil
 	}
 	if err != nil {
-		return nil, err
+		return &taskID, err
 	}
-	resp, err := s.httpcli.Do(request)
+	return &taskID, nil
+}
+
+// NewTask describes a task for Scheduler.SetMany.
+type NewTask struct {
+	// ID is an idempotence key, it's generated if empty.
+	ID        uuid.UUID
+	ExecuteAt time.Time
+	Deadline  time.Time
+	Payload   map[string]interface{}
+	Options   []TaskOption
+}
+
+type batchResult struct {
+	Results []struct {
+		ID    uuid.UUID     `json:"id"`
+		Error *domain.Error `json:"error"`
+	} `json:"results"`
+}
+
+// results returns per-item results of a batch operation.
+func (r *batchResult) results() []domain.BatchResult {
+	results := make([]domain.BatchResult, 0, len(r.Results))
+	for _, item := range r.Results {
+		result := domain.BatchResult{ID: item.ID}
+		if item.Error != nil {
+			result.Err = *item.Error
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// SetMany allows to enqueue tasks atomically.
+// Results are in the same order as tasks, a duplicate task has ErrDuplicateTask error code.
+func (s *Scheduler) SetMany(tasks []NewTask) ([]domain.BatchResult, error) {
+	return s.SetManyContext(context.Background(), tasks)
+}
+
+// SetManyContext allows to enqueue tasks atomically.
+// Results are in the same order as tasks, a duplicate task has ErrDuplicateTask error code.
+func (s *Scheduler) SetManyContext(ctx context.Context, tasks []NewTask) ([]domain.BatchResult, error) {
+	batch := make([]map[string]interface{}, 0, len(tasks))
+	for _, task := range tasks {
+		id := task.ID
+		if id == uuid.Nil {
+			id = uuid.New()
+		}
+		params := map[string]interface{}{
+			"id":        id,
+			"executeAt": task.ExecuteAt,
+			"deadline":  task.Deadline,
+			"payload":   task.Payload,
+		}
+		for _, opt := range task.Options {
+			opt(params)
+		}
+		batch = append(batch, params)
+	}
+	var result batchResult
+	err := s.call(ctx, "Scheduler.SetMany", map[string]interface{}{"tasks": batch}, &result)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	return result.results(), nil
+}
+
+type taskResult struct {
+	Meta map[string]interface{} `json:"meta"`
+	Task *domain.Task           `json:"task"`
+}
+
+// Get allows to poll a task state.
+func (s *Scheduler) Get(id uuid.UUID) (*domain.Task, error) {
+	return s.GetContext(context.Background(), id)
+}
+
+// GetContext allows to poll a task state, it's retried according to the client's retry policy.
+func (s *Scheduler) GetContext(ctx context.Context, id uuid.UUID) (*domain.Task, error) {
+	var result taskResult
+	_, err := s.retryCall(ctx, "Scheduler.Get", map[string]interface{}{"id": id}, &result)
 	if err != nil {
 		return nil, err
 	}
-	return body, nil
+	return result.Task, nil
 }
 
-type setResponse struct {
-	rpcResponse
-	Result map[string]interface{} `json:"result"`
+// Cancel allows to withdraw a task.
+func (s *Scheduler) Cancel(id uuid.UUID) (*domain.Task, error) {
+	return s.CancelContext(context.Background(), id)
 }
 
-// Set allows to enqueue task.
-func (s *Scheduler) Set(executeAt, deadline time.Time, payload map[string]interface{}) (*uuid.UUID, error) {
-	taskID := uuid.New()
-	request := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "Scheduler.Set",
-		"id":      "1",
-		"params": []map[string]interface{}{
-			{
-				"id":        taskID,
-				"executeAt": executeAt,
-				"deadline":  deadline,
-				"payload":   payload,
-			},
-		},
-	}
-	responseBody, err := s.makeRequest(request)
-	var response setResponse
-	err = json.Unmarshal(responseBody.([]byte), &response)
+// CancelContext allows to withdraw a task.
+func (s *Scheduler) CancelContext(ctx context.Context, id uuid.UUID) (*domain.Task, error) {
+	var result taskResult
+	err := s.call(ctx, "Scheduler.Cancel", map[string]interface{}{"id": id}, &result)
 	if err != nil {
-		return &taskID, err
-	}
-	if response.Error != "" {
-		return &taskID, errors.New(response.Error)
+		return nil, err
 	}
-	return &taskID, nil
+	return result.Task, nil
 }
 
-type getResponse struct {
-	rpcResponse
-	Result struct {
-		Meta map[string]interface{} `json:"meta"`
-		Task *domain.Task           `json:"task"`
-	} `json:"result"`
+// Wait blocks until a task is finished or timeout expires, returns the task's last state.
+// Client's timeout should be greater than the wait timeout.
+func (s *Scheduler) Wait(id uuid.UUID, timeout time.Duration) (*domain.Task, error) {
+	return s.WaitContext(context.Background(), id, timeout)
 }
 
-// Get allows to poll a task state.
-func (s *Scheduler) Get(id uuid.UUID) (*domain.Task, error) {
-	request := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "Scheduler.Get",
-		"id":      "1",
-		"params": []map[string]interface{}{
-			{
-				"id": id,
-			},
-		},
-	}
-	responseBody, err := s.makeRequest(request)
-	var response getResponse
-	err = json.Unmarshal(responseBody.([]byte), &response)
+// WaitContext blocks until a task is finished or timeout expires, returns the task's last state.
+// Client's timeout should be greater than the wait timeout.
+func (s *Scheduler) WaitContext(ctx context.Context, id uuid.UUID, timeout time.Duration) (*domain.Task, error) {
+	var result taskResult
+	err := s.call(ctx, "Scheduler.Wait", map[string]interface{}{
+		"id":      id,
+		"timeout": timeout.String(),
+	}, &result)
 	if err != nil {
 		return nil, err
 	}
-	if response.Error != "" {
-		return nil, errors.New(response.Error)
-	}
-	return response.Result.Task, nil
+	return result.Task, nil
 }
 
 // Worker implements client for a private interface domain.Worker.
 type Worker struct {
-	url         string
-	accessToken string
-	httpcli     *http.Client
+	rpcClient
+	lease time.Duration
+	wait  time.Duration
 }
 
 // NewWorker returns an instance of Worker.
 func NewWorker(address string, timeout time.Duration, opts ...WorkerOption) *Worker {
-	client := &http.Client{
-		Timeout: timeout,
-	}
 	worker := &Worker{
-		url:     fmt.Sprintf("http://%s/worker/v0", address),
-		httpcli: client,
+		rpcClient: newRPCClient(address, "/worker/v0", timeout),
 	}
 	for _, opt := range opts {
 		opt(worker)
@@ -158,140 +191,177 @@ func NewWorker(address string, timeout time.Duration, opts ...WorkerOption) *Wor
 	return worker
 }
 
-func (w *Worker) makeRequest(payload map[string]interface{}) (interface{}, error) {
-	requestBody, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
-	request, err := http.NewRequest("POST", w.url, bytes.NewBuffer(requestBody))
+type claimResult struct {
+	Count int            `json:"count"`
+	Tasks []*domain.Task `json:"tasks"`
+}
+
+// Claim takes a list of tasks from the named queues or from any queue, if none are named.
+func (w *Worker) Claim(amount int, queues ...string) ([]*domain.Task, error) {
+	return w.ClaimContext(context.Background(), amount, queues...)
+}
+
+// ClaimContext takes a list of tasks from the named queues or from any queue, if none are named.
+func (w *Worker) ClaimContext(ctx context.Context, amount int, queues ...string) ([]*domain.Task, error) {
+	var result claimResult
+	err := w.call(ctx, "Worker.Claim", map[string]interface{}{
+		"amount": strconv.Itoa(amount),
+		"queues": queues,
+		"lease":  leaseParam(w.lease),
+		"wait":   w.wait.String(),
+	}, &result)
 	if err != nil {
 		return nil, err
 	}
-	request.Header.Set("Content-Type", "application/json")
-	if w.accessToken != "" {
-		request.Header.Set("Auth", w.accessToken)
-	}
+	return result.Tasks, nil
+}
+
+type messageResult struct {
+	Message string `json:"message"`
+}
+
+// Succeed marks a task as done.
+func (w *Worker) Succeed(id, claimID uuid.UUID, result map[string]interface{}) error {
+	return w.SucceedContext(context.Background(), id, claimID, result)
+}
+
+// SucceedContext marks a task as done.
+func (w *Worker) SucceedContext(ctx context.Context, id, claimID uuid.UUID, result map[string]interface{}) error {
+	var response messageResult
+	err := w.call(ctx, "Worker.Succeed", map[string]interface{}{
+		"id":      id,
+		"claimID": claimID,
+		"result":  result,
+	}, &response)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	resp, err := w.httpcli.Do(request)
-	if err != nil {
-		return nil, err
+	if response.Message != "success" {
+		return errors.New("succeed op was unsuccessful")
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	return nil
+}
+
+// SucceedMany marks tasks as done with a single request.
+// Results are in the same order as outcomes, e.g. a stale claim has ErrStaleResult error code.
+func (w *Worker) SucceedMany(outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	return w.SucceedManyContext(context.Background(), outcomes)
+}
+
+// SucceedManyContext marks tasks as done with a single request.
+// Results are in the same order as outcomes, e.g. a stale claim has ErrStaleResult error code.
+func (w *Worker) SucceedManyContext(ctx context.Context, outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	tasks := make([]map[string]interface{}, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		tasks = append(tasks, map[string]interface{}{
+			"id":      outcome.ID,
+			"claimID": outcome.ClaimID,
+			"result":  outcome.Result,
+		})
+	}
+	return w.batch(ctx, "Worker.SucceedMany", tasks)
+}
+
+// FailMany marks tasks as failed with a single request.
+// Results are in the same order as outcomes, e.g. a stale claim has ErrStaleResult error code.
+func (w *Worker) FailMany(outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	return w.FailManyContext(context.Background(), outcomes)
+}
+
+// FailManyContext marks tasks as failed with a single request.
+// Results are in the same order as outcomes, e.g. a stale claim has ErrStaleResult error code.
+func (w *Worker) FailManyContext(ctx context.Context, outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	tasks := make([]map[string]interface{}, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		tasks = append(tasks, map[string]interface{}{
+			"id":      outcome.ID,
+			"claimID": outcome.ClaimID,
+			"reason":  outcome.Reason,
+		})
+	}
+	return w.batch(ctx, "Worker.FailMany", tasks)
+}
+
+func (w *Worker) batch(ctx context.Context, method string, tasks []map[string]interface{}) ([]domain.BatchResult, error) {
+	var result batchResult
+	err := w.call(ctx, method, map[string]interface{}{"tasks": tasks}, &result)
 	if err != nil {
 		return nil, err
 	}
-	return body, nil
-}
-
-type claimResponse struct {
-	rpcResponse
-	Result struct {
-		Count int            `json:"count"`
-		Tasks []*domain.Task `json:"tasks"`
-	} `json:"result"`
-}
-
-// Claim takes a list of tasks.
-func (w *Worker) Claim(amount int) ([]*domain.Task, error) {
-	request := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "Worker.Claim",
-		"id":      "1",
-		"params": []map[string]interface{}{
-			{
-				"amount": strconv.Itoa(amount),
-			},
-		},
-	}
-	responseBody, err := w.makeRequest(request)
-	var response claimResponse
-	err = json.Unmarshal(responseBody.([]byte), &response)
+	return result.results(), nil
+}
+
+type heartbeatResult struct {
+	LeasedUntil time.Time `json:"leasedUntil"`
+}
+
+// Heartbeat prolongs a task lease, zero lease means the server's default one.
+// Returns the new lease expiration time.
+func (w *Worker) Heartbeat(id, claimID uuid.UUID, lease time.Duration) (time.Time, error) {
+	return w.HeartbeatContext(context.Background(), id, claimID, lease)
+}
+
+// HeartbeatContext prolongs a task lease, zero lease means the server's default one.
+// Returns the new lease expiration time.
+func (w *Worker) HeartbeatContext(ctx context.Context, id, claimID uuid.UUID, lease time.Duration) (time.Time, error) {
+	var result heartbeatResult
+	err := w.call(ctx, "Worker.Heartbeat", map[string]interface{}{
+		"id":      id,
+		"claimID": claimID,
+		"lease":   leaseParam(lease),
+	}, &result)
 	if err != nil {
-		return nil, err
+		return time.Time{}, err
 	}
-	if response.Error != "" {
-		return nil, errors.New(response.Error)
+	return result.LeasedUntil, nil
+}
+
+// leaseParam formats a lease for the API, empty lease means the server's default one.
+func leaseParam(lease time.Duration) string {
+	if lease <= 0 {
+		return ""
 	}
-	return response.Result.Tasks, nil
+	return lease.String()
 }
 
-type succeedResponse struct {
-	rpcResponse
-	Result struct {
-		Message string `json:"message"`
-	} `json:"result"`
+// Release gives a claimed task back unprocessed, so it's claimed again at once.
+func (w *Worker) Release(id, claimID uuid.UUID) error {
+	return w.ReleaseContext(context.Background(), id, claimID)
 }
 
-// Succeed marks a task as done.
-func (w *Worker) Succeed(id, claimID uuid.UUID, result map[string]interface{}) error {
-	request := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "Worker.Succeed",
-		"id":      "1",
-		"params": []map[string]interface{}{
-			{
-				"id":      id,
-				"claimID": claimID,
-				"result":  result,
-			},
-		},
-	}
-	responseBody, err := w.makeRequest(request)
-	if err != nil {
-		return err
-	}
-	var response succeedResponse
-	err = json.Unmarshal(responseBody.([]byte), &response)
+// ReleaseContext gives a claimed task back unprocessed, so it's claimed again at once.
+func (w *Worker) ReleaseContext(ctx context.Context, id, claimID uuid.UUID) error {
+	var response messageResult
+	err := w.call(ctx, "Worker.Release", map[string]interface{}{
+		"id":      id,
+		"claimID": claimID,
+	}, &response)
 	if err != nil {
 		return err
 	}
-	if response.Error != "" {
-		return errors.New(response.Error)
-	}
-	if response.Result.Message != "success" {
-		return errors.New("succeed op was unsuccessful")
+	if response.Message != "success" {
+		return errors.New("release op was unsuccessful")
 	}
 	return nil
 }
 
-type failResponse struct {
-	rpcResponse
-	Result struct {
-		Message string `json:"message"`
-	} `json:"result"`
-}
-
 // Fail marks a task as failed.
 func (w *Worker) Fail(id, claimID uuid.UUID, reason string) error {
-	request := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"method":  "Worker.Fail",
-		"id":      "1",
-		"params": []map[string]interface{}{
-			{
-				"id":      id,
-				"claimID": claimID,
-				"reason":  reason,
-			},
-		},
-	}
-	responseBody, err := w.makeRequest(request)
-	if err != nil {
-		return err
-	}
-	fmt.Println(string(responseBody.([]byte)))
-	var response failResponse
-	err = json.Unmarshal(responseBody.([]byte), &response)
+	return w.FailContext(context.Background(), id, claimID, reason)
+}
+
+// FailContext marks a task as failed.
+func (w *Worker) FailContext(ctx context.Context, id, claimID uuid.UUID, reason string) error {
+	var response messageResult
+	err := w.call(ctx, "Worker.Fail", map[string]interface{}{
+		"id":      id,
+		"claimID": claimID,
+		"reason":  reason,
+	}, &response)
 	if err != nil {
 		return err
 	}
-	if response.Error != "" {
-		return errors.New(response.Error)
-	}
-	if response.Result.Message != "success" {
+	if response.Message != "success" {
 		return errors.New("fail op was unsuccessful")
 	}
 	return nil
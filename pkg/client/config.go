@@ -1,5 +1,13 @@
 package client
 
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+)
+
 // SchedulerOption is used to configure Scheduler.
 type SchedulerOption func(service *Scheduler)
 
@@ -10,6 +18,56 @@ func WithToken(token string) SchedulerOption {
 	}
 }
 
+// WithBearerToken makes Scheduler authenticate with a bearer token, e.g. a signed JWT.
+func WithBearerToken(token string) SchedulerOption {
+	return func(s *Scheduler) {
+		s.bearerToken = token
+	}
+}
+
+// WithHTTPClient makes Scheduler send requests with the client, NewScheduler timeout isn't applied to it.
+func WithHTTPClient(client *http.Client) SchedulerOption {
+	return func(s *Scheduler) {
+		s.httpcli = client
+	}
+}
+
+// WithTLSConfig makes Scheduler use TLS, the address is called with https scheme.
+// Settings of an *http.Transport, set with an HTTP client option before, are kept.
+func WithTLSConfig(config *tls.Config) SchedulerOption {
+	return func(s *Scheduler) {
+		s.setTLSConfig(config)
+	}
+}
+
+// WithClientCertificate makes Scheduler use TLS and present a certificate, if the server asks for it.
+// The certificate is requested on every handshake, e.g. certs.Reloader.ClientCertificate provides a rotated one.
+func WithClientCertificate(certificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) SchedulerOption {
+	return func(s *Scheduler) {
+		s.setClientCertificate(certificate)
+	}
+}
+
+// WithBaseURL makes Scheduler call the API under the base URL instead of the address,
+// e.g. "https://scheduler.example.com".
+func WithBaseURL(baseURL string) SchedulerOption {
+	return func(s *Scheduler) {
+		s.setBaseURL(baseURL)
+	}
+}
+
+// WithRetry makes Scheduler repeat idempotent calls (Set and Get) after transient failures,
+// such as network errors, rate limits or 502, 503, 504 and 429 statuses. Attempts are bounded by MaxAttempts
+// (5, unless it's positive) and a call context, rate limited calls are repeated not earlier, than server asks.
+func WithRetry(policy domain.RetryPolicy) SchedulerOption {
+	return func(s *Scheduler) {
+		if policy.MaxAttempts <= 0 {
+			policy.MaxAttempts = defaultRetryAttempts
+		}
+		s.retry = &policy
+	}
+}
+
 // WorkerOption is used to configure Worker.
 type WorkerOption func(service *Worker)
 
@@ -19,3 +77,137 @@ func WithWorkerToken(token string) WorkerOption {
 		s.accessToken = token
 	}
 }
+
+// WithWorkerBearerToken makes Worker authenticate with a bearer token, e.g. a signed JWT.
+func WithWorkerBearerToken(token string) WorkerOption {
+	return func(s *Worker) {
+		s.bearerToken = token
+	}
+}
+
+// WithWorkerHTTPClient makes Worker send requests with the client, NewWorker timeout isn't applied to it.
+func WithWorkerHTTPClient(client *http.Client) WorkerOption {
+	return func(s *Worker) {
+		s.httpcli = client
+	}
+}
+
+// WithWorkerTLSConfig makes Worker use TLS, the address is called with https scheme.
+// Settings of an *http.Transport, set with an HTTP client option before, are kept.
+func WithWorkerTLSConfig(config *tls.Config) WorkerOption {
+	return func(s *Worker) {
+		s.setTLSConfig(config)
+	}
+}
+
+// WithWorkerClientCertificate makes Worker use TLS and authenticate with a certificate (mTLS).
+// The certificate is requested on every handshake, e.g. certs.Reloader.ClientCertificate provides a rotated one.
+func WithWorkerClientCertificate(certificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) WorkerOption {
+	return func(s *Worker) {
+		s.setClientCertificate(certificate)
+	}
+}
+
+// WithWorkerBaseURL makes Worker call the API under the base URL instead of the address,
+// e.g. "https://scheduler.example.com".
+func WithWorkerBaseURL(baseURL string) WorkerOption {
+	return func(s *Worker) {
+		s.setBaseURL(baseURL)
+	}
+}
+
+// WithLease configures Worker to claim tasks for the lease duration.
+func WithLease(lease time.Duration) WorkerOption {
+	return func(s *Worker) {
+		s.lease = lease
+	}
+}
+
+// WithClaimWait configures Worker to wait for tasks in Claim up to the duration,
+// if there are no pending ones. Worker timeout should exceed the wait.
+func WithClaimWait(wait time.Duration) WorkerOption {
+	return func(s *Worker) {
+		s.wait = wait
+	}
+}
+
+// TaskOption is used to configure a task in Scheduler.Set.
+type TaskOption func(params map[string]interface{})
+
+// WithQueue puts a task to the named queue.
+func WithQueue(queue string) TaskOption {
+	return func(params map[string]interface{}) {
+		params["queue"] = queue
+	}
+}
+
+// WithPriority sets a task priority, the higher is the more urgent.
+func WithPriority(priority int) TaskOption {
+	return func(params map[string]interface{}) {
+		params["priority"] = priority
+	}
+}
+
+// WithRetryPolicy provides a retry policy of a task.
+func WithRetryPolicy(policy domain.RetryPolicy) TaskOption {
+	return func(params map[string]interface{}) {
+		params["retryPolicy"] = map[string]interface{}{
+			"maxAttempts": policy.MaxAttempts,
+			"baseDelay":   policy.BaseDelay.String(),
+			"multiplier":  policy.Multiplier,
+			"maxDelay":    policy.MaxDelay.String(),
+			"jitter":      policy.Jitter,
+		}
+	}
+}
+
+// WithCallbackURL makes scheduler post a finished task to the URL.
+func WithCallbackURL(callbackURL string) TaskOption {
+	return func(params map[string]interface{}) {
+		params["callbackURL"] = callbackURL
+	}
+}
+
+// RunnerOption is used to configure Runner.
+type RunnerOption func(runner *Runner)
+
+// WithConcurrency limits amount of tasks, that are processed at the same time. Non-positive value is ignored.
+func WithConcurrency(concurrency int) RunnerOption {
+	return func(r *Runner) {
+		if concurrency > 0 {
+			r.concurrency = concurrency
+		}
+	}
+}
+
+// WithPrefetch allows Runner to claim tasks ahead, while others are processed. Negative value is ignored.
+func WithPrefetch(prefetch int) RunnerOption {
+	return func(r *Runner) {
+		if prefetch >= 0 {
+			r.prefetch = prefetch
+		}
+	}
+}
+
+// WithClaimQueues makes Runner claim tasks only from the named queues.
+func WithClaimQueues(queues ...string) RunnerOption {
+	return func(r *Runner) {
+		r.queues = queues
+	}
+}
+
+// WithShutdownTimeout limits how long processing tasks are awaited after Run's context is cancelled.
+func WithShutdownTimeout(timeout time.Duration) RunnerOption {
+	return func(r *Runner) {
+		r.shutdownTimeout = timeout
+	}
+}
+
+// WithPollInterval configures a pause between claims, when there are no pending tasks or claim fails.
+func WithPollInterval(interval time.Duration) RunnerOption {
+	return func(r *Runner) {
+		if interval > 0 {
+			r.pollInterval = interval
+		}
+	}
+}
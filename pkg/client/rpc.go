@@ -0,0 +1,250 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+)
+
+// TransportError means, that a request wasn't sent or a response wasn't received.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("transport error: %v", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError means, that server responded with an unexpected HTTP status, e.g. 401.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// DecodeError means, that a response isn't a valid JSON-RPC response.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("invalid response: %v", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// RPCError is a JSON-RPC error object returned by server.
+// Domain errors are unwrapped to domain.Error, so domain.ErrorCode works with them.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		// Code is a domain error code.
+		Code string `json:"code"`
+		// RetryAfter is seconds to wait before repeating a rate limited call.
+		RetryAfter float64 `json:"retryAfter"`
+	} `json:"data"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// RetryAfter returns how long to wait before repeating a rate limited call.
+func (e *RPCError) RetryAfter() time.Duration {
+	return time.Duration(e.Data.RetryAfter * float64(time.Second))
+}
+
+// Unwrap returns domain.Error, if server reported one.
+func (e *RPCError) Unwrap() error {
+	if e.Data.Code == "" {
+		return nil
+	}
+	return domain.Error{Code: e.Data.Code, Message: e.Message}
+}
+
+type rpcResponse struct {
+	// ID is current response ID.
+	// Should be equals request ID.
+	ID json.RawMessage `json:"id"`
+	// Result is set, when a call succeeded.
+	Result json.RawMessage `json:"result"`
+	// Error is set, when a call failed.
+	Error *RPCError `json:"error"`
+}
+
+// rpcClient calls procedures of a JSON-RPC endpoint.
+type rpcClient struct {
+	// path is an endpoint path, e.g. "/rpc/v0".
+	path        string
+	url         string
+	accessToken string
+	bearerToken string
+	httpcli     *http.Client
+	// retry is used for idempotent calls, they are made once without it.
+	retry *domain.RetryPolicy
+}
+
+func newRPCClient(address, path string, timeout time.Duration) rpcClient {
+	return rpcClient{
+		path: path,
+		url:  fmt.Sprintf("http://%s%s", address, path),
+		httpcli: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// setBaseURL makes the client call the endpoint under a base URL.
+func (c *rpcClient) setBaseURL(baseURL string) {
+	c.url = strings.TrimSuffix(baseURL, "/") + c.path
+}
+
+// setTLSConfig makes the client use TLS, the default http scheme is switched to https.
+// A configured *http.Transport is cloned, otherwise the default one is used.
+func (c *rpcClient) setTLSConfig(config *tls.Config) {
+	base, ok := c.httpcli.Transport.(*http.Transport)
+	if !ok {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	transport := base.Clone()
+	transport.TLSClientConfig = config
+	httpcli := *c.httpcli
+	httpcli.Transport = transport
+	c.httpcli = &httpcli
+	if strings.HasPrefix(c.url, "http://") {
+		c.url = "https://" + strings.TrimPrefix(c.url, "http://")
+	}
+}
+
+// setClientCertificate makes the client present a certificate over TLS, configured TLS settings are kept.
+func (c *rpcClient) setClientCertificate(certificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) {
+	config := &tls.Config{}
+	if transport, ok := c.httpcli.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+		config = transport.TLSClientConfig.Clone()
+	}
+	config.GetClientCertificate = certificate
+	c.setTLSConfig(config)
+}
+
+// transient reports whether a failed call may succeed, if it's repeated.
+func transient(err error) bool {
+	var transportErr *TransportError
+	if errors.As(err, &transportErr) {
+		return true
+	}
+	if domain.ErrorCode(err) == domain.ErrRateLimited {
+		return true
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
+// defaultRetryAttempts bounds retries of a policy with unlimited attempts,
+// so a call without a deadline isn't repeated forever.
+const defaultRetryAttempts = 5
+
+// retryCall repeats an idempotent call after transient failures according to the retry policy.
+// Returns the amount of attempts made.
+func (c *rpcClient) retryCall(ctx context.Context, method string, params map[string]interface{}, result interface{}) (int, error) {
+	for attempts := 1; ; attempts++ {
+		err := c.call(ctx, method, params, result)
+		if err == nil || c.retry == nil || !transient(err) || c.retry.Exhausted(attempts) || ctx.Err() != nil {
+			return attempts, err
+		}
+		delay := c.retry.Backoff(attempts)
+		var rpcErr *RPCError
+		if errors.As(err, &rpcErr) && rpcErr.RetryAfter() > delay {
+			delay = rpcErr.RetryAfter()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, err
+		case <-timer.C:
+		}
+	}
+}
+
+// call invokes a procedure with named params and decodes its result.
+func (c *rpcClient) call(ctx context.Context, method string, params map[string]interface{}, result interface{}) error {
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"id":      "1",
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if c.accessToken != "" {
+		request.Header.Set("Auth", c.accessToken)
+	}
+	if c.bearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	}
+	resp, err := c.httpcli.Do(request)
+	if err != nil {
+		return &TransportError{Err: err}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &TransportError{Err: err}
+	}
+	var response rpcResponse
+	if resp.StatusCode != http.StatusOK {
+		// Rate limited call is answered with 429 status and an error object.
+		if resp.StatusCode == http.StatusTooManyRequests && json.Unmarshal(body, &response) == nil && response.Error != nil {
+			return response.Error
+		}
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return &DecodeError{Err: err}
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil {
+		return nil
+	}
+	err = json.Unmarshal(response.Result, result)
+	if err != nil {
+		return &DecodeError{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/google/uuid"
+)
+
+func TestSetTLSConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport http.RoundTripper
+		expected  int
+	}{
+		{
+			name:     "default transport",
+			expected: http.DefaultTransport.(*http.Transport).MaxIdleConns,
+		},
+		{
+			name:      "custom transport",
+			transport: &http.Transport{MaxIdleConns: 7},
+			expected:  7,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newRPCClient("localhost:8000", "/rpc/v0", time.Second)
+			client.httpcli.Transport = tc.transport
+			config := &tls.Config{ServerName: "scheduler"}
+			client.setTLSConfig(config)
+			transport, ok := client.httpcli.Transport.(*http.Transport)
+			if !ok {
+				t.Fatalf("Expected *http.Transport, got: `%T`", client.httpcli.Transport)
+			}
+			if transport.MaxIdleConns != tc.expected {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expected, transport.MaxIdleConns)
+			}
+			if transport.TLSClientConfig != config {
+				t.Errorf("Expected `%v`, got: `%v`", config, transport.TLSClientConfig)
+			}
+			if transport == tc.transport || transport == http.DefaultTransport {
+				t.Errorf("Expected a cloned transport, got: `%p`", transport)
+			}
+			if client.url != "https://localhost:8000/rpc/v0" {
+				t.Errorf("Expected `%v`, got: `%v`", "https://localhost:8000/rpc/v0", client.url)
+			}
+		})
+	}
+}
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name               string
+		status             int
+		body               string
+		closed             bool
+		expectedType       string
+		expectedCode       string
+		expectedRetryAfter time.Duration
+	}{
+		{
+			name:   "result",
+			status: http.StatusOK,
+			body:   `{"jsonrpc": "2.0", "id": "1", "result": {"id": "bd954d5e-2b11-49a8-be81-2a53e25a9dc3"}}`,
+		},
+		{
+			name:         "domain error",
+			status:       http.StatusOK,
+			body:         `{"jsonrpc": "2.0", "id": "1", "error": {"code": -32001, "message": "task not found", "data": {"code": "task_not_found"}}}`,
+			expectedType: "*client.RPCError",
+			expectedCode: domain.ErrTaskNotFound,
+		},
+		{
+			name:         "protocol error",
+			status:       http.StatusOK,
+			body:         `{"jsonrpc": "2.0", "id": "1", "error": {"code": -32601, "message": "method not found"}}`,
+			expectedType: "*client.RPCError",
+		},
+		{
+			name:         "unauthorized",
+			status:       http.StatusUnauthorized,
+			body:         "401 - not authorized",
+			expectedType: "*client.StatusError",
+		},
+		{
+			name:         "not json",
+			status:       http.StatusOK,
+			body:         "<html></html>",
+			expectedType: "*client.DecodeError",
+		},
+		{
+			name:               "rate limited",
+			status:             http.StatusTooManyRequests,
+			body:               `{"jsonrpc": "2.0", "id": "1", "error": {"code": -32009, "message": "rate limited", "data": {"code": "rate_limited", "retryAfter": 1.5}}}`,
+			expectedType:       "*client.RPCError",
+			expectedCode:       domain.ErrRateLimited,
+			expectedRetryAfter: 1500 * time.Millisecond,
+		},
+		{
+			name:         "rate limited without error object",
+			status:       http.StatusTooManyRequests,
+			body:         "too many requests",
+			expectedType: "*client.StatusError",
+		},
+		{
+			name:         "connection refused",
+			closed:       true,
+			expectedType: "*client.TransportError",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			client := newRPCClient("", "/rpc/v0", time.Second)
+			client.setBaseURL(server.URL)
+			if tc.closed {
+				server.Close()
+			}
+			var result map[string]interface{}
+			err := client.call(context.Background(), "Scheduler.Get", map[string]interface{}{}, &result)
+			if tc.expectedType == "" {
+				if err != nil || result["id"] != "bd954d5e-2b11-49a8-be81-2a53e25a9dc3" {
+					t.Errorf("Expected result, got: `%v`, `%v`", result, err)
+				}
+				return
+			}
+			if observed := fmt.Sprintf("%T", err); observed != tc.expectedType {
+				t.Fatalf("Expected `%v`, got: `%v` (%v)", tc.expectedType, observed, err)
+			}
+			if observed := domain.ErrorCode(err); observed != tc.expectedCode {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expectedCode, observed)
+			}
+			var rpcErr *RPCError
+			if errors.As(err, &rpcErr) && rpcErr.RetryAfter() != tc.expectedRetryAfter {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expectedRetryAfter, rpcErr.RetryAfter())
+			}
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) && (statusErr.StatusCode != tc.status || statusErr.Body != tc.body) {
+				t.Errorf("Expected `%v` status, got: `%v`", tc.status, statusErr)
+			}
+		})
+	}
+}
+
+func TestRPCErrorUnwrap(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected error
+	}{
+		{name: "domain error", code: domain.ErrDuplicateTask, expected: domain.Error{Code: domain.ErrDuplicateTask, Message: "duplicate"}},
+		{name: "protocol error"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rpcErr := &RPCError{Code: -32000, Message: "duplicate"}
+			rpcErr.Data.Code = tc.code
+			if observed := rpcErr.Unwrap(); observed != tc.expected {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expected, observed)
+			}
+		})
+	}
+}
+
+// rpcReply is a response of a test server to the call.
+type rpcReply struct {
+	status int
+	body   string
+}
+
+var (
+	unavailable = rpcReply{status: http.StatusServiceUnavailable, body: "503 - unavailable"}
+	resulted    = rpcReply{status: http.StatusOK, body: `{"jsonrpc": "2.0", "id": "1", "result": {}}`}
+	duplicated  = rpcReply{status: http.StatusOK, body: `{"jsonrpc": "2.0", "id": "1", "error": {"code": -32002, "message": "duplicate task", "data": {"code": "duplicate_task"}}}`}
+)
+
+// replyServer answers calls with the replies in order, the last one is repeated.
+// Returns the server and the amount of received calls.
+func replyServer(t *testing.T, replies ...rpcReply) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(atomic.AddInt32(&calls, 1))
+		if call > len(replies) {
+			call = len(replies)
+		}
+		w.WriteHeader(replies[call-1].status)
+		w.Write([]byte(replies[call-1].body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestRetryCall(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             []SchedulerOption
+		replies          []rpcReply
+		expectedAttempts int
+		expectedErr      bool
+	}{
+		{
+			name:             "without retry",
+			replies:          []rpcReply{unavailable, resulted},
+			expectedAttempts: 1,
+			expectedErr:      true,
+		},
+		{
+			name:             "transient failures",
+			opts:             []SchedulerOption{WithRetry(domain.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})},
+			replies:          []rpcReply{unavailable, unavailable, resulted},
+			expectedAttempts: 3,
+		},
+		{
+			name:             "exhausted",
+			opts:             []SchedulerOption{WithRetry(domain.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})},
+			replies:          []rpcReply{unavailable},
+			expectedAttempts: 2,
+			expectedErr:      true,
+		},
+		{
+			name:             "unlimited attempts",
+			opts:             []SchedulerOption{WithRetry(domain.RetryPolicy{BaseDelay: time.Millisecond})},
+			replies:          []rpcReply{unavailable},
+			expectedAttempts: defaultRetryAttempts,
+			expectedErr:      true,
+		},
+		{
+			name:             "not transient",
+			opts:             []SchedulerOption{WithRetry(domain.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})},
+			replies:          []rpcReply{{status: http.StatusUnauthorized, body: "401 - not authorized"}, resulted},
+			expectedAttempts: 1,
+			expectedErr:      true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server, calls := replyServer(t, tc.replies...)
+			scheduler := NewScheduler("", time.Second, append(tc.opts, WithBaseURL(server.URL))...)
+			attempts, err := scheduler.retryCall(context.Background(), "Scheduler.Get", map[string]interface{}{}, nil)
+			if (err != nil) != tc.expectedErr {
+				t.Errorf("Expected error: `%v`, got: `%v`", tc.expectedErr, err)
+			}
+			if attempts != tc.expectedAttempts || int(atomic.LoadInt32(calls)) != tc.expectedAttempts {
+				t.Errorf("Expected `%v`, got: `%v` attempts, `%v` calls", tc.expectedAttempts, attempts, atomic.LoadInt32(calls))
+			}
+		})
+	}
+}
+
+func TestRetryCallRetryAfter(t *testing.T) {
+	server, _ := replyServer(t,
+		rpcReply{
+			status: http.StatusTooManyRequests,
+			body:   `{"jsonrpc": "2.0", "id": "1", "error": {"code": -32009, "message": "rate limited", "data": {"code": "rate_limited", "retryAfter": 0.2}}}`,
+		},
+		resulted,
+	)
+	scheduler := NewScheduler("", time.Second,
+		WithBaseURL(server.URL),
+		WithRetry(domain.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}),
+	)
+	started := time.Now()
+	attempts, err := scheduler.retryCall(context.Background(), "Scheduler.Get", map[string]interface{}{}, nil)
+	if attempts != 2 || err != nil {
+		t.Fatalf("Expected `%v` attempts, got: `%v`, `%v`", 2, attempts, err)
+	}
+	if waited := time.Since(started); waited < 200*time.Millisecond {
+		t.Errorf("Expected the call to be repeated after `%v`, got: `%v`", 200*time.Millisecond, waited)
+	}
+}
+
+func TestSetContextDuplicate(t *testing.T) {
+	tests := []struct {
+		name         string
+		replies      []rpcReply
+		expectedCode string
+	}{
+		{name: "set by a lost attempt", replies: []rpcReply{unavailable, duplicated}},
+		{name: "set before", replies: []rpcReply{duplicated}, expectedCode: domain.ErrDuplicateTask},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _ := replyServer(t, tc.replies...)
+			scheduler := NewScheduler("", time.Second,
+				WithBaseURL(server.URL),
+				WithRetry(domain.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}),
+			)
+			id, err := scheduler.SetContext(context.Background(), time.Now(), time.Now().Add(time.Hour), map[string]interface{}{})
+			if observed := domain.ErrorCode(err); observed != tc.expectedCode {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expectedCode, err)
+			}
+			if id == nil || *id == uuid.Nil {
+				t.Errorf("Expected task ID, got: `%v`", id)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	log "github.com/freundallein/scheduler/pkg/utils/logging"
+)
+
+const (
+	// maxClaimAmount is the biggest amount of tasks, that API gives in one claim.
+	maxClaimAmount = 99
+	// assumedLease is used until the first heartbeat, if Worker has no lease configured.
+	// It's the minimal lease, that server accepts by default.
+	assumedLease = 10 * time.Second
+	// minRenewal limits heartbeat frequency, when they fail.
+	minRenewal = 100 * time.Millisecond
+	// releaseTimeout limits giving back a task, that won't be processed.
+	releaseTimeout = 5 * time.Second
+)
+
+// Handler processes a task, returned result succeeds the task and an error fails it.
+// Context is cancelled, when the task's lease is lost or shutdown timeout expires.
+type Handler func(ctx context.Context, task *domain.Task) (map[string]interface{}, error)
+
+// Runner claims tasks with Worker and dispatches them to handlers by payload "type".
+// Leases of claimed tasks are renewed until they are processed.
+type Runner struct {
+	worker          *Worker
+	handlers        map[string]Handler
+	queues          []string
+	concurrency     int
+	prefetch        int
+	shutdownTimeout time.Duration
+	pollInterval    time.Duration
+}
+
+// claimedTask is a task, which lease is renewed by Runner.
+type claimedTask struct {
+	*domain.Task
+	// lost is closed, when the lease can't be renewed.
+	lost chan struct{}
+	// stop stops the lease renewal.
+	stop context.CancelFunc
+}
+
+// NewRunner returns an instance of Runner.
+func NewRunner(worker *Worker, opts ...RunnerOption) *Runner {
+	runner := &Runner{
+		worker:          worker,
+		handlers:        map[string]Handler{},
+		concurrency:     1,
+		shutdownTimeout: 30 * time.Second,
+		pollInterval:    time.Second,
+	}
+	for _, opt := range opts {
+		opt(runner)
+	}
+	return runner
+}
+
+// Handle registers a handler for tasks with the payload type, tasks of unknown types are failed.
+// Handlers should be registered before Run.
+func (r *Runner) Handle(taskType string, handler Handler) {
+	r.handlers[taskType] = handler
+}
+
+// Run processes tasks until the context is cancelled.
+// Then processing tasks have shutdown timeout to finish and prefetched tasks are released,
+// so another worker claims them at once.
+func (r *Runner) Run(ctx context.Context) error {
+	// Every claimed task holds a slot until it's processed.
+	slots := make(chan struct{}, r.concurrency+r.prefetch)
+	tasks := make(chan *claimedTask, r.concurrency+r.prefetch)
+	// Processing isn't interrupted by the shutdown right away.
+	processCtx, stopProcessing := context.WithCancel(context.Background())
+	defer stopProcessing()
+	var wg sync.WaitGroup
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case task := <-tasks:
+					if ctx.Err() != nil {
+						r.release(task)
+						<-slots
+						return
+					}
+					r.process(processCtx, task)
+					<-slots
+				}
+			}
+		}()
+	}
+	r.claim(ctx, slots, tasks)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(r.shutdownTimeout)
+	select {
+	case <-done:
+		timer.Stop()
+	case <-timer.C:
+		stopProcessing()
+		<-done
+	}
+	for {
+		select {
+		case task := <-tasks:
+			r.release(task)
+		default:
+			return ctx.Err()
+		}
+	}
+}
+
+// claim fills free slots with claimed tasks until the context is cancelled.
+func (r *Runner) claim(ctx context.Context, slots chan struct{}, tasks chan<- *claimedTask) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+		amount := 1
+	reserve:
+		for amount < maxClaimAmount {
+			select {
+			case slots <- struct{}{}:
+				amount++
+			default:
+				break reserve
+			}
+		}
+		claimed, err := r.worker.ClaimContext(ctx, amount, r.queues...)
+		for i := len(claimed); i < amount; i++ {
+			<-slots
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			noTasks := domain.ErrorCode(err) == domain.ErrNoPendingTasks
+			if !noTasks {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("runner_claim_failed")
+			}
+			// Claim has already waited for tasks.
+			if noTasks && r.worker.wait > 0 {
+				continue
+			}
+			timer := time.NewTimer(r.pollInterval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			continue
+		}
+		for _, task := range claimed {
+			renewCtx, stop := context.WithCancel(context.Background())
+			claimedTask := &claimedTask{
+				Task: task,
+				lost: make(chan struct{}),
+				stop: stop,
+			}
+			go r.renew(renewCtx, claimedTask)
+			tasks <- claimedTask
+		}
+	}
+}
+
+// renew prolongs the task's lease at the half of its remaining time until renewal is stopped.
+func (r *Runner) renew(ctx context.Context, task *claimedTask) {
+	lease := r.worker.lease
+	if lease <= 0 {
+		lease = assumedLease
+	}
+	leasedUntil := time.Now().Add(lease)
+	for {
+		remaining := time.Until(leasedUntil)
+		if remaining <= 0 {
+			r.loseLease(task, fmt.Errorf("lease expired"))
+			return
+		}
+		wait := remaining / 2
+		if wait < minRenewal {
+			wait = minRenewal
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		next, err := r.worker.HeartbeatContext(ctx, task.ID, *task.ClaimID, r.worker.lease)
+		if err == nil {
+			leasedUntil = next
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		switch domain.ErrorCode(err) {
+		case domain.ErrStaleResult, domain.ErrTaskCancelled, domain.ErrDeadlineExceeded,
+			domain.ErrTaskNotFound, domain.ErrTaskFinished:
+			r.loseLease(task, err)
+			return
+		}
+		log.WithFields(log.Fields{
+			"id":  task.ID,
+			"err": err,
+		}).Error("runner_heartbeat_failed")
+	}
+}
+
+// loseLease interrupts the task processing, its result won't be accepted.
+func (r *Runner) loseLease(task *claimedTask, err error) {
+	log.WithFields(log.Fields{
+		"id":  task.ID,
+		"err": err,
+	}).Warn("runner_lease_lost")
+	close(task.lost)
+}
+
+// release stops renewal of a task, that won't be processed, and gives it back to the server.
+// If it fails, the task is claimed by another worker, when its lease expires.
+func (r *Runner) release(task *claimedTask) {
+	task.stop()
+	// Tasks are released after the shutdown, so they have their own timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	err := r.worker.ReleaseContext(ctx, task.ID, *task.ClaimID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":  task.ID,
+			"err": err,
+		}).Error("runner_release_failed")
+		return
+	}
+	log.WithFields(log.Fields{
+		"id": task.ID,
+	}).Debug("runner_task_released")
+}
+
+// process executes a handler and reports its outcome.
+func (r *Runner) process(ctx context.Context, task *claimedTask) {
+	defer task.stop()
+	handlerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-task.lost:
+			cancel()
+		case <-handlerCtx.Done():
+		}
+	}()
+	result, err := r.handle(handlerCtx, task.Task)
+	select {
+	case <-task.lost:
+		// Another worker may have the task already.
+		return
+	default:
+	}
+	// Outcome is reported even after shutdown timeout.
+	reportCtx := context.Background()
+	if err != nil {
+		err = r.worker.FailContext(reportCtx, task.ID, *task.ClaimID, err.Error())
+	} else {
+		err = r.worker.SucceedContext(reportCtx, task.ID, *task.ClaimID, result)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":  task.ID,
+			"err": err,
+		}).Error("runner_report_failed")
+	}
+}
+
+// handle dispatches a task to its handler, a panic fails the task.
+func (r *Runner) handle(ctx context.Context, task *domain.Task) (result map[string]interface{}, err error) {
+	taskType, _ := task.Payload["type"].(string)
+	handler, ok := r.handlers[taskType]
+	if !ok {
+		return nil, fmt.Errorf("no handler for task type %q", taskType)
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.WithFields(log.Fields{
+				"id":    task.ID,
+				"panic": recovered,
+			}).Error("runner_handler_panicked")
+			err = fmt.Errorf("handler panicked: %v", recovered)
+		}
+	}()
+	return handler(ctx, task)
+}
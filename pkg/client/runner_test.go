@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/google/uuid"
+)
+
+// fakeWorker serves Worker API procedures, that Runner calls.
+type fakeWorker struct {
+	mu      sync.Mutex
+	pending []*domain.Task
+	// heartbeat returns an error for a task's heartbeat, if it's set.
+	heartbeat func(id uuid.UUID) error
+	succeeded map[uuid.UUID]map[string]interface{}
+	failed    map[uuid.UUID]string
+	released  map[uuid.UUID]bool
+	// reported receives ids of succeeded and failed tasks.
+	reported chan uuid.UUID
+}
+
+func newFakeWorker(tasks ...*domain.Task) *fakeWorker {
+	return &fakeWorker{
+		pending:   tasks,
+		succeeded: map[uuid.UUID]map[string]interface{}{},
+		failed:    map[uuid.UUID]string{},
+		released:  map[uuid.UUID]bool{},
+		reported:  make(chan uuid.UUID, len(tasks)),
+	}
+}
+
+type fakeParams struct {
+	ID     uuid.UUID              `json:"id"`
+	Amount string                 `json:"amount"`
+	Result map[string]interface{} `json:"result"`
+	Reason string                 `json:"reason"`
+}
+
+func (f *fakeWorker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Method string     `json:"method"`
+		Params fakeParams `json:"params"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": "1"}
+	result, err := f.call(request.Method, request.Params)
+	if err != nil {
+		response["error"] = map[string]interface{}{
+			"code":    -32000,
+			"message": err.Error(),
+			"data":    map[string]interface{}{"code": domain.ErrorCode(err)},
+		}
+	} else {
+		response["result"] = result
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (f *fakeWorker) call(method string, params fakeParams) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch method {
+	case "Worker.Claim":
+		amount, _ := strconv.Atoi(params.Amount)
+		if amount > len(f.pending) {
+			amount = len(f.pending)
+		}
+		if amount == 0 {
+			return nil, domain.Error{Code: domain.ErrNoPendingTasks, Message: "no pending tasks"}
+		}
+		tasks := f.pending[:amount]
+		f.pending = f.pending[amount:]
+		for _, task := range tasks {
+			claimID := uuid.New()
+			task.ClaimID = &claimID
+		}
+		return map[string]interface{}{"tasks": tasks, "count": len(tasks)}, nil
+	case "Worker.Heartbeat":
+		if f.heartbeat != nil {
+			if err := f.heartbeat(params.ID); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{"leasedUntil": time.Now().Add(time.Minute)}, nil
+	case "Worker.Succeed":
+		f.succeeded[params.ID] = params.Result
+		f.reported <- params.ID
+	case "Worker.Fail":
+		f.failed[params.ID] = params.Reason
+		f.reported <- params.ID
+	case "Worker.Release":
+		f.released[params.ID] = true
+	default:
+		return nil, domain.Error{Code: "method_not_found", Message: method}
+	}
+	return map[string]interface{}{"message": "success"}, nil
+}
+
+func newTestTask(taskType string) *domain.Task {
+	return &domain.Task{
+		ID:      uuid.New(),
+		Payload: map[string]interface{}{"type": taskType},
+	}
+}
+
+// startRunner runs the runner until the returned function is called, which returns Run's error.
+func startRunner(runner *Runner) func() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- runner.Run(ctx)
+	}()
+	return func() error {
+		cancel()
+		return <-done
+	}
+}
+
+func waitReported(t *testing.T, fake *fakeWorker, amount int) {
+	t.Helper()
+	for i := 0; i < amount; i++ {
+		select {
+		case <-fake.reported:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected `%v` reported tasks, got: `%v`", amount, i)
+		}
+	}
+}
+
+func TestRunnerDispatch(t *testing.T) {
+	succeeding := newTestTask("succeeding")
+	failing := newTestTask("failing")
+	unknown := newTestTask("unknown")
+	panicking := newTestTask("panicking")
+	fake := newFakeWorker(succeeding, failing, unknown, panicking)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	runner := NewRunner(
+		NewWorker("", time.Second, WithWorkerBaseURL(server.URL)),
+		WithConcurrency(2),
+		WithPollInterval(10*time.Millisecond),
+	)
+	runner.Handle("succeeding", func(ctx context.Context, task *domain.Task) (map[string]interface{}, error) {
+		return map[string]interface{}{"handled": task.Payload["type"]}, nil
+	})
+	runner.Handle("failing", func(ctx context.Context, task *domain.Task) (map[string]interface{}, error) {
+		return nil, errors.New("handler failed")
+	})
+	runner.Handle("panicking", func(ctx context.Context, task *domain.Task) (map[string]interface{}, error) {
+		panic("boom")
+	})
+	stop := startRunner(runner)
+	waitReported(t, fake, 4)
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected `%v`, got: `%v`", context.Canceled, err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if result := fake.succeeded[succeeding.ID]; result["handled"] != "succeeding" {
+		t.Errorf("Expected `%v`, got: `%v`", "succeeding", result)
+	}
+	tests := []struct {
+		task   *domain.Task
+		reason string
+	}{
+		{task: failing, reason: "handler failed"},
+		{task: unknown, reason: `no handler for task type "unknown"`},
+		{task: panicking, reason: "handler panicked: boom"},
+	}
+	for _, tc := range tests {
+		reason, ok := fake.failed[tc.task.ID]
+		if !ok || !strings.HasPrefix(reason, tc.reason) {
+			t.Errorf("Expected `%v`, got: `%v`", tc.reason, reason)
+		}
+	}
+}
+
+func TestRunnerLostLease(t *testing.T) {
+	task := newTestTask("blocking")
+	fake := newFakeWorker(task)
+	fake.heartbeat = func(id uuid.UUID) error {
+		return domain.Error{Code: domain.ErrTaskCancelled, Message: "task was cancelled"}
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	runner := NewRunner(
+		NewWorker("", time.Second, WithWorkerBaseURL(server.URL), WithLease(200*time.Millisecond)),
+		WithPollInterval(10*time.Millisecond),
+	)
+	interrupted := make(chan error, 1)
+	runner.Handle("blocking", func(ctx context.Context, task *domain.Task) (map[string]interface{}, error) {
+		<-ctx.Done()
+		interrupted <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	stop := startRunner(runner)
+	select {
+	case err := <-interrupted:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected `%v`, got: `%v`", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected handler context to be cancelled")
+	}
+	stop()
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.succeeded) != 0 || len(fake.failed) != 0 {
+		t.Errorf("Expected no outcome of a lost task, got: `%v`, `%v`", fake.succeeded, fake.failed)
+	}
+}
+
+func TestRunnerShutdown(t *testing.T) {
+	processing := newTestTask("blocking")
+	prefetched := []*domain.Task{newTestTask("blocking"), newTestTask("blocking")}
+	fake := newFakeWorker(append([]*domain.Task{processing}, prefetched...)...)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	runner := NewRunner(
+		NewWorker("", time.Second, WithWorkerBaseURL(server.URL)),
+		WithPrefetch(2),
+		WithPollInterval(10*time.Millisecond),
+	)
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	runner.Handle("blocking", func(ctx context.Context, task *domain.Task) (map[string]interface{}, error) {
+		close(started)
+		<-finish
+		return map[string]interface{}{}, nil
+	})
+	stop := startRunner(runner)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected processing to start")
+	}
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- stop()
+	}()
+	// Processing task finishes within shutdown timeout.
+	time.Sleep(50 * time.Millisecond)
+	close(finish)
+	if err := <-stopped; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected `%v`, got: `%v`", context.Canceled, err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if _, ok := fake.succeeded[processing.ID]; !ok || fake.released[processing.ID] {
+		t.Errorf("Expected `%v` succeeded, got: `%v`", processing.ID, fake.succeeded)
+	}
+	for _, task := range prefetched {
+		if !fake.released[task.ID] {
+			t.Errorf("Expected `%v` released, got: `%v`", task.ID, fake.released)
+		}
+	}
+}
@@ -20,8 +20,30 @@ const (
 	StateSucceeded State = "succeeded"
 	// StateFailed means, that we got failure during processing.
 	StateFailed State = "failed"
+	// StateExhausted means, that task has failed and has no attempts left.
+	StateExhausted State = "exhausted"
+	// StateExpired means, that task wasn't processed before its deadline.
+	StateExpired State = "expired"
+	// StateCancelled means, that task was withdrawn by a client.
+	StateCancelled State = "cancelled"
 )
 
+// Finished reports whether a task in this state won't be processed anymore.
+func (s State) Finished() bool {
+	switch s {
+	case StateSucceeded, StateExhausted, StateExpired, StateCancelled:
+		return true
+	}
+	return false
+}
+
+// TaskEvent describes a task state change.
+type TaskEvent struct {
+	ID    uuid.UUID `json:"id"`
+	Queue string    `json:"queue"`
+	State State     `json:"state"`
+}
+
 // Task describes a work unit.
 type Task struct {
 	// ID is a task identifier.
@@ -34,8 +56,18 @@ type Task struct {
 	ExecuteAt time.Time `json:"executeAt"`
 	// Deadline  allows scheduler to define when task becomes stale.
 	Deadline time.Time `json:"deadline"`
+	// Queue is a name of the queue, that task belongs to.
+	Queue string `json:"queue"`
+	// Priority allows to claim urgent tasks first, the higher is the more urgent.
+	Priority int `json:"priority"`
 	// Payload describes the task itself.
 	Payload map[string]interface{} `json:"payload"`
+	// RetryPolicy describes how failed task should be retried.
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+	// CancelRequested shows, that client has cancelled a task during its processing.
+	CancelRequested bool `json:"cancelRequested,omitempty"`
+	// CallbackURL receives the finished task with a webhook.
+	CallbackURL string `json:"callbackURL,omitempty"`
 	// Result shows the result of a task processing.
 	Result map[string]interface{} `json:"result,omitempty"`
 	// Meta used for service information.
@@ -46,43 +78,374 @@ type Task struct {
 	DoneAt sql.NullTime `json:"doneAt,omitempty"`
 }
 
+// TaskOrder describes a field, that tasks are sorted by.
+type TaskOrder string
+
+const (
+	// OrderByCreatedAt sorts tasks by creation time.
+	OrderByCreatedAt TaskOrder = "createdAt"
+	// OrderByExecuteAt sorts tasks by execution time.
+	OrderByExecuteAt TaskOrder = "executeAt"
+	// OrderByDoneAt sorts finished tasks by finish time.
+	OrderByDoneAt TaskOrder = "doneAt"
+)
+
+// TaskFilter describes which tasks are listed, zero values don't filter.
+// Time ranges include the lower bound and exclude the upper one.
+type TaskFilter struct {
+	States      []State
+	Queues      []string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	ExecuteFrom time.Time
+	ExecuteTo   time.Time
+	DoneFrom    time.Time
+	DoneTo      time.Time
+	MinAttempts *int
+	MaxAttempts *int
+}
+
+// TaskKey is a sort key of a task, that is used for keyset pagination.
+type TaskKey struct {
+	At time.Time `json:"at"`
+	ID uuid.UUID `json:"id"`
+}
+
+// TaskQuery describes a page of tasks.
+type TaskQuery struct {
+	Filter  TaskFilter
+	OrderBy TaskOrder
+	Desc    bool
+	Limit   int
+	// After is a key of the previous page's last task, nil means the first page.
+	After *TaskKey
+}
+
+// ListTasksRequest describes a page of tasks with an opaque cursor.
+type ListTasksRequest struct {
+	Filter  TaskFilter
+	OrderBy TaskOrder
+	Desc    bool
+	Limit   int
+	// Cursor is a previous page's NextCursor, empty means the first page.
+	Cursor string
+}
+
+// TaskPage is a page of listed tasks.
+type TaskPage struct {
+	Tasks []*Task `json:"tasks"`
+	// NextCursor points to the next page, empty means the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// BatchResult describes an outcome of a batch operation item.
+type BatchResult struct {
+	// ID is an item identifier.
+	ID uuid.UUID
+	// Err is nil, when item was processed successfully.
+	Err error
+}
+
+// Outcome describes a worker's report on a claimed task.
+type Outcome struct {
+	// ID is a task identifier.
+	ID uuid.UUID
+	// ClaimID is a claim identifier, that task was given with.
+	ClaimID uuid.UUID
+	// Result is a result of a succeeded task.
+	Result map[string]interface{}
+	// Reason is a failure reason of a failed task.
+	Reason string
+}
+
+// WebhookState describes webhook delivery states.
+type WebhookState string
+
+const (
+	// WebhookPending means, that webhook is waiting for delivery.
+	WebhookPending WebhookState = "pending"
+	// WebhookDelivered means, that webhook was accepted by a receiver.
+	WebhookDelivered WebhookState = "delivered"
+	// WebhookFailed means, that webhook has no delivery attempts left.
+	WebhookFailed WebhookState = "failed"
+)
+
+// Webhook describes a delivery of a finished task to its callback URL.
+type Webhook struct {
+	// ID is a delivery identifier.
+	ID int64 `json:"id"`
+	// TaskID is a finished task identifier.
+	TaskID uuid.UUID `json:"taskId"`
+	// URL is a task's callback URL.
+	URL string `json:"url"`
+	// Payload is a finished task snapshot.
+	Payload map[string]interface{} `json:"payload"`
+	// State describes current delivery state.
+	State WebhookState `json:"state"`
+	// Attempts is an amount of delivery attempts.
+	Attempts int `json:"attempts"`
+	// LastError is a reason of the last failed attempt.
+	LastError string `json:"lastError,omitempty"`
+	// NextAttemptAt shows when delivery is attempted again.
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	// CreatedAt shows when task was finished.
+	CreatedAt time.Time `json:"createdAt"`
+	// DeliveredAt shows when webhook was delivered.
+	DeliveredAt sql.NullTime `json:"deliveredAt,omitempty"`
+}
+
+// WebhookSender delivers webhooks to receivers.
+type WebhookSender interface {
+	// Send delivers a webhook, error means it should be retried.
+	Send(ctx context.Context, webhook *Webhook) error
+}
+
+// Failure describes a failed attempt of a task.
+type Failure struct {
+	// Attempt is a number of the attempt.
+	Attempt int `json:"attempt"`
+	// Reason is a worker's failure reason.
+	Reason string `json:"reason"`
+	// FailedAt shows when attempt was failed.
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// DeadLetter describes a task, that has exhausted its attempts.
+type DeadLetter struct {
+	// ID is an original task identifier.
+	ID uuid.UUID `json:"id"`
+	// ExecuteAt is an original task execution time.
+	ExecuteAt time.Time `json:"executeAt"`
+	// Deadline is an original task deadline.
+	Deadline time.Time `json:"deadline"`
+	// Queue is an original task queue.
+	Queue string `json:"queue"`
+	// Priority is an original task priority.
+	Priority int `json:"priority"`
+	// Payload is an original task payload.
+	Payload map[string]interface{} `json:"payload"`
+	// RetryPolicy is an original task retry policy.
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+	// FailReason is the last failure reason.
+	FailReason string `json:"failReason"`
+	// Attempts shows how many attempts were made.
+	Attempts int `json:"attempts"`
+	// History describes all failed attempts.
+	History []Failure `json:"history"`
+	// CreatedAt shows when original task was created.
+	CreatedAt time.Time `json:"createdAt"`
+	// DeadAt shows when task was moved to dead letters.
+	DeadAt time.Time `json:"deadAt"`
+}
+
+// MisfirePolicy describes what to do with schedule ticks, that were missed.
+type MisfirePolicy string
+
+const (
+	// MisfireSkip means, that missed ticks are skipped.
+	MisfireSkip MisfirePolicy = "skip"
+	// MisfireOnce means, that only the latest missed tick is enqueued.
+	MisfireOnce MisfirePolicy = "once"
+	// MisfireAll means, that every missed tick is enqueued.
+	MisfireAll MisfirePolicy = "all"
+)
+
+// Schedule describes a recurring task.
+type Schedule struct {
+	// ID is a schedule identifier.
+	ID uuid.UUID `json:"id"`
+	// Cron is a cron expression, e.g. "*/5 * * * *" or "@hourly".
+	Cron string `json:"cron"`
+	// Timezone is an IANA timezone name used for the cron expression.
+	Timezone string `json:"timezone"`
+	// Queue is a queue name of enqueued tasks.
+	Queue string `json:"queue"`
+	// Priority is a priority of enqueued tasks.
+	Priority int `json:"priority"`
+	// Payload is a template of enqueued task payload.
+	Payload map[string]interface{} `json:"payload"`
+	// RetryPolicy is a retry policy of enqueued tasks.
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+	// TTL defines enqueued task deadline after its tick.
+	TTL time.Duration `json:"ttl"`
+	// MisfirePolicy describes what to do with missed ticks.
+	MisfirePolicy MisfirePolicy `json:"misfirePolicy"`
+	// NextRunAt shows the next tick, that isn't enqueued yet.
+	NextRunAt time.Time `json:"nextRunAt"`
+	// LastRunAt shows the latest enqueued tick.
+	LastRunAt sql.NullTime `json:"lastRunAt,omitempty"`
+	// CreatedAt shows when schedule was created.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DefaultQueue is used for tasks without a queue.
+const DefaultQueue = "default"
+
+// MaxPriority bounds task priorities, aging never raises a priority above it.
+const MaxPriority = 1000
+
+// ClaimRequest describes tasks, that worker wants to claim.
+type ClaimRequest struct {
+	// Amount limits amount of claimed tasks.
+	Amount int
+	// Queues limits claiming to the named queues, empty means any queue.
+	Queues []string
+	// Lease defines how long claimed tasks belong to the worker.
+	Lease time.Duration
+	// Wait defines how long to wait for tasks, if there are no pending ones.
+	Wait time.Duration
+	// Aging raises priority of a waiting task by one every Aging after its execution time,
+	// up to MaxPriority, while tasks are ordered. Stored priority isn't changed, zero disables aging.
+	Aging time.Duration
+}
+
 // Scheduler used for task planning and polling.
 type Scheduler interface {
 	// Set allows to enqueue task.
 	Set(ctx context.Context, task *Task) (*Task, error)
+	// SetMany allows to enqueue tasks atomically, duplicates are reported per task.
+	SetMany(ctx context.Context, tasks []*Task) ([]BatchResult, error)
 	// Get allows to poll a task state.
 	Get(ctx context.Context, id uuid.UUID) (*Task, error)
+	// Cancel allows to withdraw a task.
+	Cancel(ctx context.Context, id uuid.UUID) (*Task, error)
+	// Wait blocks until a task is finished or timeout expires, returns the task's last state.
+	Wait(ctx context.Context, id uuid.UUID, timeout time.Duration) (*Task, error)
 }
 
 // Worker used for task processing.
 type Worker interface {
 	// Claim gives a task to worker.
-	Claim(ctx context.Context, amount int) ([]*Task, error)
+	Claim(ctx context.Context, request ClaimRequest) ([]*Task, error)
 	// Succeed marks a task as done.
 	Succeed(ctx context.Context, id, claimID uuid.UUID, result map[string]interface{}) error
 	// Fail marks a task as failed.
 	Fail(ctx context.Context, id, claimID uuid.UUID, reason string) error
+	// SucceedMany marks tasks as done, outcomes are reported per task.
+	SucceedMany(ctx context.Context, outcomes []Outcome) ([]BatchResult, error)
+	// FailMany marks tasks as failed, outcomes are reported per task.
+	FailMany(ctx context.Context, outcomes []Outcome) ([]BatchResult, error)
+	// Heartbeat prolongs a task lease, returns the new lease expiration time.
+	Heartbeat(ctx context.Context, id, claimID uuid.UUID, lease time.Duration) (time.Time, error)
+	// Release gives a claimed task back unprocessed, so it's claimed again at once.
+	Release(ctx context.Context, id, claimID uuid.UUID) error
 }
 
 // Supervisor is used for storage maintenance.
 type Supervisor interface {
 	// DeleteStaleTasks cleans storage from stale tasks.
 	DeleteStaleTasks(ctx context.Context, staleHours int) error
-	// TODO: delete or move tasks with N attempts
+	// MoveExhaustedTasks moves tasks without attempts left to dead letters.
+	MoveExhaustedTasks(ctx context.Context) error
+	// ExpireOverdueTasks marks unfinished tasks after their deadline as expired.
+	ExpireOverdueTasks(ctx context.Context) error
+	// CancelAbandonedTasks finishes cancellation of tasks, that were abandoned by workers.
+	CancelAbandonedTasks(ctx context.Context) error
+	// MaterialiseSchedules enqueues tasks for due schedule ticks.
+	MaterialiseSchedules(ctx context.Context) error
+	// DeliverWebhooks sends finished tasks to their callback URLs.
+	DeliverWebhooks(ctx context.Context) error
+}
+
+// Schedules used for recurring tasks management.
+type Schedules interface {
+	// CreateSchedule registers a recurring task.
+	CreateSchedule(ctx context.Context, schedule *Schedule) (*Schedule, error)
+	// GetSchedule returns a schedule by id.
+	GetSchedule(ctx context.Context, id uuid.UUID) (*Schedule, error)
+	// ListSchedules returns schedules ordered by creation time.
+	ListSchedules(ctx context.Context, limit, offset int) ([]*Schedule, error)
+	// DeleteSchedule stops a recurring task, enqueued tasks are kept.
+	DeleteSchedule(ctx context.Context, id uuid.UUID) error
+}
+
+// Admin used for tasks inspection.
+type Admin interface {
+	// ListTasks returns a page of filtered tasks.
+	ListTasks(ctx context.Context, request ListTasksRequest) (*TaskPage, error)
+}
+
+// DeadLetters used for dead letters management.
+type DeadLetters interface {
+	// ListDeadLetters returns dead letters ordered by death time.
+	ListDeadLetters(ctx context.Context, limit, offset int) ([]*DeadLetter, error)
+	// GetDeadLetter returns a dead letter by task id.
+	GetDeadLetter(ctx context.Context, id uuid.UUID) (*DeadLetter, error)
+	// RequeueDeadLetter moves a dead letter back to pending tasks.
+	RequeueDeadLetter(ctx context.Context, id uuid.UUID) (*Task, error)
+	// PurgeDeadLetters removes dead letters.
+	PurgeDeadLetters(ctx context.Context, ids []uuid.UUID) (int64, error)
 }
 
 // Gateway describes database access to a task.
 type Gateway interface {
 	// Create makes record with new task.
 	Create(ctx context.Context, task *Task) (*Task, error)
+	// CreateMany makes records with new tasks at once, duplicates are skipped and reported per task.
+	CreateMany(ctx context.Context, tasks []*Task) ([]BatchResult, error)
 	// FindByID allows to poll a task state.
 	FindByID(ctx context.Context, id uuid.UUID) (*Task, error)
+	// Cancel marks a task as cancelled or cancel-requested,
+	// reports whether the task was changed, repeated cancellation doesn't change it.
+	Cancel(ctx context.Context, id uuid.UUID) (*Task, bool, error)
 	// ClaimPending used for locking tasks.
-	ClaimPending(ctx context.Context, amount int) ([]*Task, error)
+	ClaimPending(ctx context.Context, request ClaimRequest) ([]*Task, error)
+	// NextExecuteAt returns the earliest execution time of claimable tasks in queues.
+	NextExecuteAt(ctx context.Context, queues []string) (time.Time, error)
+	// ListenTasks calls notify, when a task becomes claimable or finished.
+	// It blocks until ctx is done.
+	ListenTasks(ctx context.Context, notify func(event TaskEvent)) error
+	// ListTasks returns filtered tasks sorted by query.OrderBy and id.
+	ListTasks(ctx context.Context, query TaskQuery) ([]*Task, error)
 	// MarkAsSucceeded marks a task as successfully processed.
 	MarkAsSucceeded(ctx context.Context, id, claimID uuid.UUID, result map[string]interface{}) error
 	// MarkAsFailed marks a task as failed.
 	MarkAsFailed(ctx context.Context, id, claimID uuid.UUID, reason string) error
+	// MarkManyAsSucceeded marks tasks as successfully processed, errors are reported per task.
+	MarkManyAsSucceeded(ctx context.Context, outcomes []Outcome) ([]BatchResult, error)
+	// MarkManyAsFailed marks tasks as failed, errors are reported per task.
+	MarkManyAsFailed(ctx context.Context, outcomes []Outcome) ([]BatchResult, error)
+	// ExtendLease prolongs a claimed task lease, returns the new lease expiration time.
+	ExtendLease(ctx context.Context, id, claimID uuid.UUID, lease time.Duration) (time.Time, error)
+	// ReleaseTask makes a claimed task claimable at once, its attempts aren't changed.
+	ReleaseTask(ctx context.Context, id, claimID uuid.UUID) error
 	// DeleteStaleTasks removes stale tasks.
 	DeleteStaleTasks(ctx context.Context, staleHours int) (int64, error)
+	// MoveExhaustedTasks moves exhausted tasks to dead letters.
+	MoveExhaustedTasks(ctx context.Context) (int64, error)
+	// ExpireOverdueTasks marks unfinished tasks after their deadline as expired.
+	ExpireOverdueTasks(ctx context.Context) (int64, error)
+	// CancelAbandonedTasks marks cancel-requested tasks with an expired lease as cancelled.
+	CancelAbandonedTasks(ctx context.Context) (int64, error)
+	// ListDeadLetters returns dead letters ordered by death time.
+	ListDeadLetters(ctx context.Context, limit, offset int) ([]*DeadLetter, error)
+	// FindDeadLetterByID returns a dead letter by task id.
+	FindDeadLetterByID(ctx context.Context, id uuid.UUID) (*DeadLetter, error)
+	// RequeueDeadLetter moves a dead letter back to task as a pending one.
+	RequeueDeadLetter(ctx context.Context, id uuid.UUID) (*Task, error)
+	// PurgeDeadLetters removes dead letters by task ids.
+	PurgeDeadLetters(ctx context.Context, ids []uuid.UUID) (int64, error)
+	// CreateSchedule makes record with new schedule.
+	CreateSchedule(ctx context.Context, schedule *Schedule) (*Schedule, error)
+	// FindScheduleByID returns a schedule by id.
+	FindScheduleByID(ctx context.Context, id uuid.UUID) (*Schedule, error)
+	// ListSchedules returns schedules ordered by creation time.
+	ListSchedules(ctx context.Context, limit, offset int) ([]*Schedule, error)
+	// DeleteSchedule removes a schedule.
+	DeleteSchedule(ctx context.Context, id uuid.UUID) error
+	// FindDueSchedules returns schedules with the next tick before `until`.
+	FindDueSchedules(ctx context.Context, until time.Time, limit int) ([]*Schedule, error)
+	// AdvanceSchedule moves schedule's next tick from `from` to `to` and creates tasks atomically.
+	// Tasks with existing IDs are skipped. If the next tick was already moved, nothing happens.
+	AdvanceSchedule(ctx context.Context, id uuid.UUID, from, to time.Time, tasks []*Task) (int64, error)
+	// ClaimWebhooks locks due webhooks for delivery during lease.
+	ClaimWebhooks(ctx context.Context, limit int, lease time.Duration) ([]*Webhook, error)
+	// MarkWebhookDelivered marks a webhook as delivered.
+	MarkWebhookDelivered(ctx context.Context, id int64) error
+	// RetryWebhook postpones a webhook delivery after a failed attempt.
+	RetryWebhook(ctx context.Context, id int64, reason string, retryAt time.Time) error
+	// MarkWebhookFailed marks a webhook without attempts left as failed.
+	MarkWebhookFailed(ctx context.Context, id int64, reason string) error
+	// DeleteStaleWebhooks removes finished webhooks.
+	DeleteStaleWebhooks(ctx context.Context, staleHours int) (int64, error)
 }
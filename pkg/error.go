@@ -14,6 +14,18 @@ const (
 	ErrTaskNotFound = "task_not_found"
 	// ErrStaleResult means, that worker's result is stale.
 	ErrStaleResult = "stale_result"
+	// ErrDeadlineExceeded means, that worker's result came after the task's deadline.
+	ErrDeadlineExceeded = "deadline_exceeded"
+	// ErrTaskCancelled means, that task was cancelled by a client.
+	ErrTaskCancelled = "task_cancelled"
+	// ErrTaskFinished means, that task is already finished and can't be changed.
+	ErrTaskFinished = "task_finished"
+	// ErrScheduleNotFound means, that scheduler doesn't have a schedule with that ID.
+	ErrScheduleNotFound = "schedule_not_found"
+	// ErrInvalidArgument means, that request params are invalid.
+	ErrInvalidArgument = "invalid_argument"
+	// ErrRateLimited means, that a client exceeded its rate limit.
+	ErrRateLimited = "rate_limited"
 )
 
 // Error represents an error within the context of the service.
@@ -0,0 +1,1157 @@
+// Package gatewaytest is a conformance suite for domain.Gateway implementations.
+package gatewaytest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/google/uuid"
+)
+
+// Factory returns a gateway under test.
+// Tasks of every test case are kept in their own queue, so a gateway may be shared,
+// but supervisor methods change the whole storage, so it shouldn't be used by anyone else.
+type Factory func(t *testing.T) domain.Gateway
+
+// lease is short enough to wait for its expiration.
+const lease = 100 * time.Millisecond
+
+// Run checks, that a gateway follows domain.Gateway semantics.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, gw domain.Gateway)
+	}{
+		{name: "create", run: testCreate},
+		{name: "create many", run: testCreateMany},
+		{name: "claim pending", run: testClaimPending},
+		{name: "claim priority", run: testClaimPriority},
+		{name: "claim aging", run: testClaimAging},
+		{name: "concurrent claims", run: testConcurrentClaims},
+		{name: "lease expiration", run: testLeaseExpiration},
+		{name: "next execute at", run: testNextExecuteAt},
+		{name: "mark as succeeded", run: testMarkAsSucceeded},
+		{name: "mark as failed", run: testMarkAsFailed},
+		{name: "mark many", run: testMarkMany},
+		{name: "extend lease", run: testExtendLease},
+		{name: "release task", run: testReleaseTask},
+		{name: "cancel", run: testCancel},
+		{name: "listen tasks", run: testListenTasks},
+		{name: "list tasks", run: testListTasks},
+		{name: "supervise tasks", run: testSuperviseTasks},
+		{name: "dead letters", run: testDeadLetters},
+		{name: "schedules", run: testSchedules},
+		{name: "webhooks", run: testWebhooks},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, factory(t))
+		})
+	}
+}
+
+// now is truncated to the precision of the database timestamps.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func newQueue() string {
+	return "gatewaytest-" + uuid.New().String()
+}
+
+// newTask returns a task, that may be claimed at once.
+func newTask(queue string) *domain.Task {
+	return &domain.Task{
+		ID:        uuid.New(),
+		ExecuteAt: now().Add(-time.Second),
+		Deadline:  now().Add(time.Hour),
+		Queue:     queue,
+		Payload:   map[string]interface{}{"number": 1},
+		Meta:      map[string]interface{}{},
+	}
+}
+
+func create(t *testing.T, gw domain.Gateway, task *domain.Task) *domain.Task {
+	t.Helper()
+	created, err := gw.Create(context.Background(), task)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	return created
+}
+
+func find(t *testing.T, gw domain.Gateway, id uuid.UUID) *domain.Task {
+	t.Helper()
+	task, err := gw.FindByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	return task
+}
+
+func claim(t *testing.T, gw domain.Gateway, queue string, amount int, lease time.Duration) []*domain.Task {
+	t.Helper()
+	tasks, err := gw.ClaimPending(context.Background(), domain.ClaimRequest{
+		Amount: amount,
+		Queues: []string{queue},
+		Lease:  lease,
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	return tasks
+}
+
+// claimOne creates and claims a task.
+func claimOne(t *testing.T, gw domain.Gateway, task *domain.Task, lease time.Duration) *domain.Task {
+	t.Helper()
+	create(t, gw, task)
+	tasks := claim(t, gw, task.Queue, 1, lease)
+	if len(tasks) != 1 || tasks[0].ID != task.ID {
+		t.Fatalf("Expected `%v` claimed, got: `%v`", task.ID, tasks)
+	}
+	return tasks[0]
+}
+
+func expectCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if domain.ErrorCode(err) != code {
+		t.Errorf("Expected `%v`, got: `%v`", code, err)
+	}
+}
+
+func expectState(t *testing.T, gw domain.Gateway, id uuid.UUID, state domain.State) *domain.Task {
+	t.Helper()
+	task := find(t, gw, id)
+	if task.State != state {
+		t.Errorf("Expected `%v`, got: `%v`", state, task.State)
+	}
+	return task
+}
+
+func expectTime(t *testing.T, expected, observed time.Time, delta time.Duration) {
+	t.Helper()
+	if observed.Before(expected.Add(-delta)) || observed.After(expected.Add(delta)) {
+		t.Errorf("Expected `%v`, got: `%v`", expected, observed)
+	}
+}
+
+// attempts reads attempts from task meta, numbers may be decoded as float64.
+func attempts(meta map[string]interface{}) int {
+	switch value := meta["attempts"].(type) {
+	case float64:
+		return int(value)
+	case int:
+		return value
+	}
+	return 0
+}
+
+func testCreate(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	task := newTask(newQueue())
+	task.Priority = 3
+	task.RetryPolicy = &domain.RetryPolicy{MaxAttempts: 2}
+	task.CallbackURL = "http://example.com/callback"
+	created := create(t, gw, task)
+	for _, observed := range []*domain.Task{created, find(t, gw, task.ID)} {
+		if observed.ID != task.ID || observed.State != domain.StatePending || observed.ClaimID != nil {
+			t.Errorf("Expected pending `%v`, got: `%v`", task.ID, observed)
+		}
+		if observed.Queue != task.Queue || observed.Priority != task.Priority || observed.CallbackURL != task.CallbackURL {
+			t.Errorf("Expected `%v`, got: `%v`", task, observed)
+		}
+		if observed.Payload["number"] != float64(1) {
+			t.Errorf("Expected `%v`, got: `%v`", task.Payload, observed.Payload)
+		}
+		if observed.RetryPolicy == nil || observed.RetryPolicy.MaxAttempts != 2 {
+			t.Errorf("Expected `%v`, got: `%v`", task.RetryPolicy, observed.RetryPolicy)
+		}
+		expectTime(t, task.ExecuteAt, observed.ExecuteAt, time.Millisecond)
+		expectTime(t, task.Deadline, observed.Deadline, time.Millisecond)
+		expectTime(t, now(), observed.CreatedAt, time.Minute)
+		if observed.DoneAt.Valid {
+			t.Errorf("Expected no doneAt, got: `%v`", observed.DoneAt)
+		}
+	}
+
+	created.Payload["number"] = 2
+	if find(t, gw, task.ID).Payload["number"] != float64(1) {
+		t.Errorf("Expected a stored task to be detached from a returned one")
+	}
+
+	_, err := gw.Create(ctx, task)
+	expectCode(t, err, domain.ErrDuplicateTask)
+	_, err = gw.FindByID(ctx, uuid.New())
+	expectCode(t, err, domain.ErrTaskNotFound)
+}
+
+func testCreateMany(t *testing.T, gw domain.Gateway) {
+	queue := newQueue()
+	existing := create(t, gw, newTask(queue))
+	first, second := newTask(queue), newTask(queue)
+	results, err := gw.CreateMany(context.Background(), []*domain.Task{first, existing, second, first})
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	expected := []struct {
+		id   uuid.UUID
+		code string
+	}{
+		{id: first.ID},
+		{id: existing.ID, code: domain.ErrDuplicateTask},
+		{id: second.ID},
+		{id: first.ID, code: domain.ErrDuplicateTask},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected `%v` results, got: `%v`", len(expected), results)
+	}
+	for idx, result := range results {
+		if result.ID != expected[idx].id {
+			t.Errorf("Expected `%v`, got: `%v`", expected[idx].id, result.ID)
+		}
+		expectCode(t, result.Err, expected[idx].code)
+	}
+	expectState(t, gw, first.ID, domain.StatePending)
+	expectState(t, gw, second.ID, domain.StatePending)
+}
+
+func testClaimPending(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+	due := create(t, gw, newTask(queue))
+	future := newTask(queue)
+	future.ExecuteAt = now().Add(time.Hour)
+	create(t, gw, future)
+	overdue := newTask(queue)
+	overdue.Deadline = now().Add(-time.Millisecond)
+	create(t, gw, overdue)
+	other := create(t, gw, newTask(newQueue()))
+
+	tasks := claim(t, gw, queue, 10, time.Hour)
+	if len(tasks) != 1 || tasks[0].ID != due.ID {
+		t.Fatalf("Expected `%v` claimed, got: `%v`", due.ID, tasks)
+	}
+	claimed := tasks[0]
+	if claimed.State != domain.StateProcessing || claimed.ClaimID == nil {
+		t.Errorf("Expected processing task with claim, got: `%v`", claimed)
+	}
+	expectTime(t, now().Add(time.Hour), claimed.ExecuteAt, time.Minute)
+	stored := expectState(t, gw, due.ID, domain.StateProcessing)
+	if stored.ClaimID == nil || *stored.ClaimID != *claimed.ClaimID {
+		t.Errorf("Expected `%v`, got: `%v`", claimed.ClaimID, stored.ClaimID)
+	}
+
+	_, err := gw.ClaimPending(ctx, domain.ClaimRequest{Amount: 10, Queues: []string{queue}, Lease: time.Hour})
+	expectCode(t, err, domain.ErrNoPendingTasks)
+	expectState(t, gw, other.ID, domain.StatePending)
+
+	tasks, err = gw.ClaimPending(ctx, domain.ClaimRequest{Amount: 1, Queues: []string{queue, other.Queue}, Lease: time.Hour})
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != other.ID {
+		t.Errorf("Expected `%v` claimed, got: `%v`", other.ID, tasks)
+	}
+}
+
+func testClaimPriority(t *testing.T, gw domain.Gateway) {
+	queue := newQueue()
+	executeAt := now().Add(-time.Second)
+	priorities := []int{0, 5, 1}
+	ids := map[int]uuid.UUID{}
+	for _, priority := range priorities {
+		task := newTask(queue)
+		task.ExecuteAt = executeAt
+		task.Priority = priority
+		ids[priority] = create(t, gw, task).ID
+	}
+	for _, priority := range []int{5, 1, 0} {
+		tasks := claim(t, gw, queue, 1, time.Hour)
+		if len(tasks) != 1 || tasks[0].ID != ids[priority] {
+			t.Errorf("Expected task with priority `%v`, got: `%v`", priority, tasks)
+		}
+	}
+
+	// Priority wins over queue names, so later queues aren't starved.
+	first, second := newQueue(), newQueue()
+	if second < first {
+		first, second = second, first
+	}
+	routine := newTask(first)
+	routine.ExecuteAt = executeAt.Add(-time.Minute)
+	create(t, gw, routine)
+	urgent := newTask(second)
+	urgent.ExecuteAt = executeAt
+	urgent.Priority = 5
+	create(t, gw, urgent)
+	tasks, err := gw.ClaimPending(context.Background(), domain.ClaimRequest{Amount: 1, Queues: []string{first, second}, Lease: time.Hour})
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != urgent.ID {
+		t.Errorf("Expected `%v` claimed, got: `%v`", urgent.ID, tasks)
+	}
+}
+
+func testClaimAging(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+	waiting := newTask(queue)
+	waiting.ExecuteAt = now().Add(-time.Hour)
+	create(t, gw, waiting)
+	fresh := newTask(queue)
+	fresh.Priority = 5
+	create(t, gw, fresh)
+
+	// An hour of waiting raises the priority by 60 for a moment of the claim only.
+	tasks, err := gw.ClaimPending(ctx, domain.ClaimRequest{Amount: 1, Queues: []string{queue}, Lease: time.Hour, Aging: time.Minute})
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != waiting.ID || tasks[0].Priority != 0 {
+		t.Errorf("Expected `%v` claimed with its own priority, got: `%v`", waiting.ID, tasks)
+	}
+
+	// Aged priority doesn't exceed domain.MaxPriority, so the earlier urgent task wins.
+	queue = newQueue()
+	urgent := newTask(queue)
+	urgent.ExecuteAt = now().Add(-2 * time.Hour)
+	urgent.Priority = domain.MaxPriority
+	create(t, gw, urgent)
+	aged := newTask(queue)
+	aged.ExecuteAt = now().Add(-time.Hour)
+	create(t, gw, aged)
+	tasks, err = gw.ClaimPending(ctx, domain.ClaimRequest{Amount: 2, Queues: []string{queue}, Lease: time.Hour, Aging: time.Second})
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	if len(tasks) != 2 || tasks[0].ID != urgent.ID || tasks[1].ID != aged.ID {
+		t.Errorf("Expected `%v` claimed before `%v`, got: `%v`", urgent.ID, aged.ID, tasks)
+	}
+	if task := find(t, gw, aged.ID); task.Priority != 0 {
+		t.Errorf("Expected `%v`, got: `%v`", 0, task.Priority)
+	}
+}
+
+func testConcurrentClaims(t *testing.T, gw domain.Gateway) {
+	queue := newQueue()
+	const amount = 50
+	tasks := make([]*domain.Task, 0, amount)
+	for i := 0; i < amount; i++ {
+		tasks = append(tasks, newTask(queue))
+	}
+	_, err := gw.CreateMany(context.Background(), tasks)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	var (
+		mu      sync.Mutex
+		claimed = map[uuid.UUID]int{}
+		wg      sync.WaitGroup
+	)
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				tasks, err := gw.ClaimPending(context.Background(), domain.ClaimRequest{
+					Amount: 3,
+					Queues: []string{queue},
+					Lease:  time.Hour,
+				})
+				if domain.ErrorCode(err) == domain.ErrNoPendingTasks {
+					return
+				}
+				if err != nil {
+					t.Errorf("Expected no error, got: `%v`", err)
+					return
+				}
+				mu.Lock()
+				for _, task := range tasks {
+					claimed[task.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(claimed) != amount {
+		t.Errorf("Expected `%v` claimed tasks, got: `%v`", amount, len(claimed))
+	}
+	for id, times := range claimed {
+		if times != 1 {
+			t.Errorf("Expected `%v` claimed once, got: `%v`", id, times)
+		}
+	}
+}
+
+func testLeaseExpiration(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	task := newTask(newQueue())
+	first := claimOne(t, gw, task, lease)
+	_, err := gw.ClaimPending(ctx, domain.ClaimRequest{Amount: 1, Queues: []string{task.Queue}, Lease: lease})
+	expectCode(t, err, domain.ErrNoPendingTasks)
+
+	time.Sleep(2 * lease)
+	tasks := claim(t, gw, task.Queue, 1, time.Hour)
+	if len(tasks) != 1 || tasks[0].ID != task.ID {
+		t.Fatalf("Expected `%v` claimed, got: `%v`", task.ID, tasks)
+	}
+	second := tasks[0]
+	if *second.ClaimID == *first.ClaimID {
+		t.Errorf("Expected a new claim, got: `%v`", second.ClaimID)
+	}
+	err = gw.MarkAsSucceeded(ctx, task.ID, *first.ClaimID, nil)
+	expectCode(t, err, domain.ErrStaleResult)
+	err = gw.MarkAsSucceeded(ctx, task.ID, *second.ClaimID, nil)
+	if err != nil {
+		t.Errorf("Expected no error, got: `%v`", err)
+	}
+}
+
+func testNextExecuteAt(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+	_, err := gw.NextExecuteAt(ctx, []string{queue})
+	expectCode(t, err, domain.ErrNoPendingTasks)
+
+	later, sooner := newTask(queue), newTask(queue)
+	later.ExecuteAt = now().Add(2 * time.Hour)
+	sooner.ExecuteAt = now().Add(time.Hour)
+	create(t, gw, later)
+	create(t, gw, sooner)
+	executeAt, err := gw.NextExecuteAt(ctx, []string{queue})
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	expectTime(t, sooner.ExecuteAt, executeAt, time.Millisecond)
+}
+
+func testMarkAsSucceeded(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+	claimed := claimOne(t, gw, newTask(queue), time.Hour)
+
+	err := gw.MarkAsSucceeded(ctx, claimed.ID, uuid.New(), nil)
+	expectCode(t, err, domain.ErrStaleResult)
+	err = gw.MarkAsSucceeded(ctx, claimed.ID, *claimed.ClaimID, map[string]interface{}{"answer": 42})
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	task := expectState(t, gw, claimed.ID, domain.StateSucceeded)
+	if task.Result["answer"] != float64(42) {
+		t.Errorf("Expected `%v`, got: `%v`", 42, task.Result)
+	}
+	if task.ClaimID != nil || !task.DoneAt.Valid {
+		t.Errorf("Expected released finished task, got: `%v`", task)
+	}
+	err = gw.MarkAsSucceeded(ctx, claimed.ID, *claimed.ClaimID, nil)
+	expectCode(t, err, domain.ErrStaleResult)
+
+	overdue := newTask(queue)
+	overdue.Deadline = now().Add(lease)
+	claimed = claimOne(t, gw, overdue, time.Hour)
+	time.Sleep(2 * lease)
+	err = gw.MarkAsSucceeded(ctx, claimed.ID, *claimed.ClaimID, nil)
+	expectCode(t, err, domain.ErrDeadlineExceeded)
+	expectState(t, gw, claimed.ID, domain.StateExpired)
+}
+
+func testMarkAsFailed(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	task := newTask(newQueue())
+	task.RetryPolicy = &domain.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour}
+	claimed := claimOne(t, gw, task, time.Hour)
+
+	err := gw.MarkAsFailed(ctx, task.ID, uuid.New(), "boom")
+	expectCode(t, err, domain.ErrStaleResult)
+	err = gw.MarkAsFailed(ctx, task.ID, *claimed.ClaimID, "boom")
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	failed := expectState(t, gw, task.ID, domain.StateFailed)
+	if failed.ClaimID != nil || attempts(failed.Meta) != 1 || failed.Meta["failReason"] != "boom" {
+		t.Errorf("Expected released task with one attempt, got: `%v`", failed)
+	}
+	if history, _ := failed.Meta["history"].([]interface{}); len(history) != 1 {
+		t.Errorf("Expected one failure in history, got: `%v`", failed.Meta["history"])
+	}
+	expectTime(t, now().Add(time.Hour), failed.ExecuteAt, time.Minute)
+	err = gw.MarkAsFailed(ctx, task.ID, *claimed.ClaimID, "boom")
+	expectCode(t, err, domain.ErrStaleResult)
+
+	// The next attempt is postponed by the backoff.
+	_, err = gw.ClaimPending(ctx, domain.ClaimRequest{Amount: 1, Queues: []string{task.Queue}, Lease: time.Hour})
+	expectCode(t, err, domain.ErrNoPendingTasks)
+}
+
+func testMarkMany(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+	for i := 0; i < 4; i++ {
+		create(t, gw, newTask(queue))
+	}
+	tasks := claim(t, gw, queue, 4, time.Hour)
+	if len(tasks) != 4 {
+		t.Fatalf("Expected `%v` claimed tasks, got: `%v`", 4, tasks)
+	}
+	_, _, err := gw.Cancel(ctx, tasks[2].ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+
+	results, err := gw.MarkManyAsSucceeded(ctx, []domain.Outcome{
+		{ID: tasks[0].ID, ClaimID: *tasks[0].ClaimID, Result: map[string]interface{}{"ok": true}},
+		{ID: tasks[0].ID, ClaimID: *tasks[0].ClaimID},
+		{ID: tasks[1].ID, ClaimID: uuid.New()},
+		{ID: tasks[2].ID, ClaimID: *tasks[2].ClaimID},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	codes := []string{"", domain.ErrStaleResult, domain.ErrStaleResult, domain.ErrTaskCancelled}
+	if len(results) != len(codes) {
+		t.Fatalf("Expected `%v` results, got: `%v`", len(codes), results)
+	}
+	for idx, result := range results {
+		expectCode(t, result.Err, codes[idx])
+	}
+	expectState(t, gw, tasks[0].ID, domain.StateSucceeded)
+	expectState(t, gw, tasks[1].ID, domain.StateProcessing)
+	expectState(t, gw, tasks[2].ID, domain.StateCancelled)
+
+	results, err = gw.MarkManyAsFailed(ctx, []domain.Outcome{
+		{ID: tasks[1].ID, ClaimID: *tasks[1].ClaimID, Reason: "boom"},
+		{ID: tasks[3].ID, ClaimID: *tasks[3].ClaimID, Reason: "bang"},
+		{ID: tasks[3].ID, ClaimID: *tasks[3].ClaimID, Reason: "bang"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	codes = []string{"", "", domain.ErrStaleResult}
+	if len(results) != len(codes) {
+		t.Fatalf("Expected `%v` results, got: `%v`", len(codes), results)
+	}
+	for idx, result := range results {
+		if result.ID != []uuid.UUID{tasks[1].ID, tasks[3].ID, tasks[3].ID}[idx] {
+			t.Errorf("Expected results in outcomes order, got: `%v`", results)
+		}
+		expectCode(t, result.Err, codes[idx])
+	}
+	failed := expectState(t, gw, tasks[3].ID, domain.StateFailed)
+	if attempts(failed.Meta) != 1 || failed.Meta["failReason"] != "bang" {
+		t.Errorf("Expected one attempt, got: `%v`", failed.Meta)
+	}
+
+	// A batch plans attempts the same way as MarkAsFailed does, the second attempt is postponed up to MaxDelay.
+	policy := &domain.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, Multiplier: 2, MaxDelay: 90 * time.Minute}
+	single, batched := newTask(newQueue()), newTask(newQueue())
+	for _, task := range []*domain.Task{single, batched} {
+		task.RetryPolicy = policy
+		task.Meta = map[string]interface{}{"attempts": 1}
+	}
+	claimedSingle := claimOne(t, gw, single, time.Hour)
+	claimedBatched := claimOne(t, gw, batched, time.Hour)
+	err = gw.MarkAsFailed(ctx, single.ID, *claimedSingle.ClaimID, "boom")
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	results, err = gw.MarkManyAsFailed(ctx, []domain.Outcome{{ID: batched.ID, ClaimID: *claimedBatched.ClaimID, Reason: "boom"}})
+	if err != nil || len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("Expected no error, got: `%v`, `%v`", results, err)
+	}
+	expected := expectState(t, gw, single.ID, domain.StateFailed)
+	observed := expectState(t, gw, batched.ID, domain.StateFailed)
+	if attempts(observed.Meta) != 2 || attempts(expected.Meta) != 2 {
+		t.Errorf("Expected two attempts, got: `%v`, `%v`", expected.Meta, observed.Meta)
+	}
+	expectTime(t, now().Add(90*time.Minute), expected.ExecuteAt, time.Minute)
+	expectTime(t, expected.ExecuteAt, observed.ExecuteAt, time.Minute)
+}
+
+func testExtendLease(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+	claimed := claimOne(t, gw, newTask(queue), time.Minute)
+	leasedUntil, err := gw.ExtendLease(ctx, claimed.ID, *claimed.ClaimID, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	expectTime(t, now().Add(time.Hour), leasedUntil, time.Minute)
+	expectTime(t, leasedUntil, find(t, gw, claimed.ID).ExecuteAt, time.Millisecond)
+	_, err = gw.ExtendLease(ctx, claimed.ID, uuid.New(), time.Hour)
+	expectCode(t, err, domain.ErrStaleResult)
+
+	cancelled := claimOne(t, gw, newTask(queue), time.Hour)
+	_, _, err = gw.Cancel(ctx, cancelled.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	_, err = gw.ExtendLease(ctx, cancelled.ID, *cancelled.ClaimID, time.Hour)
+	expectCode(t, err, domain.ErrTaskCancelled)
+	expectState(t, gw, cancelled.ID, domain.StateCancelled)
+
+	overdue := newTask(queue)
+	overdue.Deadline = now().Add(lease)
+	claimed = claimOne(t, gw, overdue, time.Hour)
+	time.Sleep(2 * lease)
+	_, err = gw.ExtendLease(ctx, claimed.ID, *claimed.ClaimID, time.Hour)
+	expectCode(t, err, domain.ErrDeadlineExceeded)
+	expectState(t, gw, claimed.ID, domain.StateExpired)
+}
+
+func testReleaseTask(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+	claimed := claimOne(t, gw, newTask(queue), time.Hour)
+	err := gw.ReleaseTask(ctx, claimed.ID, uuid.New())
+	expectCode(t, err, domain.ErrStaleResult)
+	err = gw.ReleaseTask(ctx, claimed.ID, *claimed.ClaimID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	released := expectState(t, gw, claimed.ID, domain.StatePending)
+	if released.ClaimID != nil || attempts(released.Meta) != 0 {
+		t.Errorf("Expected unclaimed task without attempts, got: `%v`", released)
+	}
+	err = gw.ReleaseTask(ctx, claimed.ID, *claimed.ClaimID)
+	expectCode(t, err, domain.ErrStaleResult)
+	reclaimed := claim(t, gw, queue, 1, time.Hour)
+	if len(reclaimed) != 1 || reclaimed[0].ID != claimed.ID {
+		t.Errorf("Expected `%v` reclaimed, got: `%v`", claimed.ID, reclaimed)
+	}
+
+	cancelled := claimOne(t, gw, newTask(queue), time.Hour)
+	_, _, err = gw.Cancel(ctx, cancelled.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	err = gw.ReleaseTask(ctx, cancelled.ID, *cancelled.ClaimID)
+	expectCode(t, err, domain.ErrTaskCancelled)
+	expectState(t, gw, cancelled.ID, domain.StateCancelled)
+
+	overdue := newTask(queue)
+	overdue.Deadline = now().Add(lease)
+	claimed = claimOne(t, gw, overdue, time.Hour)
+	time.Sleep(2 * lease)
+	err = gw.ReleaseTask(ctx, claimed.ID, *claimed.ClaimID)
+	expectCode(t, err, domain.ErrDeadlineExceeded)
+	expectState(t, gw, claimed.ID, domain.StateExpired)
+}
+
+func testCancel(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+	pending := create(t, gw, newTask(queue))
+	task, changed, err := gw.Cancel(ctx, pending.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	if task.State != domain.StateCancelled || !task.DoneAt.Valid || !changed {
+		t.Errorf("Expected cancelled task, got: `%v`, changed `%v`", task, changed)
+	}
+	task, changed, err = gw.Cancel(ctx, pending.ID)
+	if err != nil || task.State != domain.StateCancelled || changed {
+		t.Errorf("Expected repeated cancellation to succeed without changes, got: `%v`, `%v`, `%v`", task, changed, err)
+	}
+
+	claimed := claimOne(t, gw, newTask(queue), time.Hour)
+	task, changed, err = gw.Cancel(ctx, claimed.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	if task.State != domain.StateProcessing || !task.CancelRequested || !changed {
+		t.Errorf("Expected cancel-requested task, got: `%v`, changed `%v`", task, changed)
+	}
+	_, changed, err = gw.Cancel(ctx, claimed.ID)
+	if err != nil || changed {
+		t.Errorf("Expected repeated cancel request to succeed without changes, got: `%v`, `%v`", changed, err)
+	}
+	err = gw.MarkAsSucceeded(ctx, claimed.ID, *claimed.ClaimID, nil)
+	expectCode(t, err, domain.ErrTaskCancelled)
+	expectState(t, gw, claimed.ID, domain.StateCancelled)
+
+	claimed = claimOne(t, gw, newTask(queue), time.Hour)
+	err = gw.MarkAsSucceeded(ctx, claimed.ID, *claimed.ClaimID, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	_, _, err = gw.Cancel(ctx, claimed.ID)
+	expectCode(t, err, domain.ErrTaskFinished)
+	_, _, err = gw.Cancel(ctx, uuid.New())
+	expectCode(t, err, domain.ErrTaskNotFound)
+}
+
+func testListenTasks(t *testing.T, gw domain.Gateway) {
+	queue := newQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan domain.TaskEvent, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- gw.ListenTasks(ctx, func(event domain.TaskEvent) {
+			if event.Queue == queue {
+				events <- event
+			}
+		})
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Expected no error, got: `%v`", err)
+		}
+	}()
+	// Listening starts asynchronously, so tasks are created until the first event comes.
+	probes := map[uuid.UUID]bool{}
+	timeout := time.After(5 * time.Second)
+	for len(probes) == 0 || !waitEvent(events, probes) {
+		select {
+		case <-timeout:
+			t.Fatalf("Expected an event of created task")
+		default:
+		}
+		probes[create(t, gw, newTask(queue)).ID] = true
+	}
+
+	// Probes are claimed, so the channel only has the task events.
+	claim(t, gw, queue, len(probes), time.Hour)
+	drain(events)
+	claimed := claimOne(t, gw, newTask(queue), time.Hour)
+	err := gw.MarkAsSucceeded(context.Background(), claimed.ID, *claimed.ClaimID, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	for _, state := range []domain.State{domain.StatePending, domain.StateSucceeded} {
+		select {
+		case event := <-events:
+			if event.ID != claimed.ID || event.State != state {
+				t.Errorf("Expected `%v` event, got: `%v`", state, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected `%v` event", state)
+		}
+	}
+}
+
+// waitEvent reports whether an event of any task in ids comes shortly.
+func waitEvent(events chan domain.TaskEvent, ids map[uuid.UUID]bool) bool {
+	timeout := time.After(50 * time.Millisecond)
+	for {
+		select {
+		case event := <-events:
+			if ids[event.ID] {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// drain skips delayed events.
+func drain(events chan domain.TaskEvent) {
+	timeout := time.After(50 * time.Millisecond)
+	for {
+		select {
+		case <-events:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func testListTasks(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+	tasks := make([]*domain.Task, 0)
+	for i := 0; i < 5; i++ {
+		tasks = append(tasks, newTask(queue))
+	}
+	_, err := gw.CreateMany(ctx, tasks)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	claimed := claim(t, gw, queue, 2, time.Hour)
+	if len(claimed) != 2 {
+		t.Fatalf("Expected `%v` claimed tasks, got: `%v`", 2, claimed)
+	}
+	err = gw.MarkAsSucceeded(ctx, claimed[0].ID, *claimed[0].ClaimID, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	err = gw.MarkAsFailed(ctx, claimed[1].ID, *claimed[1].ClaimID, "boom")
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+
+	for _, desc := range []bool{false, true} {
+		query := domain.TaskQuery{
+			Filter:  domain.TaskFilter{Queues: []string{queue}},
+			OrderBy: domain.OrderByCreatedAt,
+			Desc:    desc,
+			Limit:   2,
+		}
+		listed := make([]*domain.Task, 0)
+		for {
+			page, err := gw.ListTasks(ctx, query)
+			if err != nil {
+				t.Fatalf("Expected no error, got: `%v`", err)
+			}
+			listed = append(listed, page...)
+			if len(page) < query.Limit {
+				break
+			}
+			last := page[len(page)-1]
+			query.After = &domain.TaskKey{At: last.CreatedAt, ID: last.ID}
+		}
+		if len(listed) != len(tasks) {
+			t.Fatalf("Expected `%v` tasks, got: `%v`", len(tasks), len(listed))
+		}
+		sorted := sort.SliceIsSorted(listed, func(i, j int) bool {
+			less := listed[i].CreatedAt.Before(listed[j].CreatedAt) ||
+				listed[i].CreatedAt.Equal(listed[j].CreatedAt) && listed[i].ID.String() < listed[j].ID.String()
+			if desc {
+				return !less
+			}
+			return less
+		})
+		if !sorted {
+			t.Errorf("Expected tasks sorted by creation time, got: `%v`", listed)
+		}
+	}
+
+	one := 1
+	filters := []struct {
+		name     string
+		query    domain.TaskQuery
+		expected []uuid.UUID
+	}{
+		{
+			name: "states",
+			query: domain.TaskQuery{
+				Filter:  domain.TaskFilter{Queues: []string{queue}, States: []domain.State{domain.StateSucceeded}},
+				OrderBy: domain.OrderByExecuteAt,
+			},
+			expected: []uuid.UUID{claimed[0].ID},
+		},
+		{
+			name: "attempts",
+			query: domain.TaskQuery{
+				Filter:  domain.TaskFilter{Queues: []string{queue}, MinAttempts: &one},
+				OrderBy: domain.OrderByCreatedAt,
+			},
+			expected: []uuid.UUID{claimed[1].ID},
+		},
+		{
+			name: "finished",
+			query: domain.TaskQuery{
+				Filter:  domain.TaskFilter{Queues: []string{queue}},
+				OrderBy: domain.OrderByDoneAt,
+			},
+			expected: []uuid.UUID{claimed[0].ID},
+		},
+		{
+			name: "done range",
+			query: domain.TaskQuery{
+				Filter:  domain.TaskFilter{Queues: []string{queue}, DoneTo: now().Add(-time.Hour)},
+				OrderBy: domain.OrderByCreatedAt,
+			},
+			expected: []uuid.UUID{},
+		},
+	}
+	for _, tt := range filters {
+		tt.query.Limit = 100
+		listed, err := gw.ListTasks(ctx, tt.query)
+		if err != nil {
+			t.Fatalf("Expected no error, got: `%v`", err)
+		}
+		if len(listed) != len(tt.expected) {
+			t.Errorf("%s: expected `%v`, got: `%v`", tt.name, tt.expected, listed)
+			continue
+		}
+		for idx, task := range listed {
+			if task.ID != tt.expected[idx] {
+				t.Errorf("%s: expected `%v`, got: `%v`", tt.name, tt.expected[idx], task.ID)
+			}
+		}
+	}
+}
+
+func testSuperviseTasks(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+
+	abandoned := claimOne(t, gw, newTask(queue), lease)
+	_, _, err := gw.Cancel(ctx, abandoned.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	overdue := newTask(queue)
+	overdue.Deadline = now().Add(lease)
+	create(t, gw, overdue)
+	time.Sleep(2 * lease)
+
+	_, err = gw.ClaimPending(ctx, domain.ClaimRequest{Amount: 10, Queues: []string{queue}, Lease: time.Hour})
+	expectCode(t, err, domain.ErrNoPendingTasks)
+	expired, err := gw.ExpireOverdueTasks(ctx)
+	if err != nil || expired < 1 {
+		t.Errorf("Expected expired tasks, got: `%v`, `%v`", expired, err)
+	}
+	task := expectState(t, gw, overdue.ID, domain.StateExpired)
+	if !task.DoneAt.Valid {
+		t.Errorf("Expected doneAt, got: `%v`", task.DoneAt)
+	}
+	cancelled, err := gw.CancelAbandonedTasks(ctx)
+	if err != nil || cancelled < 1 {
+		t.Errorf("Expected cancelled tasks, got: `%v`, `%v`", cancelled, err)
+	}
+	expectState(t, gw, abandoned.ID, domain.StateCancelled)
+
+	pending := create(t, gw, newTask(queue))
+	deleted, err := gw.DeleteStaleTasks(ctx, 0)
+	if err != nil || deleted < 2 {
+		t.Errorf("Expected deleted tasks, got: `%v`, `%v`", deleted, err)
+	}
+	for _, id := range []uuid.UUID{overdue.ID, abandoned.ID} {
+		_, err = gw.FindByID(ctx, id)
+		expectCode(t, err, domain.ErrTaskNotFound)
+	}
+	expectState(t, gw, pending.ID, domain.StatePending)
+}
+
+// exhaust claims and fails a task with the only attempt.
+func exhaust(t *testing.T, gw domain.Gateway, queue string) uuid.UUID {
+	t.Helper()
+	tasks := claim(t, gw, queue, 1, time.Hour)
+	err := gw.MarkAsFailed(context.Background(), tasks[0].ID, *tasks[0].ClaimID, "boom")
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	expectState(t, gw, tasks[0].ID, domain.StateExhausted)
+	moved, err := gw.MoveExhaustedTasks(context.Background())
+	if err != nil || moved < 1 {
+		t.Fatalf("Expected moved tasks, got: `%v`, `%v`", moved, err)
+	}
+	return tasks[0].ID
+}
+
+func testDeadLetters(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	task := newTask(newQueue())
+	task.Priority = 2
+	task.RetryPolicy = &domain.RetryPolicy{MaxAttempts: 1}
+	create(t, gw, task)
+	exhaust(t, gw, task.Queue)
+
+	_, err := gw.FindByID(ctx, task.ID)
+	expectCode(t, err, domain.ErrTaskNotFound)
+	letter, err := gw.FindDeadLetterByID(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	if letter.Queue != task.Queue || letter.Priority != task.Priority || letter.Attempts != 1 || letter.FailReason != "boom" {
+		t.Errorf("Expected dead letter of `%v`, got: `%v`", task, letter)
+	}
+	if len(letter.History) != 1 || letter.History[0].Reason != "boom" {
+		t.Errorf("Expected one failure in history, got: `%v`", letter.History)
+	}
+	letters, err := gw.ListDeadLetters(ctx, 1000, 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	found := false
+	for _, letter := range letters {
+		found = found || letter.ID == task.ID
+	}
+	if !found {
+		t.Errorf("Expected `%v` in dead letters, got: `%v`", task.ID, letters)
+	}
+
+	requeued, err := gw.RequeueDeadLetter(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	if requeued.State != domain.StatePending || requeued.Queue != task.Queue {
+		t.Errorf("Expected pending task, got: `%v`", requeued)
+	}
+	if history, _ := requeued.Meta["history"].([]interface{}); len(history) != 1 {
+		t.Errorf("Expected history to be kept, got: `%v`", requeued.Meta)
+	}
+	_, err = gw.FindDeadLetterByID(ctx, task.ID)
+	expectCode(t, err, domain.ErrTaskNotFound)
+	_, err = gw.RequeueDeadLetter(ctx, task.ID)
+	expectCode(t, err, domain.ErrTaskNotFound)
+
+	exhaust(t, gw, task.Queue)
+	purged, err := gw.PurgeDeadLetters(ctx, []uuid.UUID{task.ID, uuid.New()})
+	if err != nil || purged != 1 {
+		t.Errorf("Expected `%v` purged, got: `%v`, `%v`", 1, purged, err)
+	}
+	_, err = gw.FindDeadLetterByID(ctx, task.ID)
+	expectCode(t, err, domain.ErrTaskNotFound)
+}
+
+func testSchedules(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+	schedule := &domain.Schedule{
+		ID:            uuid.New(),
+		Cron:          "@hourly",
+		Timezone:      "UTC",
+		Queue:         queue,
+		Payload:       map[string]interface{}{"number": 1},
+		TTL:           time.Hour,
+		MisfirePolicy: domain.MisfireOnce,
+		NextRunAt:     now().Add(-time.Minute),
+	}
+	created, err := gw.CreateSchedule(ctx, schedule)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	if created.ID != schedule.ID || created.Queue != queue || created.TTL != time.Hour || created.LastRunAt.Valid {
+		t.Errorf("Expected `%v`, got: `%v`", schedule, created)
+	}
+	_, err = gw.CreateSchedule(ctx, schedule)
+	expectCode(t, err, domain.ErrDuplicateTask)
+	found, err := gw.FindScheduleByID(ctx, schedule.ID)
+	if err != nil || found.ID != schedule.ID {
+		t.Errorf("Expected `%v`, got: `%v`, `%v`", schedule.ID, found, err)
+	}
+	_, err = gw.FindScheduleByID(ctx, uuid.New())
+	expectCode(t, err, domain.ErrScheduleNotFound)
+
+	contains := func(schedules []*domain.Schedule) bool {
+		for _, s := range schedules {
+			if s.ID == schedule.ID {
+				return true
+			}
+		}
+		return false
+	}
+	schedules, err := gw.ListSchedules(ctx, 1000, 0)
+	if err != nil || !contains(schedules) {
+		t.Errorf("Expected `%v` listed, got: `%v`, `%v`", schedule.ID, schedules, err)
+	}
+	schedules, err = gw.FindDueSchedules(ctx, now(), 1000)
+	if err != nil || !contains(schedules) {
+		t.Errorf("Expected `%v` due, got: `%v`, `%v`", schedule.ID, schedules, err)
+	}
+
+	task := newTask(queue)
+	next := now().Add(time.Hour)
+	advanced, err := gw.AdvanceSchedule(ctx, schedule.ID, created.NextRunAt, next, []*domain.Task{task})
+	if err != nil || advanced != 1 {
+		t.Errorf("Expected `%v` task, got: `%v`, `%v`", 1, advanced, err)
+	}
+	advanced, err = gw.AdvanceSchedule(ctx, schedule.ID, created.NextRunAt, next, []*domain.Task{newTask(queue)})
+	if err != nil || advanced != 0 {
+		t.Errorf("Expected moved tick to be skipped, got: `%v`, `%v`", advanced, err)
+	}
+	expectState(t, gw, task.ID, domain.StatePending)
+	found, err = gw.FindScheduleByID(ctx, schedule.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	expectTime(t, next, found.NextRunAt, time.Millisecond)
+	if !found.LastRunAt.Valid {
+		t.Errorf("Expected last run, got: `%v`", found.LastRunAt)
+	}
+	schedules, err = gw.FindDueSchedules(ctx, now(), 1000)
+	if err != nil || contains(schedules) {
+		t.Errorf("Expected `%v` not due, got: `%v`, `%v`", schedule.ID, schedules, err)
+	}
+
+	err = gw.DeleteSchedule(ctx, schedule.ID)
+	if err != nil {
+		t.Errorf("Expected no error, got: `%v`", err)
+	}
+	err = gw.DeleteSchedule(ctx, schedule.ID)
+	expectCode(t, err, domain.ErrScheduleNotFound)
+}
+
+// claimWebhooks returns claimed webhooks of tasks.
+func claimWebhooks(t *testing.T, gw domain.Gateway, ids ...uuid.UUID) map[uuid.UUID]*domain.Webhook {
+	t.Helper()
+	webhooks, err := gw.ClaimWebhooks(context.Background(), 1000, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	claimed := map[uuid.UUID]*domain.Webhook{}
+	for _, webhook := range webhooks {
+		for _, id := range ids {
+			if webhook.TaskID == id {
+				claimed[id] = webhook
+			}
+		}
+	}
+	return claimed
+}
+
+func testWebhooks(t *testing.T, gw domain.Gateway) {
+	ctx := context.Background()
+	queue := newQueue()
+	task := newTask(queue)
+	task.CallbackURL = "http://example.com/callback"
+	succeeded := claimOne(t, gw, task, time.Hour)
+	err := gw.MarkAsSucceeded(ctx, succeeded.ID, *succeeded.ClaimID, map[string]interface{}{"answer": 42})
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	cancelled := newTask(queue)
+	cancelled.CallbackURL = task.CallbackURL
+	create(t, gw, cancelled)
+	_, _, err = gw.Cancel(ctx, cancelled.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	silent := claimOne(t, gw, newTask(queue), time.Hour)
+	err = gw.MarkAsSucceeded(ctx, silent.ID, *silent.ClaimID, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+
+	claimed := claimWebhooks(t, gw, succeeded.ID, cancelled.ID, silent.ID)
+	if len(claimed) != 2 || claimed[silent.ID] != nil {
+		t.Fatalf("Expected webhooks of tasks with callbacks, got: `%v`", claimed)
+	}
+	webhook := claimed[succeeded.ID]
+	if webhook.URL != task.CallbackURL || webhook.State != domain.WebhookPending || webhook.Attempts != 1 {
+		t.Errorf("Expected pending webhook with an attempt, got: `%v`", webhook)
+	}
+	if webhook.Payload["state"] != string(domain.StateSucceeded) || webhook.Payload["id"] != succeeded.ID.String() {
+		t.Errorf("Expected succeeded task payload, got: `%v`", webhook.Payload)
+	}
+	if len(claimWebhooks(t, gw, succeeded.ID, cancelled.ID)) != 0 {
+		t.Errorf("Expected leased webhooks to be skipped")
+	}
+
+	err = gw.RetryWebhook(ctx, webhook.ID, "unavailable", now().Add(-time.Second))
+	if err != nil {
+		t.Fatalf("Expected no error, got: `%v`", err)
+	}
+	retried := claimWebhooks(t, gw, succeeded.ID)[succeeded.ID]
+	if retried == nil || retried.Attempts != 2 || retried.LastError != "unavailable" {
+		t.Errorf("Expected retried webhook, got: `%v`", retried)
+	}
+	for _, err := range []error{
+		gw.MarkWebhookDelivered(ctx, webhook.ID),
+		gw.MarkWebhookFailed(ctx, claimed[cancelled.ID].ID, "gone"),
+		gw.RetryWebhook(ctx, claimed[cancelled.ID].ID, "gone", now().Add(-time.Second)),
+	} {
+		if err != nil {
+			t.Fatalf("Expected no error, got: `%v`", err)
+		}
+	}
+	// Retry of a failed webhook doesn't bring it back.
+	if len(claimWebhooks(t, gw, succeeded.ID, cancelled.ID)) != 0 {
+		t.Errorf("Expected finished webhooks to be skipped")
+	}
+	deleted, err := gw.DeleteStaleWebhooks(ctx, 0)
+	if err != nil || deleted < 2 {
+		t.Errorf("Expected deleted webhooks, got: `%v`, `%v`", deleted, err)
+	}
+}
@@ -2,6 +2,7 @@ package mock
 
 import (
 	"context"
+	"time"
 
 	domain "github.com/freundallein/scheduler/pkg"
 	"github.com/google/uuid"
@@ -9,12 +10,42 @@ import (
 
 // Gateway mocks domain.Gateway.
 type Gateway struct {
-	CreateFn           func(task *domain.Task) (*domain.Task, error)
-	FindByIDFn         func(id uuid.UUID) (*domain.Task, error)
-	ClaimPendingFn     func(amount int) ([]*domain.Task, error)
-	MarkAsSucceededFn  func(id, claimID uuid.UUID, result map[string]interface{}) error
-	MarkAsFailedFn     func(id, claimID uuid.UUID, reason string) error
-	DeleteStaleTasksFn func(staleHours int) (int64, error)
+	CreateFn              func(task *domain.Task) (*domain.Task, error)
+	CreateManyFn          func(tasks []*domain.Task) ([]domain.BatchResult, error)
+	FindByIDFn            func(id uuid.UUID) (*domain.Task, error)
+	CancelFn              func(id uuid.UUID) (*domain.Task, bool, error)
+	ClaimPendingFn        func(request domain.ClaimRequest) ([]*domain.Task, error)
+	NextExecuteAtFn       func(queues []string) (time.Time, error)
+	ListenTasksFn         func(ctx context.Context, notify func(event domain.TaskEvent)) error
+	ListTasksFn           func(query domain.TaskQuery) ([]*domain.Task, error)
+	MarkAsSucceededFn     func(id, claimID uuid.UUID, result map[string]interface{}) error
+	MarkAsFailedFn        func(id, claimID uuid.UUID, reason string) error
+	MarkManyAsSucceededFn func(outcomes []domain.Outcome) ([]domain.BatchResult, error)
+	MarkManyAsFailedFn    func(outcomes []domain.Outcome) ([]domain.BatchResult, error)
+	ExtendLeaseFn         func(id, claimID uuid.UUID, lease time.Duration) (time.Time, error)
+	ReleaseTaskFn         func(id, claimID uuid.UUID) error
+	DeleteStaleTasksFn    func(staleHours int) (int64, error)
+
+	MoveExhaustedTasksFn   func() (int64, error)
+	ExpireOverdueTasksFn   func() (int64, error)
+	CancelAbandonedTasksFn func() (int64, error)
+	ListDeadLettersFn      func(limit, offset int) ([]*domain.DeadLetter, error)
+	FindDeadLetterByIDFn   func(id uuid.UUID) (*domain.DeadLetter, error)
+	RequeueDeadLetterFn    func(id uuid.UUID) (*domain.Task, error)
+	PurgeDeadLettersFn     func(ids []uuid.UUID) (int64, error)
+
+	CreateScheduleFn   func(schedule *domain.Schedule) (*domain.Schedule, error)
+	FindScheduleByIDFn func(id uuid.UUID) (*domain.Schedule, error)
+	ListSchedulesFn    func(limit, offset int) ([]*domain.Schedule, error)
+	DeleteScheduleFn   func(id uuid.UUID) error
+	FindDueSchedulesFn func(until time.Time, limit int) ([]*domain.Schedule, error)
+	AdvanceScheduleFn  func(id uuid.UUID, from, to time.Time, tasks []*domain.Task) (int64, error)
+
+	ClaimWebhooksFn        func(limit int, lease time.Duration) ([]*domain.Webhook, error)
+	MarkWebhookDeliveredFn func(id int64) error
+	RetryWebhookFn         func(id int64, reason string, retryAt time.Time) error
+	MarkWebhookFailedFn    func(id int64, reason string) error
+	DeleteStaleWebhooksFn  func(staleHours int) (int64, error)
 }
 
 // Create makes record with new task.
@@ -25,6 +56,14 @@ func (m *Gateway) Create(ctx context.Context, task *domain.Task) (*domain.Task,
 	return m.CreateFn(task)
 }
 
+// CreateMany makes records with new tasks at once.
+func (m *Gateway) CreateMany(ctx context.Context, tasks []*domain.Task) ([]domain.BatchResult, error) {
+	if m.CreateManyFn == nil {
+		panic("Gateway.CreateManyFn is not implemented")
+	}
+	return m.CreateManyFn(tasks)
+}
+
 // FindByID allows to poll a task state.
 func (m *Gateway) FindByID(ctx context.Context, id uuid.UUID) (*domain.Task, error) {
 	if m.FindByIDFn == nil {
@@ -33,12 +72,44 @@ func (m *Gateway) FindByID(ctx context.Context, id uuid.UUID) (*domain.Task, err
 	return m.FindByIDFn(id)
 }
 
+// Cancel marks a task as cancelled or cancel-requested.
+func (m *Gateway) Cancel(ctx context.Context, id uuid.UUID) (*domain.Task, bool, error) {
+	if m.CancelFn == nil {
+		panic("Gateway.CancelFn is not implemented")
+	}
+	return m.CancelFn(id)
+}
+
 // ClaimPending used for locking tasks.
-func (m *Gateway) ClaimPending(ctx context.Context, amount int) ([]*domain.Task, error) {
+func (m *Gateway) ClaimPending(ctx context.Context, request domain.ClaimRequest) ([]*domain.Task, error) {
 	if m.ClaimPendingFn == nil {
 		panic("Gateway.ClaimPendingFn is not implemented")
 	}
-	return m.ClaimPendingFn(amount)
+	return m.ClaimPendingFn(request)
+}
+
+// NextExecuteAt returns the earliest execution time of claimable tasks in queues.
+func (m *Gateway) NextExecuteAt(ctx context.Context, queues []string) (time.Time, error) {
+	if m.NextExecuteAtFn == nil {
+		panic("Gateway.NextExecuteAtFn is not implemented")
+	}
+	return m.NextExecuteAtFn(queues)
+}
+
+// ListenTasks calls notify, when a task becomes claimable or finished.
+func (m *Gateway) ListenTasks(ctx context.Context, notify func(event domain.TaskEvent)) error {
+	if m.ListenTasksFn == nil {
+		panic("Gateway.ListenTasksFn is not implemented")
+	}
+	return m.ListenTasksFn(ctx, notify)
+}
+
+// ListTasks returns filtered tasks.
+func (m *Gateway) ListTasks(ctx context.Context, query domain.TaskQuery) ([]*domain.Task, error) {
+	if m.ListTasksFn == nil {
+		panic("Gateway.ListTasksFn is not implemented")
+	}
+	return m.ListTasksFn(query)
 }
 
 // MarkAsSucceeded marks a task as succefully processed.
@@ -57,6 +128,38 @@ func (m *Gateway) MarkAsFailed(ctx context.Context, id, claimID uuid.UUID, reaso
 	return m.MarkAsFailedFn(id, claimID, reason)
 }
 
+// MarkManyAsSucceeded marks tasks as successfully processed.
+func (m *Gateway) MarkManyAsSucceeded(ctx context.Context, outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	if m.MarkManyAsSucceededFn == nil {
+		panic("Gateway.MarkManyAsSucceededFn is not implemented")
+	}
+	return m.MarkManyAsSucceededFn(outcomes)
+}
+
+// MarkManyAsFailed marks tasks as failed.
+func (m *Gateway) MarkManyAsFailed(ctx context.Context, outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	if m.MarkManyAsFailedFn == nil {
+		panic("Gateway.MarkManyAsFailedFn is not implemented")
+	}
+	return m.MarkManyAsFailedFn(outcomes)
+}
+
+// ExtendLease prolongs a claimed task lease.
+func (m *Gateway) ExtendLease(ctx context.Context, id, claimID uuid.UUID, lease time.Duration) (time.Time, error) {
+	if m.ExtendLeaseFn == nil {
+		panic("Gateway.ExtendLeaseFn is not implemented")
+	}
+	return m.ExtendLeaseFn(id, claimID, lease)
+}
+
+// ReleaseTask makes a claimed task claimable.
+func (m *Gateway) ReleaseTask(ctx context.Context, id, claimID uuid.UUID) error {
+	if m.ReleaseTaskFn == nil {
+		panic("Gateway.ReleaseTaskFn is not implemented")
+	}
+	return m.ReleaseTaskFn(id, claimID)
+}
+
 // DeleteStaleTasks removes stale tasks.
 func (m *Gateway) DeleteStaleTasks(ctx context.Context, staleHours int) (int64, error) {
 	if m.DeleteStaleTasksFn == nil {
@@ -64,3 +167,147 @@ func (m *Gateway) DeleteStaleTasks(ctx context.Context, staleHours int) (int64,
 	}
 	return m.DeleteStaleTasksFn(staleHours)
 }
+
+// MoveExhaustedTasks moves exhausted tasks to dead letters.
+func (m *Gateway) MoveExhaustedTasks(ctx context.Context) (int64, error) {
+	if m.MoveExhaustedTasksFn == nil {
+		panic("Gateway.MoveExhaustedTasksFn is not implemented")
+	}
+	return m.MoveExhaustedTasksFn()
+}
+
+// ExpireOverdueTasks marks unfinished tasks after their deadline as expired.
+func (m *Gateway) ExpireOverdueTasks(ctx context.Context) (int64, error) {
+	if m.ExpireOverdueTasksFn == nil {
+		panic("Gateway.ExpireOverdueTasksFn is not implemented")
+	}
+	return m.ExpireOverdueTasksFn()
+}
+
+// CancelAbandonedTasks marks cancel-requested tasks with an expired lease as cancelled.
+func (m *Gateway) CancelAbandonedTasks(ctx context.Context) (int64, error) {
+	if m.CancelAbandonedTasksFn == nil {
+		panic("Gateway.CancelAbandonedTasksFn is not implemented")
+	}
+	return m.CancelAbandonedTasksFn()
+}
+
+// ListDeadLetters returns dead letters ordered by death time.
+func (m *Gateway) ListDeadLetters(ctx context.Context, limit, offset int) ([]*domain.DeadLetter, error) {
+	if m.ListDeadLettersFn == nil {
+		panic("Gateway.ListDeadLettersFn is not implemented")
+	}
+	return m.ListDeadLettersFn(limit, offset)
+}
+
+// FindDeadLetterByID returns a dead letter by task id.
+func (m *Gateway) FindDeadLetterByID(ctx context.Context, id uuid.UUID) (*domain.DeadLetter, error) {
+	if m.FindDeadLetterByIDFn == nil {
+		panic("Gateway.FindDeadLetterByIDFn is not implemented")
+	}
+	return m.FindDeadLetterByIDFn(id)
+}
+
+// RequeueDeadLetter moves a dead letter back to task as a pending one.
+func (m *Gateway) RequeueDeadLetter(ctx context.Context, id uuid.UUID) (*domain.Task, error) {
+	if m.RequeueDeadLetterFn == nil {
+		panic("Gateway.RequeueDeadLetterFn is not implemented")
+	}
+	return m.RequeueDeadLetterFn(id)
+}
+
+// PurgeDeadLetters removes dead letters by task ids.
+func (m *Gateway) PurgeDeadLetters(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	if m.PurgeDeadLettersFn == nil {
+		panic("Gateway.PurgeDeadLettersFn is not implemented")
+	}
+	return m.PurgeDeadLettersFn(ids)
+}
+
+// CreateSchedule makes record with new schedule.
+func (m *Gateway) CreateSchedule(ctx context.Context, schedule *domain.Schedule) (*domain.Schedule, error) {
+	if m.CreateScheduleFn == nil {
+		panic("Gateway.CreateScheduleFn is not implemented")
+	}
+	return m.CreateScheduleFn(schedule)
+}
+
+// FindScheduleByID returns a schedule by id.
+func (m *Gateway) FindScheduleByID(ctx context.Context, id uuid.UUID) (*domain.Schedule, error) {
+	if m.FindScheduleByIDFn == nil {
+		panic("Gateway.FindScheduleByIDFn is not implemented")
+	}
+	return m.FindScheduleByIDFn(id)
+}
+
+// ListSchedules returns schedules ordered by creation time.
+func (m *Gateway) ListSchedules(ctx context.Context, limit, offset int) ([]*domain.Schedule, error) {
+	if m.ListSchedulesFn == nil {
+		panic("Gateway.ListSchedulesFn is not implemented")
+	}
+	return m.ListSchedulesFn(limit, offset)
+}
+
+// DeleteSchedule removes a schedule.
+func (m *Gateway) DeleteSchedule(ctx context.Context, id uuid.UUID) error {
+	if m.DeleteScheduleFn == nil {
+		panic("Gateway.DeleteScheduleFn is not implemented")
+	}
+	return m.DeleteScheduleFn(id)
+}
+
+// FindDueSchedules returns schedules with the next tick before `until`.
+func (m *Gateway) FindDueSchedules(ctx context.Context, until time.Time, limit int) ([]*domain.Schedule, error) {
+	if m.FindDueSchedulesFn == nil {
+		panic("Gateway.FindDueSchedulesFn is not implemented")
+	}
+	return m.FindDueSchedulesFn(until, limit)
+}
+
+// AdvanceSchedule moves schedule's next tick and creates tasks atomically.
+func (m *Gateway) AdvanceSchedule(ctx context.Context, id uuid.UUID, from, to time.Time, tasks []*domain.Task) (int64, error) {
+	if m.AdvanceScheduleFn == nil {
+		panic("Gateway.AdvanceScheduleFn is not implemented")
+	}
+	return m.AdvanceScheduleFn(id, from, to, tasks)
+}
+
+// ClaimWebhooks locks due webhooks for delivery during lease.
+func (m *Gateway) ClaimWebhooks(ctx context.Context, limit int, lease time.Duration) ([]*domain.Webhook, error) {
+	if m.ClaimWebhooksFn == nil {
+		panic("Gateway.ClaimWebhooksFn is not implemented")
+	}
+	return m.ClaimWebhooksFn(limit, lease)
+}
+
+// MarkWebhookDelivered marks a webhook as delivered.
+func (m *Gateway) MarkWebhookDelivered(ctx context.Context, id int64) error {
+	if m.MarkWebhookDeliveredFn == nil {
+		panic("Gateway.MarkWebhookDeliveredFn is not implemented")
+	}
+	return m.MarkWebhookDeliveredFn(id)
+}
+
+// RetryWebhook postpones a webhook delivery after a failed attempt.
+func (m *Gateway) RetryWebhook(ctx context.Context, id int64, reason string, retryAt time.Time) error {
+	if m.RetryWebhookFn == nil {
+		panic("Gateway.RetryWebhookFn is not implemented")
+	}
+	return m.RetryWebhookFn(id, reason, retryAt)
+}
+
+// MarkWebhookFailed marks a webhook without attempts left as failed.
+func (m *Gateway) MarkWebhookFailed(ctx context.Context, id int64, reason string) error {
+	if m.MarkWebhookFailedFn == nil {
+		panic("Gateway.MarkWebhookFailedFn is not implemented")
+	}
+	return m.MarkWebhookFailedFn(id, reason)
+}
+
+// DeleteStaleWebhooks removes finished webhooks.
+func (m *Gateway) DeleteStaleWebhooks(ctx context.Context, staleHours int) (int64, error) {
+	if m.DeleteStaleWebhooksFn == nil {
+		panic("Gateway.DeleteStaleWebhooksFn is not implemented")
+	}
+	return m.DeleteStaleWebhooksFn(staleHours)
+}
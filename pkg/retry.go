@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes when a failed task is executed again.
+type RetryPolicy struct {
+	// MaxAttempts limits amount of attempts, zero means unlimited.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// BaseDelay is a delay before the second attempt.
+	BaseDelay time.Duration `json:"baseDelay,omitempty"`
+	// Multiplier grows the delay after every next attempt.
+	Multiplier float64 `json:"multiplier,omitempty"`
+	// MaxDelay limits the delay, zero means unlimited.
+	MaxDelay time.Duration `json:"maxDelay,omitempty"`
+	// Jitter is a fraction of the delay which is randomised.
+	Jitter float64 `json:"jitter,omitempty"`
+}
+
+// Exhausted reports whether a task has no attempts left.
+func (p *RetryPolicy) Exhausted(attempts int) bool {
+	if p == nil || p.MaxAttempts <= 0 {
+		return false
+	}
+	return attempts >= p.MaxAttempts
+}
+
+// Backoff returns a delay before the next attempt after `attempts` failures.
+func (p *RetryPolicy) Backoff(attempts int) time.Duration {
+	if p == nil || p.BaseDelay <= 0 || attempts <= 0 {
+		return 0
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(attempts-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	// Jitter is applied within the cap, so capped delays are randomised too.
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		low, high := delay*(1-jitter), delay*(1+jitter)
+		if p.MaxDelay > 0 && high > float64(p.MaxDelay) {
+			high = float64(p.MaxDelay)
+		}
+		delay = low + (high-low)*rand.Float64()
+	}
+	// float64(math.MaxInt64) is 2^63, which doesn't fit time.Duration.
+	if delay >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay)
+}
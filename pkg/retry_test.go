@@ -0,0 +1,132 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *RetryPolicy
+		attempts int
+		expected time.Duration
+	}{
+		{
+			name:     "no policy",
+			attempts: 3,
+			expected: 0,
+		},
+		{
+			name:     "first attempt",
+			policy:   &RetryPolicy{BaseDelay: time.Second, Multiplier: 2},
+			attempts: 1,
+			expected: time.Second,
+		},
+		{
+			name:     "exponential growth",
+			policy:   &RetryPolicy{BaseDelay: time.Second, Multiplier: 2},
+			attempts: 4,
+			expected: 8 * time.Second,
+		},
+		{
+			name:     "constant delay",
+			policy:   &RetryPolicy{BaseDelay: time.Second},
+			attempts: 4,
+			expected: time.Second,
+		},
+		{
+			name:     "max delay",
+			policy:   &RetryPolicy{BaseDelay: time.Second, Multiplier: 10, MaxDelay: time.Minute},
+			attempts: 5,
+			expected: time.Minute,
+		},
+		{
+			name:     "overflow",
+			policy:   &RetryPolicy{BaseDelay: time.Hour, Multiplier: 10},
+			attempts: 100,
+			expected: time.Duration(1<<63 - 1),
+		},
+		{
+			name:     "overflow by one",
+			policy:   &RetryPolicy{BaseDelay: 1 << 62, Multiplier: 2},
+			attempts: 2,
+			expected: time.Duration(1<<63 - 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observed := tt.policy.Backoff(tt.attempts)
+			if observed != tt.expected {
+				t.Errorf("Expected `%v`, got: `%v`", tt.expected, observed)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	policy := &RetryPolicy{BaseDelay: 10 * time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		observed := policy.Backoff(1)
+		if observed < 5*time.Second || observed > 15*time.Second {
+			t.Errorf("Expected delay within `5s..15s`, got: `%v`", observed)
+		}
+	}
+}
+
+func TestRetryPolicyJitterWithinMaxDelay(t *testing.T) {
+	policy := &RetryPolicy{BaseDelay: time.Second, Multiplier: 10, MaxDelay: 10 * time.Second, Jitter: 0.5}
+	capped := 0
+	for i := 0; i < 100; i++ {
+		observed := policy.Backoff(5)
+		if observed < 5*time.Second || observed > 10*time.Second {
+			t.Errorf("Expected delay within `5s..10s`, got: `%v`", observed)
+		}
+		if observed == 10*time.Second {
+			capped++
+		}
+	}
+	if capped == 100 {
+		t.Errorf("Expected capped delays to be randomised")
+	}
+}
+
+func TestRetryPolicyExhausted(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *RetryPolicy
+		attempts int
+		expected bool
+	}{
+		{
+			name:     "no policy",
+			attempts: 100,
+		},
+		{
+			name:     "unlimited attempts",
+			policy:   &RetryPolicy{},
+			attempts: 100,
+		},
+		{
+			name:     "attempts left",
+			policy:   &RetryPolicy{MaxAttempts: 3},
+			attempts: 2,
+		},
+		{
+			name:     "no attempts left",
+			policy:   &RetryPolicy{MaxAttempts: 3},
+			attempts: 3,
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observed := tt.policy.Exhausted(tt.attempts)
+			if observed != tt.expected {
+				t.Errorf("Expected `%v`, got: `%v`", tt.expected, observed)
+			}
+		})
+	}
+}
@@ -1,6 +1,12 @@
 package scheduler
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	domain "github.com/freundallein/scheduler/pkg"
+)
 
 // Option is used to configure Service.
 type Option func(service *Service)
@@ -40,6 +46,42 @@ func WithTasksFailed(counter prometheus.Counter) Option {
 	}
 }
 
+// WithTasksCancelled configures Service to use counter metrics.
+func WithTasksCancelled(counter prometheus.Counter) Option {
+	return func(s *Service) {
+		s.tasksCancelled = counter
+	}
+}
+
+// WithLeaseBounds configures Service to accept task leases within bounds.
+// The default lease is clamped to the bounds.
+func WithLeaseBounds(min, max time.Duration) Option {
+	return func(s *Service) {
+		if min <= 0 || max < min {
+			return
+		}
+		s.minLease = min
+		s.maxLease = max
+		if s.defaultLease < min {
+			s.defaultLease = min
+		}
+		if s.defaultLease > max {
+			s.defaultLease = max
+		}
+	}
+}
+
+// WithPriorityAging configures how long a task waits after its execution time
+// to gain one point of priority, when tasks are claimed. Zero disables aging.
+// Negative aging is ignored.
+func WithPriorityAging(aging time.Duration) Option {
+	return func(s *Service) {
+		if aging >= 0 {
+			s.priorityAging = aging
+		}
+	}
+}
+
 // SupervisorOption is used to configure Worker.
 type SupervisorOption func(service *Supervisor)
 
@@ -49,3 +91,59 @@ func WithStaleTasksDeleted(counter prometheus.Counter) SupervisorOption {
 		s.staleTasksDeletedCounter = counter
 	}
 }
+
+// WithExhaustedTasksMoved configures Supervisor to use counter metrics.
+func WithExhaustedTasksMoved(counter prometheus.Counter) SupervisorOption {
+	return func(s *Supervisor) {
+		s.exhaustedTasksMovedCounter = counter
+	}
+}
+
+// WithOverdueTasksExpired configures Supervisor to use counter metrics.
+func WithOverdueTasksExpired(counter prometheus.Counter) SupervisorOption {
+	return func(s *Supervisor) {
+		s.overdueTasksExpiredCounter = counter
+	}
+}
+
+// WithAbandonedTasksCancelled configures Supervisor to use counter metrics.
+func WithAbandonedTasksCancelled(counter prometheus.Counter) SupervisorOption {
+	return func(s *Supervisor) {
+		s.abandonedTasksCancelledCounter = counter
+	}
+}
+
+// WithScheduledTasksEnqueued configures Supervisor to use counter metrics.
+func WithScheduledTasksEnqueued(counter prometheus.Counter) SupervisorOption {
+	return func(s *Supervisor) {
+		s.scheduledTasksEnqueuedCounter = counter
+	}
+}
+
+// WithWebhooksDelivered configures Supervisor to use counter metrics.
+func WithWebhooksDelivered(counter prometheus.Counter) SupervisorOption {
+	return func(s *Supervisor) {
+		s.webhooksDeliveredCounter = counter
+	}
+}
+
+// WithWebhookAttemptsFailed configures Supervisor to use counter metrics.
+func WithWebhookAttemptsFailed(counter prometheus.Counter) SupervisorOption {
+	return func(s *Supervisor) {
+		s.webhookAttemptsFailedCounter = counter
+	}
+}
+
+// WithWebhooksFailed configures Supervisor to use counter metrics.
+func WithWebhooksFailed(counter prometheus.Counter) SupervisorOption {
+	return func(s *Supervisor) {
+		s.webhooksFailedCounter = counter
+	}
+}
+
+// WithWebhookSender configures Supervisor to deliver webhooks.
+func WithWebhookSender(sender domain.WebhookSender) SupervisorOption {
+	return func(s *Supervisor) {
+		s.webhookSender = sender
+	}
+}
@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	domain "github.com/freundallein/scheduler/pkg"
+)
+
+// cursor points to the last task of a listed page.
+type cursor struct {
+	OrderBy domain.TaskOrder `json:"o"`
+	Desc    bool             `json:"d,omitempty"`
+	Key     domain.TaskKey   `json:"k"`
+}
+
+// encode returns an opaque cursor representation.
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses an opaque cursor representation.
+func decodeCursor(encoded string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return c, domain.Error{Code: domain.ErrInvalidArgument, Message: "invalid cursor"}
+	}
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return c, domain.Error{Code: domain.ErrInvalidArgument, Message: "invalid cursor"}
+	}
+	return c, nil
+}
+
+// taskKey returns a sort key of a task.
+func taskKey(task *domain.Task, orderBy domain.TaskOrder) domain.TaskKey {
+	key := domain.TaskKey{ID: task.ID}
+	switch orderBy {
+	case domain.OrderByExecuteAt:
+		key.At = task.ExecuteAt
+	case domain.OrderByDoneAt:
+		key.At = task.DoneAt.Time
+	default:
+		key.At = task.CreatedAt
+	}
+	return key
+}
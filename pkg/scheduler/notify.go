@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// queueWaiter is woken up by a notification about any of its queues.
+type queueWaiter struct {
+	ch     chan struct{}
+	queues []string
+}
+
+// queueSignals wakes up waiters of a particular queue,
+// waiters without queues are woken up by notifications about any queue.
+type queueSignals struct {
+	mu      sync.Mutex
+	any     map[*queueWaiter]struct{}
+	waiters map[string]map[*queueWaiter]struct{}
+}
+
+func newQueueSignals() *queueSignals {
+	return &queueSignals{
+		any:     map[*queueWaiter]struct{}{},
+		waiters: map[string]map[*queueWaiter]struct{}{},
+	}
+}
+
+// wait returns a channel, that is closed on the next notification about queues,
+// release should be called, when the channel isn't needed anymore.
+func (s *queueSignals) wait(queues []string) (<-chan struct{}, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	waiter := &queueWaiter{ch: make(chan struct{}), queues: queues}
+	if len(queues) == 0 {
+		s.any[waiter] = struct{}{}
+	}
+	for _, queue := range queues {
+		if s.waiters[queue] == nil {
+			s.waiters[queue] = map[*queueWaiter]struct{}{}
+		}
+		s.waiters[queue][waiter] = struct{}{}
+	}
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.remove(waiter)
+	}
+	return waiter.ch, release
+}
+
+// remove unsubscribes a waiter from all its queues.
+func (s *queueSignals) remove(waiter *queueWaiter) {
+	delete(s.any, waiter)
+	for _, queue := range waiter.queues {
+		delete(s.waiters[queue], waiter)
+		if len(s.waiters[queue]) == 0 {
+			delete(s.waiters, queue)
+		}
+	}
+}
+
+// notify wakes up current waiters of the queue.
+func (s *queueSignals) notify(queue string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	woken := make([]*queueWaiter, 0, len(s.any)+len(s.waiters[queue]))
+	for waiter := range s.any {
+		woken = append(woken, waiter)
+	}
+	for waiter := range s.waiters[queue] {
+		woken = append(woken, waiter)
+	}
+	for _, waiter := range woken {
+		close(waiter.ch)
+		s.remove(waiter)
+	}
+}
+
+// signal is shared by waiters of the same task.
+type signal struct {
+	ch   chan struct{}
+	refs int
+}
+
+// taskSignals wakes up waiters of a particular task.
+type taskSignals struct {
+	mu      sync.Mutex
+	signals map[uuid.UUID]*signal
+}
+
+func newTaskSignals() *taskSignals {
+	return &taskSignals{signals: map[uuid.UUID]*signal{}}
+}
+
+// wait returns a channel, that is closed on the next notification about the task,
+// release should be called, when the channel isn't needed anymore.
+func (s *taskSignals) wait(id uuid.UUID) (<-chan struct{}, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sig, ok := s.signals[id]
+	if !ok {
+		sig = &signal{ch: make(chan struct{})}
+		s.signals[id] = sig
+	}
+	sig.refs++
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		sig.refs--
+		if sig.refs == 0 && s.signals[id] == sig {
+			delete(s.signals, id)
+		}
+	}
+	return sig.ch, release
+}
+
+// notify wakes up current waiters of the task.
+func (s *taskSignals) notify(id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sig, ok := s.signals[id]
+	if !ok {
+		return
+	}
+	close(sig.ch)
+	delete(s.signals, id)
+}
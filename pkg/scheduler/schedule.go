@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// scheduledAtPlaceholder is replaced with a tick time in payload template strings.
+	scheduledAtPlaceholder = "{{scheduledAt}}"
+	// maxMissedTicks limits amount of missed ticks enqueued at once with domain.MisfireAll.
+	maxMissedTicks = 100
+	// replanDelay postpones a schedule, that can't be planned, so it doesn't block others.
+	replanDelay = time.Hour
+)
+
+var cronParser = cron.NewParser(
+	cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+// cronSchedule is a schedule with parsed cron expression and timezone.
+type cronSchedule struct {
+	*domain.Schedule
+	expression cron.Schedule
+	location   *time.Location
+}
+
+// parseSchedule parses schedule's cron expression and timezone.
+func parseSchedule(schedule *domain.Schedule) (*cronSchedule, error) {
+	expression, err := cronParser.Parse(schedule.Cron)
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return &cronSchedule{Schedule: schedule, expression: expression, location: location}, nil
+}
+
+// next returns the first schedule tick after `after`.
+func (schedule *cronSchedule) next(after time.Time) (time.Time, error) {
+	next := schedule.expression.Next(after.In(schedule.location))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression has no next run")
+	}
+	return next.UTC(), nil
+}
+
+// nextRun returns the first schedule tick after `after`.
+func nextRun(schedule *domain.Schedule, after time.Time) (time.Time, error) {
+	parsed, err := parseSchedule(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parsed.next(after)
+}
+
+// planTicks returns schedule ticks up to `until`, that should be enqueued,
+// and the first tick, that isn't enqueued yet.
+// Ticks before `misfiredBefore` are treated according to schedule's misfire policy.
+func planTicks(schedule *cronSchedule, until, misfiredBefore time.Time) ([]time.Time, time.Time, error) {
+	var (
+		ticks  []time.Time
+		missed []time.Time
+	)
+	next := schedule.NextRunAt
+	for !next.After(until) {
+		if next.Before(misfiredBefore) {
+			if schedule.MisfirePolicy == domain.MisfireAll && len(missed) == maxMissedTicks {
+				break
+			}
+			missed = append(missed, next)
+		} else {
+			ticks = append(ticks, next)
+		}
+		var err error
+		next, err = schedule.next(next)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	switch schedule.MisfirePolicy {
+	case domain.MisfireAll:
+		ticks = append(missed, ticks...)
+	case domain.MisfireSkip:
+	default:
+		if len(missed) > 0 {
+			ticks = append([]time.Time{missed[len(missed)-1]}, ticks...)
+		}
+	}
+	return ticks, next, nil
+}
+
+// scheduledTask makes a task for the schedule tick.
+// Task ID is derived from the schedule ID and the tick, so a tick is enqueued once.
+func scheduledTask(schedule *domain.Schedule, tick time.Time) *domain.Task {
+	scheduledAt := tick.UTC().Format(time.RFC3339)
+	payload, _ := renderTemplate(schedule.Payload, scheduledAt).(map[string]interface{})
+	return &domain.Task{
+		ID:          uuid.NewSHA1(schedule.ID, []byte(scheduledAt)),
+		ExecuteAt:   tick.UTC(),
+		Deadline:    tick.Add(schedule.TTL).UTC(),
+		Queue:       schedule.Queue,
+		Priority:    schedule.Priority,
+		Payload:     payload,
+		RetryPolicy: schedule.RetryPolicy,
+		Meta: map[string]interface{}{
+			"scheduleId":  schedule.ID,
+			"scheduledAt": scheduledAt,
+		},
+	}
+}
+
+// renderTemplate returns a copy of value with placeholders replaced in every string.
+func renderTemplate(value interface{}, scheduledAt string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return strings.ReplaceAll(v, scheduledAtPlaceholder, scheduledAt)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			rendered[key] = renderTemplate(item, scheduledAt)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for idx, item := range v {
+			rendered[idx] = renderTemplate(item, scheduledAt)
+		}
+		return rendered
+	default:
+		return v
+	}
+}
@@ -0,0 +1,222 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/freundallein/scheduler/pkg/mock"
+	"github.com/google/uuid"
+)
+
+func TestPlanTicks(t *testing.T) {
+	now := time.Date(2021, 10, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		policy        domain.MisfirePolicy
+		nextRunAt     time.Time
+		expectedTicks []time.Time
+		expectedNext  time.Time
+	}{
+		{
+			name:          "on time",
+			policy:        domain.MisfireOnce,
+			nextRunAt:     now,
+			expectedTicks: []time.Time{now},
+			expectedNext:  now.Add(5 * time.Minute),
+		},
+		{
+			name:          "not due",
+			policy:        domain.MisfireOnce,
+			nextRunAt:     now.Add(5 * time.Minute),
+			expectedNext:  now.Add(5 * time.Minute),
+			expectedTicks: nil,
+		},
+		{
+			name:          "misfire once",
+			policy:        domain.MisfireOnce,
+			nextRunAt:     now.Add(-15 * time.Minute),
+			expectedTicks: []time.Time{now.Add(-5 * time.Minute), now},
+			expectedNext:  now.Add(5 * time.Minute),
+		},
+		{
+			name:          "misfire skip",
+			policy:        domain.MisfireSkip,
+			nextRunAt:     now.Add(-15 * time.Minute),
+			expectedTicks: []time.Time{now},
+			expectedNext:  now.Add(5 * time.Minute),
+		},
+		{
+			name:      "misfire all",
+			policy:    domain.MisfireAll,
+			nextRunAt: now.Add(-15 * time.Minute),
+			expectedTicks: []time.Time{
+				now.Add(-15 * time.Minute),
+				now.Add(-10 * time.Minute),
+				now.Add(-5 * time.Minute),
+				now,
+			},
+			expectedNext: now.Add(5 * time.Minute),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := &domain.Schedule{
+				Cron:          "*/5 * * * *",
+				Timezone:      "UTC",
+				MisfirePolicy: tt.policy,
+				NextRunAt:     tt.nextRunAt,
+			}
+			parsed, err := parseSchedule(schedule)
+			if err != nil {
+				t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+			}
+			ticks, next, err := planTicks(parsed, now.Add(10*time.Second), now.Add(-time.Minute))
+			if err != nil {
+				t.Errorf("Expected `%v`, got: `%v`", nil, err)
+			}
+			if len(ticks) != len(tt.expectedTicks) {
+				t.Fatalf("Expected `%v`, got: `%v`", tt.expectedTicks, ticks)
+			}
+			for idx := range ticks {
+				if !ticks[idx].Equal(tt.expectedTicks[idx]) {
+					t.Errorf("Expected `%v`, got: `%v`", tt.expectedTicks[idx], ticks[idx])
+				}
+			}
+			if !next.Equal(tt.expectedNext) {
+				t.Errorf("Expected `%v`, got: `%v`", tt.expectedNext, next)
+			}
+		})
+	}
+}
+
+func TestNextRunTimezone(t *testing.T) {
+	schedule := &domain.Schedule{
+		Cron:     "0 9 * * *",
+		Timezone: "Europe/Moscow",
+	}
+	after := time.Date(2021, 10, 14, 12, 0, 0, 0, time.UTC)
+	expected := time.Date(2021, 10, 15, 6, 0, 0, 0, time.UTC)
+	observed, err := nextRun(schedule, after)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	if !observed.Equal(expected) {
+		t.Errorf("Expected `%v`, got: `%v`", expected, observed)
+	}
+}
+
+func TestScheduledTask(t *testing.T) {
+	tick := time.Date(2021, 10, 14, 12, 0, 0, 0, time.UTC)
+	schedule := &domain.Schedule{
+		ID:    uuid.New(),
+		Queue: "reports",
+		TTL:   time.Hour,
+		Payload: map[string]interface{}{
+			"type": "report",
+			"args": []interface{}{"{{scheduledAt}}"},
+		},
+	}
+	first := scheduledTask(schedule, tick)
+	second := scheduledTask(schedule, tick)
+	if first.ID != second.ID {
+		t.Errorf("Expected `%v`, got: `%v`", first.ID, second.ID)
+	}
+	if !first.Deadline.Equal(tick.Add(time.Hour)) {
+		t.Errorf("Expected `%v`, got: `%v`", tick.Add(time.Hour), first.Deadline)
+	}
+	args := first.Payload["args"].([]interface{})
+	if args[0] != "2021-10-14T12:00:00Z" {
+		t.Errorf("Expected `%v`, got: `%v`", "2021-10-14T12:00:00Z", args[0])
+	}
+	if schedule.Payload["args"].([]interface{})[0] != "{{scheduledAt}}" {
+		t.Errorf("Expected template to be unchanged, got: `%v`", schedule.Payload)
+	}
+}
+
+func TestMaterialiseSchedules(t *testing.T) {
+	now := time.Date(2021, 10, 14, 12, 0, 0, 0, time.UTC)
+	schedule := &domain.Schedule{
+		ID:            uuid.New(),
+		Cron:          "*/5 * * * *",
+		Timezone:      "UTC",
+		TTL:           time.Hour,
+		MisfirePolicy: domain.MisfireOnce,
+		NextRunAt:     now,
+	}
+	advanced := 0
+	supervisor := NewSupervisor(
+		&mock.Gateway{
+			FindDueSchedulesFn: func(until time.Time, limit int) ([]*domain.Schedule, error) {
+				return []*domain.Schedule{schedule}, nil
+			},
+			AdvanceScheduleFn: func(id uuid.UUID, from, to time.Time, tasks []*domain.Task) (int64, error) {
+				advanced++
+				if id != schedule.ID {
+					t.Errorf("Expected `%v`, got: `%v`", schedule.ID, id)
+				}
+				if !from.Equal(now) {
+					t.Errorf("Expected `%v`, got: `%v`", now, from)
+				}
+				if !to.Equal(now.Add(5 * time.Minute)) {
+					t.Errorf("Expected `%v`, got: `%v`", now.Add(5*time.Minute), to)
+				}
+				if len(tasks) != 1 || !tasks[0].ExecuteAt.Equal(now) {
+					t.Errorf("Expected a task at `%v`, got: `%v`", now, tasks)
+				}
+				return int64(len(tasks)), nil
+			},
+		},
+	)
+	rows, err := supervisor.materialiseSchedules(context.Background(), now)
+	if err != nil {
+		t.Errorf("Expected `%v`, got: `%v`", nil, err)
+	}
+	if rows != 1 || advanced != 1 {
+		t.Errorf("Expected `%v`, got: `%v`", 1, rows)
+	}
+}
+
+func TestMaterialiseBrokenSchedule(t *testing.T) {
+	now := time.Date(2021, 10, 14, 12, 0, 0, 0, time.UTC)
+	broken := &domain.Schedule{
+		ID:        uuid.New(),
+		Cron:      "*/5 * * * *",
+		Timezone:  "Mars/Olympus",
+		NextRunAt: now,
+	}
+	valid := &domain.Schedule{
+		ID:        uuid.New(),
+		Cron:      "*/5 * * * *",
+		Timezone:  "UTC",
+		NextRunAt: now,
+	}
+	advanced := map[uuid.UUID]time.Time{}
+	supervisor := NewSupervisor(&mock.Gateway{
+		FindDueSchedulesFn: func(until time.Time, limit int) ([]*domain.Schedule, error) {
+			return []*domain.Schedule{broken, valid}, nil
+		},
+		AdvanceScheduleFn: func(id uuid.UUID, from, to time.Time, tasks []*domain.Task) (int64, error) {
+			advanced[id] = to
+			if id == broken.ID && len(tasks) != 0 {
+				t.Errorf("Expected no tasks of broken schedule, got: `%v`", tasks)
+			}
+			return int64(len(tasks)), nil
+		},
+	})
+	rows, err := supervisor.materialiseSchedules(context.Background(), now)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	if rows != 1 {
+		t.Errorf("Expected `%v`, got: `%v`", 1, rows)
+	}
+	if expected := now.Add(replanDelay); !advanced[broken.ID].Equal(expected) {
+		t.Errorf("Expected `%v`, got: `%v`", expected, advanced[broken.ID])
+	}
+	if expected := now.Add(5 * time.Minute); !advanced[valid.ID].Equal(expected) {
+		t.Errorf("Expected `%v`, got: `%v`", expected, advanced[valid.ID])
+	}
+}
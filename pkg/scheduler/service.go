@@ -2,6 +2,9 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 
 	domain "github.com/freundallein/scheduler/pkg"
@@ -17,12 +20,34 @@ type Service struct {
 	tasksClaimed      prometheus.Counter
 	tasksSucceeded    prometheus.Counter
 	tasksFailed       prometheus.Counter
+	tasksCancelled    prometheus.Counter
+
+	minLease     time.Duration
+	maxLease     time.Duration
+	defaultLease time.Duration
+	// priorityAging is applied at claim time, so every replica ages tasks the same way.
+	priorityAging time.Duration
+
+	claimable *queueSignals
+	finished  *taskSignals
 }
 
 // New returns domain.Scheduler & domain.Worker implementation.
 func New(taskGateway domain.Gateway, opts ...Option) *Service {
 	svc := &Service{
-		taskGateway: taskGateway,
+		taskGateway:       taskGateway,
+		tasksEnqueued:     prometheus.NewCounter(prometheus.CounterOpts{Name: "tasks_enqueued_total"}),
+		taskRequestPolled: prometheus.NewCounter(prometheus.CounterOpts{Name: "tasks_polled_total"}),
+		tasksClaimed:      prometheus.NewCounter(prometheus.CounterOpts{Name: "tasks_claimed_total"}),
+		tasksSucceeded:    prometheus.NewCounter(prometheus.CounterOpts{Name: "tasks_succeeded_total"}),
+		tasksFailed:       prometheus.NewCounter(prometheus.CounterOpts{Name: "tasks_failed_total"}),
+		tasksCancelled:    prometheus.NewCounter(prometheus.CounterOpts{Name: "tasks_cancelled_total"}),
+		minLease:          10 * time.Second,
+		maxLease:          time.Hour,
+		defaultLease:      time.Minute,
+		priorityAging:     time.Minute,
+		claimable:         newQueueSignals(),
+		finished:          newTaskSignals(),
 	}
 	for _, opt := range opts {
 		opt(svc)
@@ -32,24 +57,124 @@ func New(taskGateway domain.Gateway, opts ...Option) *Service {
 
 // Set allows to enqueue task.
 func (svc *Service) Set(ctx context.Context, task *domain.Task) (*domain.Task, error) {
+	if task.Queue == "" {
+		task.Queue = domain.DefaultQueue
+	}
 	svc.tasksEnqueued.Inc()
 	return svc.taskGateway.Create(ctx, task)
 }
 
+// SetMany allows to enqueue tasks atomically, duplicates are reported per task.
+func (svc *Service) SetMany(ctx context.Context, tasks []*domain.Task) ([]domain.BatchResult, error) {
+	for _, task := range tasks {
+		if task.Queue == "" {
+			task.Queue = domain.DefaultQueue
+		}
+	}
+	results, err := svc.taskGateway.CreateMany(ctx, tasks)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if result.Err == nil {
+			svc.tasksEnqueued.Inc()
+		}
+	}
+	return results, nil
+}
+
 // Get allows to poll a task state.
 func (svc *Service) Get(ctx context.Context, id uuid.UUID) (*domain.Task, error) {
 	svc.taskRequestPolled.Inc()
 	return svc.taskGateway.FindByID(ctx, id)
 }
 
+// Cancel allows to withdraw a task.
+func (svc *Service) Cancel(ctx context.Context, id uuid.UUID) (*domain.Task, error) {
+	task, changed, err := svc.taskGateway.Cancel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		svc.tasksCancelled.Inc()
+	}
+	return task, nil
+}
+
+// Wait blocks until a task is finished or timeout expires, returns the task's last state.
+func (svc *Service) Wait(ctx context.Context, id uuid.UUID, timeout time.Duration) (*domain.Task, error) {
+	svc.taskRequestPolled.Inc()
+	// Subscribe before polling, so a task finished in between isn't missed.
+	finished, release := svc.finished.wait(id)
+	defer release()
+	task, err := svc.taskGateway.FindByID(ctx, id)
+	if err != nil || task.State.Finished() || timeout <= 0 {
+		return task, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-finished:
+	case <-timer.C:
+	}
+	return svc.taskGateway.FindByID(ctx, id)
+}
+
 // Claim gives a task to worker.
-func (svc *Service) Claim(ctx context.Context, amount int) ([]*domain.Task, error) {
-	tasks, err := svc.taskGateway.ClaimPending(ctx, amount)
+// If there are no pending tasks, it waits for them up to request.Wait.
+func (svc *Service) Claim(ctx context.Context, request domain.ClaimRequest) ([]*domain.Task, error) {
+	lease, err := svc.lease(request.Lease)
 	if err != nil {
 		return nil, err
 	}
-	svc.tasksClaimed.Add(float64(len(tasks)))
-	return tasks, nil
+	request.Lease = lease
+	request.Aging = svc.priorityAging
+	waitUntil := time.Now().Add(request.Wait)
+	for {
+		// Subscribe before claiming, so a task created in between isn't missed.
+		claimable, release := svc.claimable.wait(request.Queues)
+		tasks, err := svc.taskGateway.ClaimPending(ctx, request)
+		if err == nil {
+			release()
+			svc.tasksClaimed.Add(float64(len(tasks)))
+			return tasks, nil
+		}
+		if domain.ErrorCode(err) != domain.ErrNoPendingTasks || !time.Now().Before(waitUntil) {
+			release()
+			return nil, err
+		}
+		wakeAt := waitUntil
+		next, nextErr := svc.taskGateway.NextExecuteAt(ctx, request.Queues)
+		if nextErr == nil && next.Before(wakeAt) {
+			wakeAt = next
+		}
+		timer := time.NewTimer(time.Until(wakeAt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			release()
+			return nil, ctx.Err()
+		case <-claimable:
+			timer.Stop()
+		case <-timer.C:
+		}
+		release()
+	}
+}
+
+// Listen wakes up claimers of a queue, when its tasks become claimable,
+// and waiters of a task, when it's finished.
+// It blocks until ctx is done.
+func (svc *Service) Listen(ctx context.Context) error {
+	return svc.taskGateway.ListenTasks(ctx, func(event domain.TaskEvent) {
+		if event.State.Finished() {
+			svc.finished.notify(event.ID)
+			return
+		}
+		svc.claimable.notify(event.Queue)
+	})
 }
 
 // Succeed marks a task as done.
@@ -63,3 +188,167 @@ func (svc *Service) Fail(ctx context.Context, id, claimID uuid.UUID, reason stri
 	svc.tasksFailed.Inc()
 	return svc.taskGateway.MarkAsFailed(ctx, id, claimID, reason)
 }
+
+// SucceedMany marks tasks as done, outcomes are reported per task.
+func (svc *Service) SucceedMany(ctx context.Context, outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	results, err := svc.taskGateway.MarkManyAsSucceeded(ctx, outcomes)
+	svc.tasksSucceeded.Add(accepted(results))
+	return results, err
+}
+
+// FailMany marks tasks as failed, outcomes are reported per task.
+func (svc *Service) FailMany(ctx context.Context, outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+	results, err := svc.taskGateway.MarkManyAsFailed(ctx, outcomes)
+	svc.tasksFailed.Add(accepted(results))
+	return results, err
+}
+
+// accepted counts batch results without errors.
+func accepted(results []domain.BatchResult) float64 {
+	var count float64
+	for _, result := range results {
+		if result.Err == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// Heartbeat prolongs a task lease, returns the new lease expiration time.
+func (svc *Service) Heartbeat(ctx context.Context, id, claimID uuid.UUID, lease time.Duration) (time.Time, error) {
+	lease, err := svc.lease(lease)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return svc.taskGateway.ExtendLease(ctx, id, claimID, lease)
+}
+
+// Release gives a claimed task back unprocessed, so it's claimed again at once.
+func (svc *Service) Release(ctx context.Context, id, claimID uuid.UUID) error {
+	return svc.taskGateway.ReleaseTask(ctx, id, claimID)
+}
+
+// lease validates requested lease duration, zero means the default one.
+func (svc *Service) lease(lease time.Duration) (time.Duration, error) {
+	if lease == 0 {
+		return svc.defaultLease, nil
+	}
+	if lease < svc.minLease || lease > svc.maxLease {
+		return 0, domain.Error{Code: domain.ErrInvalidArgument, Message: fmt.Sprintf("lease should be between %v and %v", svc.minLease, svc.maxLease)}
+	}
+	return lease, nil
+}
+
+// ListTasks returns a page of filtered tasks.
+// Pages are linked with opaque cursors, so tasks aren't skipped, when new ones are enqueued.
+func (svc *Service) ListTasks(ctx context.Context, request domain.ListTasksRequest) (*domain.TaskPage, error) {
+	if request.Limit <= 0 {
+		return nil, domain.Error{Code: domain.ErrInvalidArgument, Message: "limit should be positive"}
+	}
+	if request.OrderBy == "" {
+		request.OrderBy = domain.OrderByCreatedAt
+	}
+	switch request.OrderBy {
+	case domain.OrderByCreatedAt, domain.OrderByExecuteAt, domain.OrderByDoneAt:
+	default:
+		return nil, domain.Error{Code: domain.ErrInvalidArgument, Message: fmt.Sprintf("unknown order %q", request.OrderBy)}
+	}
+	query := domain.TaskQuery{
+		Filter:  request.Filter,
+		OrderBy: request.OrderBy,
+		Desc:    request.Desc,
+		// One more task shows, whether there is the next page.
+		Limit: request.Limit + 1,
+	}
+	if request.Cursor != "" {
+		after, err := decodeCursor(request.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if after.OrderBy != request.OrderBy || after.Desc != request.Desc {
+			return nil, domain.Error{Code: domain.ErrInvalidArgument, Message: "cursor doesn't match the order"}
+		}
+		query.After = &after.Key
+	}
+	tasks, err := svc.taskGateway.ListTasks(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	page := &domain.TaskPage{Tasks: tasks}
+	if len(tasks) > request.Limit {
+		page.Tasks = tasks[:request.Limit]
+		page.NextCursor = cursor{
+			OrderBy: request.OrderBy,
+			Desc:    request.Desc,
+			Key:     taskKey(page.Tasks[request.Limit-1], request.OrderBy),
+		}.encode()
+	}
+	return page, nil
+}
+
+// ListDeadLetters returns dead letters ordered by death time.
+func (svc *Service) ListDeadLetters(ctx context.Context, limit, offset int) ([]*domain.DeadLetter, error) {
+	return svc.taskGateway.ListDeadLetters(ctx, limit, offset)
+}
+
+// GetDeadLetter returns a dead letter by task id.
+func (svc *Service) GetDeadLetter(ctx context.Context, id uuid.UUID) (*domain.DeadLetter, error) {
+	return svc.taskGateway.FindDeadLetterByID(ctx, id)
+}
+
+// RequeueDeadLetter moves a dead letter back to pending tasks.
+func (svc *Service) RequeueDeadLetter(ctx context.Context, id uuid.UUID) (*domain.Task, error) {
+	task, err := svc.taskGateway.RequeueDeadLetter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	svc.tasksEnqueued.Inc()
+	return task, nil
+}
+
+// PurgeDeadLetters removes dead letters.
+func (svc *Service) PurgeDeadLetters(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	return svc.taskGateway.PurgeDeadLetters(ctx, ids)
+}
+
+// CreateSchedule registers a recurring task.
+func (svc *Service) CreateSchedule(ctx context.Context, schedule *domain.Schedule) (*domain.Schedule, error) {
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+	if schedule.Queue == "" {
+		schedule.Queue = domain.DefaultQueue
+	}
+	if schedule.MisfirePolicy == "" {
+		schedule.MisfirePolicy = domain.MisfireOnce
+	}
+	switch schedule.MisfirePolicy {
+	case domain.MisfireSkip, domain.MisfireOnce, domain.MisfireAll:
+	default:
+		return nil, domain.Error{Code: domain.ErrInvalidArgument, Message: fmt.Sprintf("unknown misfire policy %q", schedule.MisfirePolicy)}
+	}
+	if schedule.TTL <= 0 {
+		return nil, domain.Error{Code: domain.ErrInvalidArgument, Message: "ttl should be positive"}
+	}
+	next, err := nextRun(schedule, time.Now())
+	if err != nil {
+		return nil, domain.Error{Code: domain.ErrInvalidArgument, Message: err.Error(), Inner: err}
+	}
+	schedule.NextRunAt = next
+	return svc.taskGateway.CreateSchedule(ctx, schedule)
+}
+
+// GetSchedule returns a schedule by id.
+func (svc *Service) GetSchedule(ctx context.Context, id uuid.UUID) (*domain.Schedule, error) {
+	return svc.taskGateway.FindScheduleByID(ctx, id)
+}
+
+// ListSchedules returns schedules ordered by creation time.
+func (svc *Service) ListSchedules(ctx context.Context, limit, offset int) ([]*domain.Schedule, error) {
+	return svc.taskGateway.ListSchedules(ctx, limit, offset)
+}
+
+// DeleteSchedule stops a recurring task, enqueued tasks are kept.
+func (svc *Service) DeleteSchedule(ctx context.Context, id uuid.UUID) error {
+	return svc.taskGateway.DeleteSchedule(ctx, id)
+}
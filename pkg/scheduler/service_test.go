@@ -7,22 +7,33 @@ import (
 	domain "github.com/freundallein/scheduler/pkg"
 	"github.com/freundallein/scheduler/pkg/mock"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"testing"
+	"time"
 )
 
 var errExpected = errors.New("expected error")
 
 func TestSet(t *testing.T) {
 	tests := []struct {
-		name        string
-		expectedErr error
-		task        *domain.Task
-		expectedID  uuid.UUID
+		name          string
+		expectedErr   error
+		task          *domain.Task
+		expectedID    uuid.UUID
+		expectedQueue string
 	}{
 		{
-			name:       "normal case",
-			task:       &domain.Task{},
-			expectedID: uuid.New(),
+			name:          "normal case",
+			task:          &domain.Task{},
+			expectedID:    uuid.New(),
+			expectedQueue: domain.DefaultQueue,
+		},
+		{
+			name:          "named queue case",
+			task:          &domain.Task{Queue: "pdf"},
+			expectedID:    uuid.New(),
+			expectedQueue: "pdf",
 		},
 		{
 			name:        "error case",
@@ -62,6 +73,85 @@ func TestSet(t *testing.T) {
 			if observed.ID != tt.expectedID {
 				t.Errorf("Expected `%v`, got: `%v`", tt.expectedID, observed.ID)
 			}
+			if observed.Queue != tt.expectedQueue {
+				t.Errorf("Expected `%v`, got: `%v`", tt.expectedQueue, observed.Queue)
+			}
+		})
+	}
+}
+
+func TestSetMany(t *testing.T) {
+	duplicateID := uuid.New()
+	tests := []struct {
+		name           string
+		tasks          []*domain.Task
+		gatewayErr     error
+		expectedErr    error
+		expectedCodes  []string
+		expectedQueues []string
+	}{
+		{
+			name:           "normal case",
+			tasks:          []*domain.Task{{ID: uuid.New()}, {ID: uuid.New(), Queue: "pdf"}},
+			expectedCodes:  []string{"", ""},
+			expectedQueues: []string{domain.DefaultQueue, "pdf"},
+		},
+		{
+			name:           "duplicate case",
+			tasks:          []*domain.Task{{ID: uuid.New()}, {ID: duplicateID}},
+			expectedCodes:  []string{"", domain.ErrDuplicateTask},
+			expectedQueues: []string{domain.DefaultQueue, domain.DefaultQueue},
+		},
+		{
+			name:           "error case",
+			tasks:          []*domain.Task{{ID: uuid.New()}},
+			gatewayErr:     errExpected,
+			expectedErr:    errExpected,
+			expectedQueues: []string{domain.DefaultQueue},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheduler := New(
+				&mock.Gateway{
+					CreateManyFn: func(tasks []*domain.Task) ([]domain.BatchResult, error) {
+						if tt.gatewayErr != nil {
+							return nil, tt.gatewayErr
+						}
+						results := make([]domain.BatchResult, 0, len(tasks))
+						for _, task := range tasks {
+							result := domain.BatchResult{ID: task.ID}
+							if task.ID == duplicateID {
+								result.Err = domain.Error{Code: domain.ErrDuplicateTask}
+							}
+							results = append(results, result)
+						}
+						return results, nil
+					},
+				},
+			)
+			ctx := context.Background()
+			observed, err := scheduler.SetMany(ctx, tt.tasks)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("Expected `%v`, got: `%v`", tt.expectedErr, err)
+			}
+			for i, task := range tt.tasks {
+				if task.Queue != tt.expectedQueues[i] {
+					t.Errorf("Expected `%v`, got: `%v`", tt.expectedQueues[i], task.Queue)
+				}
+			}
+			if len(observed) != len(tt.expectedCodes) {
+				t.Fatalf("Expected `%v` results, got: `%v`", len(tt.expectedCodes), len(observed))
+			}
+			for i, result := range observed {
+				if result.ID != tt.tasks[i].ID {
+					t.Errorf("Expected `%v`, got: `%v`", tt.tasks[i].ID, result.ID)
+				}
+				if domain.ErrorCode(result.Err) != tt.expectedCodes[i] {
+					t.Errorf("Expected `%v`, got: `%v`", tt.expectedCodes[i], result.Err)
+				}
+			}
 		})
 	}
 }
@@ -123,3 +213,503 @@ func TestGet(t *testing.T) {
 		})
 	}
 }
+
+func TestCancel(t *testing.T) {
+	expectedUUID := uuid.New()
+	tests := []struct {
+		name              string
+		expectedErr       error
+		task              *domain.Task
+		changed           bool
+		expectedState     domain.State
+		expectedCancelled float64
+	}{
+		{
+			name: "normal case",
+			task: &domain.Task{
+				ID:    expectedUUID,
+				State: domain.StateCancelled,
+			},
+			changed:           true,
+			expectedState:     domain.StateCancelled,
+			expectedCancelled: 1,
+		},
+		{
+			name: "processing case",
+			task: &domain.Task{
+				ID:              expectedUUID,
+				State:           domain.StateProcessing,
+				CancelRequested: true,
+			},
+			changed:           true,
+			expectedState:     domain.StateProcessing,
+			expectedCancelled: 1,
+		},
+		{
+			name: "repeated case",
+			task: &domain.Task{
+				ID:    expectedUUID,
+				State: domain.StateCancelled,
+			},
+			expectedState: domain.StateCancelled,
+		},
+		{
+			name:        "error case",
+			expectedErr: errExpected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cancelled := prometheus.NewCounter(prometheus.CounterOpts{Name: "tasks_cancelled_total"})
+			scheduler := New(
+				&mock.Gateway{
+					CancelFn: func(id uuid.UUID) (*domain.Task, bool, error) {
+						if id != expectedUUID {
+							t.Errorf("Expected: `%v`, got: `%v`", expectedUUID, id)
+						}
+						if tt.expectedErr != nil {
+							return nil, false, tt.expectedErr
+						}
+						return tt.task, tt.changed, nil
+					},
+				},
+				WithTasksCancelled(cancelled),
+			)
+			ctx := context.Background()
+			observed, err := scheduler.Cancel(ctx, expectedUUID)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("Expected `%v`, got: `%v`", tt.expectedErr, err)
+			}
+			if value := testutil.ToFloat64(cancelled); value != tt.expectedCancelled {
+				t.Errorf("Expected `%v` cancelled tasks, got: `%v`", tt.expectedCancelled, value)
+			}
+			if observed == nil {
+				return
+			}
+			if observed.State != tt.expectedState {
+				t.Errorf("Expected `%v`, got: `%v`", tt.expectedState, observed.State)
+			}
+		})
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	leasedUntil := time.Now().Add(time.Minute)
+	tests := []struct {
+		name          string
+		lease         time.Duration
+		expectedLease time.Duration
+		expectedErr   bool
+	}{
+		{
+			name:          "default lease",
+			expectedLease: time.Minute,
+		},
+		{
+			name:          "requested lease",
+			lease:         5 * time.Minute,
+			expectedLease: 5 * time.Minute,
+		},
+		{
+			name:        "too short lease",
+			lease:       time.Second,
+			expectedErr: true,
+		},
+		{
+			name:        "too long lease",
+			lease:       2 * time.Hour,
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheduler := New(
+				&mock.Gateway{
+					ExtendLeaseFn: func(id, claimID uuid.UUID, lease time.Duration) (time.Time, error) {
+						if lease != tt.expectedLease {
+							t.Errorf("Expected `%v`, got: `%v`", tt.expectedLease, lease)
+						}
+						return leasedUntil, nil
+					},
+				},
+				WithLeaseBounds(10*time.Second, time.Hour),
+			)
+			ctx := context.Background()
+			observed, err := scheduler.Heartbeat(ctx, uuid.New(), uuid.New(), tt.lease)
+			if (err != nil) != tt.expectedErr {
+				t.Errorf("Expected error: `%v`, got: `%v`", tt.expectedErr, err)
+			}
+			if err == nil && !observed.Equal(leasedUntil) {
+				t.Errorf("Expected `%v`, got: `%v`", leasedUntil, observed)
+			}
+		})
+	}
+}
+
+func TestClaimWait(t *testing.T) {
+	expectedTasks := []*domain.Task{{ID: uuid.New()}}
+	notified := make(chan func(event domain.TaskEvent), 1)
+	claims := 0
+	scheduler := New(
+		&mock.Gateway{
+			ClaimPendingFn: func(request domain.ClaimRequest) ([]*domain.Task, error) {
+				claims++
+				if claims == 1 {
+					return nil, domain.Error{Code: domain.ErrNoPendingTasks}
+				}
+				return expectedTasks, nil
+			},
+			NextExecuteAtFn: func(queues []string) (time.Time, error) {
+				return time.Time{}, domain.Error{Code: domain.ErrNoPendingTasks}
+			},
+			ListenTasksFn: func(ctx context.Context, notify func(event domain.TaskEvent)) error {
+				notified <- notify
+				<-ctx.Done()
+				return nil
+			},
+		},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go scheduler.Listen(ctx)
+	notify := <-notified
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		notify(domain.TaskEvent{ID: uuid.New(), Queue: domain.DefaultQueue, State: domain.StatePending})
+	}()
+	started := time.Now()
+	observed, err := scheduler.Claim(ctx, domain.ClaimRequest{Amount: 1, Wait: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	if len(observed) != 1 || observed[0].ID != expectedTasks[0].ID {
+		t.Errorf("Expected `%v`, got: `%v`", expectedTasks, observed)
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Errorf("Expected claim to be woken up by notification, waited: `%v`", time.Since(started))
+	}
+}
+
+func TestClaimWaitTimeout(t *testing.T) {
+	scheduler := New(
+		&mock.Gateway{
+			ClaimPendingFn: func(request domain.ClaimRequest) ([]*domain.Task, error) {
+				return nil, domain.Error{Code: domain.ErrNoPendingTasks}
+			},
+			NextExecuteAtFn: func(queues []string) (time.Time, error) {
+				return time.Time{}, domain.Error{Code: domain.ErrNoPendingTasks}
+			},
+		},
+	)
+	ctx := context.Background()
+	_, err := scheduler.Claim(ctx, domain.ClaimRequest{Amount: 1, Wait: 50 * time.Millisecond})
+	if domain.ErrorCode(err) != domain.ErrNoPendingTasks {
+		t.Errorf("Expected `%v`, got: `%v`", domain.ErrNoPendingTasks, err)
+	}
+}
+
+func TestClaimWaitOtherQueue(t *testing.T) {
+	notified := make(chan func(event domain.TaskEvent), 1)
+	claims := 0
+	scheduler := New(
+		&mock.Gateway{
+			ClaimPendingFn: func(request domain.ClaimRequest) ([]*domain.Task, error) {
+				claims++
+				return nil, domain.Error{Code: domain.ErrNoPendingTasks}
+			},
+			NextExecuteAtFn: func(queues []string) (time.Time, error) {
+				return time.Time{}, domain.Error{Code: domain.ErrNoPendingTasks}
+			},
+			ListenTasksFn: func(ctx context.Context, notify func(event domain.TaskEvent)) error {
+				notified <- notify
+				<-ctx.Done()
+				return nil
+			},
+		},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go scheduler.Listen(ctx)
+	notify := <-notified
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		notify(domain.TaskEvent{ID: uuid.New(), Queue: "other", State: domain.StatePending})
+	}()
+	_, err := scheduler.Claim(ctx, domain.ClaimRequest{Amount: 1, Queues: []string{"pdf"}, Wait: 100 * time.Millisecond})
+	if domain.ErrorCode(err) != domain.ErrNoPendingTasks {
+		t.Errorf("Expected `%v`, got: `%v`", domain.ErrNoPendingTasks, err)
+	}
+	if claims != 2 {
+		t.Errorf("Expected claim to be retried on timeout only, got `%v` claims", claims)
+	}
+}
+
+func TestClaimWaitCancelled(t *testing.T) {
+	scheduler := New(
+		&mock.Gateway{
+			ClaimPendingFn: func(request domain.ClaimRequest) ([]*domain.Task, error) {
+				return nil, domain.Error{Code: domain.ErrNoPendingTasks}
+			},
+			NextExecuteAtFn: func(queues []string) (time.Time, error) {
+				return time.Time{}, domain.Error{Code: domain.ErrNoPendingTasks}
+			},
+		},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := scheduler.Claim(ctx, domain.ClaimRequest{Amount: 1, Wait: 10 * time.Second})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected `%v`, got: `%v`", context.DeadlineExceeded, err)
+	}
+}
+
+func TestClaimAging(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected time.Duration
+	}{
+		{name: "default", expected: time.Minute},
+		{name: "configured", opts: []Option{WithPriorityAging(time.Hour)}, expected: time.Hour},
+		{name: "disabled", opts: []Option{WithPriorityAging(0)}, expected: 0},
+		{name: "negative", opts: []Option{WithPriorityAging(-time.Hour)}, expected: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var observed time.Duration
+			scheduler := New(
+				&mock.Gateway{
+					ClaimPendingFn: func(request domain.ClaimRequest) ([]*domain.Task, error) {
+						observed = request.Aging
+						return []*domain.Task{{ID: uuid.New()}}, nil
+					},
+				},
+				tt.opts...,
+			)
+			_, err := scheduler.Claim(context.Background(), domain.ClaimRequest{Amount: 1, Aging: 42 * time.Second})
+			if err != nil {
+				t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+			}
+			if observed != tt.expected {
+				t.Errorf("Expected `%v`, got: `%v`", tt.expected, observed)
+			}
+		})
+	}
+}
+
+func TestWait(t *testing.T) {
+	testCases := []struct {
+		name          string
+		states        []domain.State
+		notify        bool
+		timeout       time.Duration
+		expectedState domain.State
+		expectedPolls int
+	}{
+		{
+			name:          "already finished",
+			states:        []domain.State{domain.StateSucceeded},
+			timeout:       10 * time.Second,
+			expectedState: domain.StateSucceeded,
+			expectedPolls: 1,
+		},
+		{
+			name:          "finished while waiting",
+			states:        []domain.State{domain.StateProcessing, domain.StateSucceeded},
+			notify:        true,
+			timeout:       10 * time.Second,
+			expectedState: domain.StateSucceeded,
+			expectedPolls: 2,
+		},
+		{
+			name:          "timeout",
+			states:        []domain.State{domain.StatePending, domain.StatePending},
+			timeout:       50 * time.Millisecond,
+			expectedState: domain.StatePending,
+			expectedPolls: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			id := uuid.New()
+			polls := 0
+			notified := make(chan func(event domain.TaskEvent), 1)
+			scheduler := New(
+				&mock.Gateway{
+					FindByIDFn: func(id uuid.UUID) (*domain.Task, error) {
+						state := tc.states[polls]
+						polls++
+						return &domain.Task{ID: id, State: state}, nil
+					},
+					ListenTasksFn: func(ctx context.Context, notify func(event domain.TaskEvent)) error {
+						notified <- notify
+						<-ctx.Done()
+						return nil
+					},
+				},
+			)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go scheduler.Listen(ctx)
+			notify := <-notified
+			if tc.notify {
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					notify(domain.TaskEvent{ID: uuid.New(), State: domain.StateSucceeded})
+					notify(domain.TaskEvent{ID: id, State: domain.StateSucceeded})
+				}()
+			}
+			started := time.Now()
+			observed, err := scheduler.Wait(ctx, id, tc.timeout)
+			if err != nil {
+				t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+			}
+			if observed.State != tc.expectedState {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expectedState, observed.State)
+			}
+			if polls != tc.expectedPolls {
+				t.Errorf("Expected `%v` polls, got: `%v`", tc.expectedPolls, polls)
+			}
+			if time.Since(started) > 5*time.Second {
+				t.Errorf("Expected wait to be woken up, waited: `%v`", time.Since(started))
+			}
+		})
+	}
+}
+
+func TestSucceedMany(t *testing.T) {
+	staleID := uuid.New()
+	tests := []struct {
+		name              string
+		outcomes          []domain.Outcome
+		gatewayErr        error
+		expectedErr       error
+		expectedCodes     []string
+		expectedSucceeded float64
+	}{
+		{
+			name:              "normal case",
+			outcomes:          []domain.Outcome{{ID: uuid.New(), ClaimID: uuid.New()}, {ID: uuid.New(), ClaimID: uuid.New()}},
+			expectedCodes:     []string{"", ""},
+			expectedSucceeded: 2,
+		},
+		{
+			name:              "stale claim case",
+			outcomes:          []domain.Outcome{{ID: staleID, ClaimID: uuid.New()}, {ID: uuid.New(), ClaimID: uuid.New()}},
+			expectedCodes:     []string{domain.ErrStaleResult, ""},
+			expectedSucceeded: 1,
+		},
+		{
+			name:        "error case",
+			outcomes:    []domain.Outcome{{ID: uuid.New(), ClaimID: uuid.New()}},
+			gatewayErr:  errExpected,
+			expectedErr: errExpected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			succeeded := prometheus.NewCounter(prometheus.CounterOpts{Name: "tasks_succeeded"})
+			scheduler := New(
+				&mock.Gateway{
+					MarkManyAsSucceededFn: func(outcomes []domain.Outcome) ([]domain.BatchResult, error) {
+						if tt.gatewayErr != nil {
+							return nil, tt.gatewayErr
+						}
+						results := make([]domain.BatchResult, 0, len(outcomes))
+						for _, outcome := range outcomes {
+							result := domain.BatchResult{ID: outcome.ID}
+							if outcome.ID == staleID {
+								result.Err = domain.Error{Code: domain.ErrStaleResult}
+							}
+							results = append(results, result)
+						}
+						return results, nil
+					},
+				},
+				WithTasksSucceeded(succeeded),
+			)
+			ctx := context.Background()
+			observed, err := scheduler.SucceedMany(ctx, tt.outcomes)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("Expected `%v`, got: `%v`", tt.expectedErr, err)
+			}
+			if observed := testutil.ToFloat64(succeeded); observed != tt.expectedSucceeded {
+				t.Errorf("Expected `%v` succeeded, got: `%v`", tt.expectedSucceeded, observed)
+			}
+			if len(observed) != len(tt.expectedCodes) {
+				t.Fatalf("Expected `%v` results, got: `%v`", len(tt.expectedCodes), len(observed))
+			}
+			for i, result := range observed {
+				if domain.ErrorCode(result.Err) != tt.expectedCodes[i] {
+					t.Errorf("Expected `%v`, got: `%v`", tt.expectedCodes[i], result.Err)
+				}
+			}
+		})
+	}
+}
+
+func TestListTasks(t *testing.T) {
+	created := time.Date(2021, 10, 14, 12, 0, 0, 0, time.UTC)
+	tasks := make([]*domain.Task, 0, 5)
+	for i := 0; i < 5; i++ {
+		tasks = append(tasks, &domain.Task{ID: uuid.New(), CreatedAt: created.Add(time.Duration(i) * time.Minute)})
+	}
+	scheduler := New(
+		&mock.Gateway{
+			ListTasksFn: func(query domain.TaskQuery) ([]*domain.Task, error) {
+				if query.OrderBy != domain.OrderByCreatedAt {
+					t.Errorf("Expected `%v`, got: `%v`", domain.OrderByCreatedAt, query.OrderBy)
+				}
+				start := 0
+				if query.After != nil {
+					for i, task := range tasks {
+						if task.ID == query.After.ID && task.CreatedAt.Equal(query.After.At) {
+							start = i + 1
+						}
+					}
+				}
+				end := start + query.Limit
+				if end > len(tasks) {
+					end = len(tasks)
+				}
+				return tasks[start:end], nil
+			},
+		},
+	)
+	ctx := context.Background()
+	var (
+		observed []*domain.Task
+		pages    int
+		cursor   string
+	)
+	for {
+		page, err := scheduler.ListTasks(ctx, domain.ListTasksRequest{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+		}
+		pages++
+		observed = append(observed, page.Tasks...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if pages != 3 {
+		t.Errorf("Expected `%v` pages, got: `%v`", 3, pages)
+	}
+	if len(observed) != len(tasks) {
+		t.Fatalf("Expected `%v` tasks, got: `%v`", len(tasks), len(observed))
+	}
+	for i, task := range observed {
+		if task.ID != tasks[i].ID {
+			t.Errorf("Expected `%v`, got: `%v`", tasks[i].ID, task.ID)
+		}
+	}
+	_, err := scheduler.ListTasks(ctx, domain.ListTasksRequest{Limit: 2, Desc: true, Cursor: cursor})
+	if err == nil {
+		t.Errorf("Expected cursor order mismatch error, got: `%v`", err)
+	}
+}
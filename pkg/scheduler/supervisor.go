@@ -4,6 +4,7 @@ import (
 	"context"
 	log "github.com/freundallein/scheduler/pkg/utils/logging"
 	"github.com/prometheus/client_golang/prometheus"
+	"sync"
 	"time"
 
 	domain "github.com/freundallein/scheduler/pkg"
@@ -11,14 +12,39 @@ import (
 
 // Supervisor implements a domain.Supervisor.
 type Supervisor struct {
-	taskGateway              domain.Gateway
-	staleTasksDeletedCounter prometheus.Counter
+	taskGateway                    domain.Gateway
+	staleTasksDeletedCounter       prometheus.Counter
+	exhaustedTasksMovedCounter     prometheus.Counter
+	overdueTasksExpiredCounter     prometheus.Counter
+	abandonedTasksCancelledCounter prometheus.Counter
+	scheduledTasksEnqueuedCounter  prometheus.Counter
+	webhooksDeliveredCounter       prometheus.Counter
+	webhookAttemptsFailedCounter   prometheus.Counter
+	webhooksFailedCounter          prometheus.Counter
+
+	webhookSender      domain.WebhookSender
+	webhookRetryPolicy *domain.RetryPolicy
 }
 
 // NewSupervisor returns a domain.Supervisor implementation.
 func NewSupervisor(taskGateway domain.Gateway, opts ...SupervisorOption) *Supervisor {
 	svc := &Supervisor{
-		taskGateway: taskGateway,
+		taskGateway:                    taskGateway,
+		staleTasksDeletedCounter:       prometheus.NewCounter(prometheus.CounterOpts{Name: "stale_tasks_deleted"}),
+		exhaustedTasksMovedCounter:     prometheus.NewCounter(prometheus.CounterOpts{Name: "exhausted_tasks_moved"}),
+		overdueTasksExpiredCounter:     prometheus.NewCounter(prometheus.CounterOpts{Name: "overdue_tasks_expired"}),
+		abandonedTasksCancelledCounter: prometheus.NewCounter(prometheus.CounterOpts{Name: "abandoned_tasks_cancelled"}),
+		scheduledTasksEnqueuedCounter:  prometheus.NewCounter(prometheus.CounterOpts{Name: "scheduled_tasks_enqueued"}),
+		webhooksDeliveredCounter:       prometheus.NewCounter(prometheus.CounterOpts{Name: "webhooks_delivered_total"}),
+		webhookAttemptsFailedCounter:   prometheus.NewCounter(prometheus.CounterOpts{Name: "webhook_attempts_failed_total"}),
+		webhooksFailedCounter:          prometheus.NewCounter(prometheus.CounterOpts{Name: "webhooks_failed_total"}),
+		webhookRetryPolicy: &domain.RetryPolicy{
+			MaxAttempts: 10,
+			BaseDelay:   10 * time.Second,
+			Multiplier:  2,
+			MaxDelay:    time.Hour,
+			Jitter:      0.1,
+		},
 	}
 	for _, opt := range opts {
 		opt(svc)
@@ -44,6 +70,225 @@ func (svc *Supervisor) DeleteStaleTasks(ctx context.Context, staleHours int) err
 			log.WithFields(log.Fields{
 				"rows": rows,
 			}).Debug("supervisor_delete_stale_rows")
+			rows, err = svc.taskGateway.DeleteStaleWebhooks(ctx, staleHours)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("supervisor_delete_stale_webhooks_failure")
+				continue
+			}
+			log.WithFields(log.Fields{
+				"rows": rows,
+			}).Debug("supervisor_delete_stale_webhook_rows")
+		}
+	}
+}
+
+// MoveExhaustedTasks moves tasks without attempts left to dead letters.
+// Failures are logged, so the supervisor keeps working, while the database is unavailable.
+func (svc *Supervisor) MoveExhaustedTasks(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(10 * time.Second):
+			rows, err := svc.taskGateway.MoveExhaustedTasks(ctx)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("supervisor_move_exhausted_tasks_failure")
+				// Transient failures are retried on the next tick.
+				continue
+			}
+			svc.exhaustedTasksMovedCounter.Add(float64(rows))
+			log.WithFields(log.Fields{
+				"rows": rows,
+			}).Debug("supervisor_move_exhausted_rows")
+		}
+	}
+}
+
+// ExpireOverdueTasks marks unfinished tasks after their deadline as expired.
+func (svc *Supervisor) ExpireOverdueTasks(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(10 * time.Second):
+			rows, err := svc.taskGateway.ExpireOverdueTasks(ctx)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("supervisor_expire_overdue_tasks_failure")
+				continue
+			}
+			svc.overdueTasksExpiredCounter.Add(float64(rows))
+			log.WithFields(log.Fields{
+				"rows": rows,
+			}).Debug("supervisor_expire_overdue_rows")
+		}
+	}
+}
+
+// CancelAbandonedTasks finishes cancellation of tasks, that were abandoned by workers.
+func (svc *Supervisor) CancelAbandonedTasks(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(10 * time.Second):
+			rows, err := svc.taskGateway.CancelAbandonedTasks(ctx)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("supervisor_cancel_abandoned_tasks_failure")
+				continue
+			}
+			svc.abandonedTasksCancelledCounter.Add(float64(rows))
+			log.WithFields(log.Fields{
+				"rows": rows,
+			}).Debug("supervisor_cancel_abandoned_rows")
 		}
 	}
 }
+
+// MaterialiseSchedules enqueues tasks for due schedule ticks.
+// Ticks are enqueued a bit ahead of time, so tasks are executed on time.
+func (svc *Supervisor) MaterialiseSchedules(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(10 * time.Second):
+			rows, err := svc.materialiseSchedules(ctx, time.Now())
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("supervisor_materialise_schedules_failure")
+				continue
+			}
+			svc.scheduledTasksEnqueuedCounter.Add(float64(rows))
+			log.WithFields(log.Fields{
+				"rows": rows,
+			}).Debug("supervisor_materialise_schedules_rows")
+		}
+	}
+}
+
+func (svc *Supervisor) materialiseSchedules(ctx context.Context, now time.Time) (int64, error) {
+	until := now.Add(10 * time.Second)
+	schedules, err := svc.taskGateway.FindDueSchedules(ctx, until, 100)
+	if err != nil {
+		return 0, err
+	}
+	var created int64
+	for _, schedule := range schedules {
+		rows, err := svc.materialiseSchedule(ctx, schedule, until, now)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":        err,
+				"scheduleId": schedule.ID,
+			}).Error("supervisor_schedule_advance_failure")
+			continue
+		}
+		created += rows
+	}
+	return created, nil
+}
+
+// materialiseSchedule enqueues tasks for due ticks of a schedule.
+// A schedule, that can't be planned, is postponed, so it doesn't take a place of due ones.
+func (svc *Supervisor) materialiseSchedule(ctx context.Context, schedule *domain.Schedule, until, now time.Time) (int64, error) {
+	parsed, err := parseSchedule(schedule)
+	var (
+		ticks []time.Time
+		next  time.Time
+	)
+	if err == nil {
+		ticks, next, err = planTicks(parsed, until, now.Add(-time.Minute))
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":        err,
+			"scheduleId": schedule.ID,
+		}).Error("supervisor_schedule_plan_failure")
+		_, err = svc.taskGateway.AdvanceSchedule(ctx, schedule.ID, schedule.NextRunAt, now.Add(replanDelay), nil)
+		return 0, err
+	}
+	tasks := make([]*domain.Task, 0, len(ticks))
+	for _, tick := range ticks {
+		tasks = append(tasks, scheduledTask(schedule, tick))
+	}
+	return svc.taskGateway.AdvanceSchedule(ctx, schedule.ID, schedule.NextRunAt, next, tasks)
+}
+
+// DeliverWebhooks sends finished tasks to their callback URLs.
+// Failed deliveries are retried with backoff, until attempts are exhausted.
+func (svc *Supervisor) DeliverWebhooks(ctx context.Context) error {
+	if svc.webhookSender == nil {
+		<-ctx.Done()
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+			rows, err := svc.deliverWebhooks(ctx)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Error("supervisor_deliver_webhooks_failure")
+				continue
+			}
+			log.WithFields(log.Fields{
+				"rows": rows,
+			}).Debug("supervisor_deliver_webhooks_rows")
+		}
+	}
+}
+
+func (svc *Supervisor) deliverWebhooks(ctx context.Context) (int, error) {
+	// Lease should outlast a delivery attempt, otherwise webhook is sent twice.
+	webhooks, err := svc.taskGateway.ClaimWebhooks(ctx, 100, time.Minute)
+	if err != nil {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	for _, webhook := range webhooks {
+		wg.Add(1)
+		go func(webhook *domain.Webhook) {
+			defer wg.Done()
+			err := svc.deliverWebhook(ctx, webhook)
+			if err != nil {
+				// Webhook is claimed again, when its lease expires.
+				log.WithFields(log.Fields{
+					"err":    err,
+					"taskId": webhook.TaskID,
+				}).Error("supervisor_webhook_update_failure")
+			}
+		}(webhook)
+	}
+	wg.Wait()
+	return len(webhooks), nil
+}
+
+func (svc *Supervisor) deliverWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	sendErr := svc.webhookSender.Send(ctx, webhook)
+	if sendErr == nil {
+		svc.webhooksDeliveredCounter.Inc()
+		return svc.taskGateway.MarkWebhookDelivered(ctx, webhook.ID)
+	}
+	svc.webhookAttemptsFailedCounter.Inc()
+	log.WithFields(log.Fields{
+		"err":      sendErr,
+		"taskId":   webhook.TaskID,
+		"attempts": webhook.Attempts,
+	}).Warning("supervisor_webhook_attempt_failure")
+	if svc.webhookRetryPolicy.Exhausted(webhook.Attempts) {
+		svc.webhooksFailedCounter.Inc()
+		return svc.taskGateway.MarkWebhookFailed(ctx, webhook.ID, sendErr.Error())
+	}
+	retryAt := time.Now().Add(svc.webhookRetryPolicy.Backoff(webhook.Attempts))
+	return svc.taskGateway.RetryWebhook(ctx, webhook.ID, sendErr.Error(), retryAt)
+}
@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	domain "github.com/freundallein/scheduler/pkg"
+	"github.com/freundallein/scheduler/pkg/mock"
+	"github.com/google/uuid"
+)
+
+type senderFunc func(ctx context.Context, webhook *domain.Webhook) error
+
+func (f senderFunc) Send(ctx context.Context, webhook *domain.Webhook) error {
+	return f(ctx, webhook)
+}
+
+func TestDeliverWebhooks(t *testing.T) {
+	tests := []struct {
+		name          string
+		attempts      int
+		sendErr       error
+		expectedState domain.WebhookState
+	}{
+		{
+			name:          "delivered",
+			attempts:      1,
+			expectedState: domain.WebhookDelivered,
+		},
+		{
+			name:          "retried",
+			attempts:      1,
+			sendErr:       errors.New("unexpected status code 503"),
+			expectedState: domain.WebhookPending,
+		},
+		{
+			name:          "exhausted",
+			attempts:      10,
+			sendErr:       errors.New("unexpected status code 503"),
+			expectedState: domain.WebhookFailed,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			webhook := &domain.Webhook{
+				ID:       1,
+				TaskID:   uuid.New(),
+				URL:      "http://example.com/callback",
+				State:    domain.WebhookPending,
+				Attempts: tc.attempts,
+			}
+			var retryAt time.Time
+			supervisor := NewSupervisor(
+				&mock.Gateway{
+					ClaimWebhooksFn: func(limit int, lease time.Duration) ([]*domain.Webhook, error) {
+						return []*domain.Webhook{webhook}, nil
+					},
+					MarkWebhookDeliveredFn: func(id int64) error {
+						webhook.State = domain.WebhookDelivered
+						return nil
+					},
+					RetryWebhookFn: func(id int64, reason string, at time.Time) error {
+						webhook.LastError = reason
+						retryAt = at
+						return nil
+					},
+					MarkWebhookFailedFn: func(id int64, reason string) error {
+						webhook.State = domain.WebhookFailed
+						webhook.LastError = reason
+						return nil
+					},
+				},
+				WithWebhookSender(senderFunc(func(ctx context.Context, webhook *domain.Webhook) error {
+					return tc.sendErr
+				})),
+			)
+			rows, err := supervisor.deliverWebhooks(context.Background())
+			if err != nil {
+				t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+			}
+			if rows != 1 {
+				t.Errorf("Expected `%v`, got: `%v`", 1, rows)
+			}
+			if webhook.State != tc.expectedState {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expectedState, webhook.State)
+			}
+			if tc.sendErr != nil && webhook.LastError != tc.sendErr.Error() {
+				t.Errorf("Expected `%v`, got: `%v`", tc.sendErr, webhook.LastError)
+			}
+			if tc.expectedState == domain.WebhookPending && !retryAt.After(time.Now()) {
+				t.Errorf("Expected retry in the future, got: `%v`", retryAt)
+			}
+		})
+	}
+}
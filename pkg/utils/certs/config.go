@@ -0,0 +1,23 @@
+package certs
+
+import "time"
+
+// Option is used to configure Reloader.
+type Option func(r *Reloader)
+
+// WithClientCA makes Reloader keep CAs of client certificates from the PEM file.
+func WithClientCA(path string) Option {
+	return func(r *Reloader) {
+		r.clientCAPath = path
+	}
+}
+
+// WithReloadInterval configures how often files are checked for changes.
+// Non-positive interval is ignored.
+func WithReloadInterval(interval time.Duration) Option {
+	return func(r *Reloader) {
+		if interval > 0 {
+			r.reloadInterval = interval
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/freundallein/scheduler/pkg/utils/logging"
+)
+
+// Reloader keeps a certificate and optional client CAs loaded from PEM files.
+// Files are reloaded, when they change, so certificates are rotated without restarts.
+type Reloader struct {
+	certPath       string
+	keyPath        string
+	clientCAPath   string
+	reloadInterval time.Duration
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	modTimes    []time.Time
+}
+
+// New returns an instance of Reloader, files should be valid.
+func New(certPath, keyPath string, opts ...Option) (*Reloader, error) {
+	reloader := &Reloader{
+		certPath:       certPath,
+		keyPath:        keyPath,
+		reloadInterval: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(reloader)
+	}
+	_, err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Run reloads files on changes until the context is cancelled.
+// Invalid files are skipped and the last valid certificate is kept.
+func (r *Reloader) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		reloaded, err := r.reload()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path": r.certPath,
+				"err":  err,
+			}).Error("certificate_reload_failed")
+			continue
+		}
+		if reloaded {
+			log.WithFields(log.Fields{
+				"path": r.certPath,
+			}).Info("certificate_reloaded")
+		}
+	}
+}
+
+// reload reads the files, if any of them was modified since the last read.
+func (r *Reloader) reload() (bool, error) {
+	paths := []string{r.certPath, r.keyPath}
+	if r.clientCAPath != "" {
+		paths = append(paths, r.clientCAPath)
+	}
+	modTimes := make([]time.Time, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	r.mu.RLock()
+	changed := len(r.modTimes) != len(modTimes)
+	for i := 0; !changed && i < len(modTimes); i++ {
+		changed = !modTimes[i].Equal(r.modTimes[i])
+	}
+	r.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return false, err
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAPath != "" {
+		data, err := ioutil.ReadFile(r.clientCAPath)
+		if err != nil {
+			return false, err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return false, fmt.Errorf("%s has no PEM certificates", r.clientCAPath)
+		}
+	}
+	r.mu.Lock()
+	r.certificate = &certificate
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Certificate returns the current certificate, it's used as tls.Config.GetCertificate.
+func (r *Reloader) Certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certificate, nil
+}
+
+// ClientCertificate returns the current certificate, it's used as tls.Config.GetClientCertificate.
+func (r *Reloader) ClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certificate, nil
+}
+
+// ServerConfig returns a server config with the current certificate.
+// With client CAs, clients are asked for certificates, which are verified, if they are given.
+func (r *Reloader) ServerConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.Certificate,
+	}
+	if r.clientCAPath == "" {
+		return config
+	}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		clientCAs := r.clientCAs
+		r.mu.RUnlock()
+		handshake := config.Clone()
+		handshake.GetConfigForClient = nil
+		handshake.ClientAuth = tls.VerifyClientCertIfGiven
+		handshake.ClientCAs = clientCAs
+		return handshake, nil
+	}
+	return config
+}
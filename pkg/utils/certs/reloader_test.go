@@ -0,0 +1,150 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/freundallein/scheduler/pkg/utils/certs/certstest"
+)
+
+// writeFile writes a file with the modification time, so a change is noticed regardless of timestamp precision.
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	err := ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+}
+
+// serveTLS serves the handler over TLS the same way as API does, returns the server's URL.
+// httptest.Server isn't used, because it adds its own certificate to the config.
+func serveTLS(t *testing.T, config *tls.Config, handler http.Handler) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	server := &http.Server{Handler: handler, TLSConfig: config}
+	go server.ServeTLS(listener, "", "")
+	t.Cleanup(func() {
+		server.Close()
+	})
+	return "https://" + listener.Addr().String()
+}
+
+// serverSerial connects to the server with a new connection and returns its certificate's serial number.
+func serverSerial(t *testing.T, url string, roots *x509.CertPool) int64 {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	response, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	response.Body.Close()
+	return response.TLS.PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestReload(t *testing.T) {
+	ca := certstest.NewCA(t)
+	roots := ca.Pool()
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	modTime := time.Now().Add(-time.Hour)
+	certPEM, keyPEM := ca.Issue(t, "scheduler", 10)
+	writeFile(t, certPath, certPEM, modTime)
+	writeFile(t, keyPath, keyPEM, modTime)
+	reloader, err := New(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	url := serveTLS(t, reloader.ServerConfig(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if serial := serverSerial(t, url, roots); serial != 10 {
+		t.Errorf("Expected `%v`, got: `%v`", 10, serial)
+	}
+
+	modTime = modTime.Add(time.Minute)
+	certPEM, keyPEM = ca.Issue(t, "scheduler", 20)
+	writeFile(t, certPath, certPEM, modTime)
+	writeFile(t, keyPath, keyPEM, modTime)
+	reloaded, err := reloader.reload()
+	if !reloaded || err != nil {
+		t.Fatalf("Expected files to be reloaded, got: `%v`, `%v`", reloaded, err)
+	}
+	if serial := serverSerial(t, url, roots); serial != 20 {
+		t.Errorf("Expected `%v`, got: `%v`", 20, serial)
+	}
+
+	modTime = modTime.Add(time.Minute)
+	writeFile(t, keyPath, []byte("not a key"), modTime)
+	reloaded, err = reloader.reload()
+	if reloaded || err == nil {
+		t.Errorf("Expected invalid key to be rejected, got: `%v`, `%v`", reloaded, err)
+	}
+	if serial := serverSerial(t, url, roots); serial != 20 {
+		t.Errorf("Expected the last valid certificate `%v`, got: `%v`", 20, serial)
+	}
+}
+
+func TestServerConfigClientCA(t *testing.T) {
+	ca := certstest.NewCA(t)
+	roots := ca.Pool()
+	dir := t.TempDir()
+	certPath, keyPath, caPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	certPEM, keyPEM := ca.Issue(t, "scheduler", 10)
+	writeFile(t, certPath, certPEM, time.Now())
+	writeFile(t, keyPath, keyPEM, time.Now())
+	writeFile(t, caPath, ca.PEM(), time.Now())
+	reloader, err := New(certPath, keyPath, WithClientCA(caPath))
+	if err != nil {
+		t.Fatalf("Expected `%v`, got: `%v`", nil, err)
+	}
+	url := serveTLS(t, reloader.ServerConfig(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.VerifiedChains) > 0 {
+			w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+		}
+	}))
+
+	trusted := ca.Certificate(t, "worker", 30)
+	foreign := certstest.NewCA(t).Certificate(t, "worker", 40)
+	tests := []struct {
+		name         string
+		certificates []tls.Certificate
+		expected     string
+		expectedErr  bool
+	}{
+		{name: "trusted certificate", certificates: []tls.Certificate{trusted}, expected: "worker"},
+		{name: "without certificate"},
+		{name: "foreign certificate", certificates: []tls.Certificate{foreign}, expectedErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				Certificates: tc.certificates,
+			}}}
+			response, err := client.Get(url)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("Expected error: `%v`, got: `%v`", tc.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			defer response.Body.Close()
+			body, _ := ioutil.ReadAll(response.Body)
+			if string(body) != tc.expected {
+				t.Errorf("Expected `%v`, got: `%v`", tc.expected, string(body))
+			}
+		})
+	}
+}
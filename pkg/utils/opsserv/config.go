@@ -1,5 +1,7 @@
 package opsserv
 
+import "crypto/tls"
+
 // Option is used to configure Service.
 type Option func(service *Service)
 
@@ -9,3 +11,11 @@ func WithPort(port string) Option {
 		s.Port = port
 	}
 }
+
+// WithTLSConfig makes Service serve TLS, the config should provide certificates,
+// e.g. with GetCertificate to reload them.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Service) {
+		s.tlsConfig = config
+	}
+}
@@ -2,6 +2,7 @@ package opsserv
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"time"
@@ -12,8 +13,9 @@ import (
 
 // Service used as an endpoint for operations management.
 type Service struct {
-	httpserv *http.Server
-	Port     string
+	httpserv  *http.Server
+	Port      string
+	tlsConfig *tls.Config
 }
 
 // New returns service instance.
@@ -46,11 +48,16 @@ func New(opts ...Option) *Service {
 	return svc
 }
 
-// Run starts the ops http server.
+// Run starts the ops http server, it's served over TLS, if TLS config is provided.
 func (svc *Service) Run(ctx context.Context) error {
 	log.WithFields(log.Fields{
 		"addr": svc.httpserv.Addr,
+		"tls":  svc.tlsConfig != nil,
 	}).Info("ops_svc_starting")
+	if svc.tlsConfig != nil {
+		svc.httpserv.TLSConfig = svc.tlsConfig
+		return svc.httpserv.ListenAndServeTLS("", "")
+	}
 	return svc.httpserv.ListenAndServe()
 }
 